@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/database"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+)
+
+func init() {
+	utils.LoadEnv()
+}
+
+// reconcile compares MinIO with the database and prints the issues found as JSON.
+// With -repair, the issues are repaired as well. It exits with status 1 if issues
+// were found and left unrepaired.
+func main() {
+	repair := flag.Bool("repair", false, "repair the issues found instead of only reporting them")
+	tempDir := flag.String("temp-dir", services.DEFAULT_TEMP_DIR, "directory the thumbnail and HLS jobs write their temporary files to")
+	flag.Parse()
+
+	db, err := database.ConnectToDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	minioClient, err := database.ConnectToMinIO()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reconcileService := services.NewReconcileService(db.GetDB(), minioClient.GetMinioClient())
+	reconcileService.TempDir = *tempDir
+
+	report, err := reconcileService.Reconcile(*repair)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+
+	if !*repair && report.IssueCount() > 0 {
+		os.Exit(1)
+	}
+}
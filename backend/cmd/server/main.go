@@ -1,9 +1,7 @@
 package main
 
 import (
-	"fmt"
 	"log"
-	"os"
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/routes"
@@ -11,6 +9,7 @@ import (
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/database/migrations"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/mailer"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -21,13 +20,6 @@ func init() {
 	utils.LoadEnv()
 }
 
-func isDatabaseMigrated(db database.Database) bool {
-	var result []string
-	rawSQL := fmt.Sprintf("SHOW TABLES FROM %s;", os.Getenv("DB_NAME"))
-	db.GetDB().Raw(rawSQL).Scan(&result)
-	return len(result) > 0
-}
-
 func main() {
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
@@ -42,7 +34,7 @@ func main() {
 	}
 
 	// Check if the database is migrated
-	if !isDatabaseMigrated(db) {
+	if !migrations.IsMigrated(db) {
 		if err := migrations.Migrate(db); err != nil {
 			log.Fatal(err)
 		}
@@ -61,6 +53,10 @@ func main() {
 		return
 	}
 
+	if err := services.EnsureSharedBucket(minioClient.GetMinioClient()); err != nil {
+		log.Fatal(err)
+	}
+
 	// Allow cors
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowHeaders = []string{"*"}
@@ -79,21 +75,85 @@ func main() {
 	folderService := services.NewFolderService(db.GetDB())
 	folderHandler := handlers.NewFolderHandler(folderService)
 
+	authService := services.NewAuthService(db.GetDB())
+	sessionService := services.NewSessionService(db.GetDB())
+	tokenService := services.NewTokenService(db.GetDB())
+	authHandler := handlers.NewAuthHandler(authService, sessionService, tokenService)
+
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	twoFactorService := services.NewTwoFactorService(db.GetDB())
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService)
+
+	webAuthnService := services.NewWebAuthnService(db.GetDB())
+	webAuthnHandler := handlers.NewWebAuthnHandler(webAuthnService, twoFactorService, authService)
+
+	personalAccessTokenService := services.NewPersonalAccessTokenService(db.GetDB())
+	personalAccessTokenHandler := handlers.NewPersonalAccessTokenHandler(personalAccessTokenService)
+
 	userService := services.NewUserService(db.GetDB(), minioClient.GetMinioClient())
-	userHandler := handlers.NewUserHandler(userService)
+	userService.Mailer = mail
+	userHandler := handlers.NewUserHandler(userService, sessionService, tokenService)
 
-	authService := services.NewAuthService(db.GetDB())
-	authHandler := handlers.NewAuthHandler(authService)
+	authService.Authenticators, err = services.AuthenticatorsFromEnv(db.GetDB(), userService)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oidcService := services.NewOIDCService(db.GetDB(), userService)
+	oidcHandler := handlers.NewOIDCHandler(oidcService, authService)
 
 	hlsService := services.NewHLSService(db.GetDB(), nil)
 	hlsHandler := handlers.NewHLSHandler(hlsService)
 
+	searchService := services.NewSearchService(db.GetDB())
+	searchHandler := handlers.NewSearchHandler(searchService)
+
+	smartFolderService := services.NewSmartFolderService(db.GetDB())
+	smartFolderHandler := handlers.NewSmartFolderHandler(smartFolderService)
+
+	fileVersionService := services.NewFileVersionService(db.GetDB(), nil)
+	fileVersionHandler := handlers.NewFileVersionHandler(fileVersionService)
+
+	quotaService := services.NewQuotaService(db.GetDB())
+	quotaHandler := handlers.NewQuotaHandler(quotaService)
+
+	duplicateService := services.NewDuplicateService(db.GetDB(), nil)
+	duplicateHandler := handlers.NewDuplicateHandler(duplicateService)
+
+	trashService := services.NewTrashService(db.GetDB(), nil)
+	trashHandler := handlers.NewTrashHandler(trashService)
+
+	copyService := services.NewCopyService(db.GetDB(), nil)
+	copyHandler := handlers.NewCopyHandler(copyService)
+
+	batchService := services.NewBatchService(db.GetDB(), nil)
+	batchHandler := handlers.NewBatchHandler(batchService)
+	if err := batchService.FailInterruptedJobs(); err != nil {
+		log.Println(err)
+	}
+
 	routes.AuthRoutes(api, authHandler)
+	routes.TwoFactorRoutes(api, twoFactorHandler)
+	routes.WebAuthnRoutes(api, webAuthnHandler)
+	routes.PersonalAccessTokenRoutes(api, personalAccessTokenHandler)
+	routes.OIDCRoutes(api, oidcHandler)
 	routes.TokenRoutes(api)
 	routes.UserRoutes(api, userHandler)
 	routes.FileRoutes(api, fileHandler, minioClient.GetMinioClient())
 	routes.FolderRoutes(api, folderHandler, minioClient.GetMinioClient())
 	routes.HLSRoutes(api, hlsHandler, minioClient.GetMinioClient())
+	routes.SearchRoutes(api, searchHandler)
+	routes.SmartFolderRoutes(api, smartFolderHandler)
+	routes.FileVersionRoutes(api, fileVersionHandler, minioClient.GetMinioClient())
+	routes.QuotaRoutes(api, quotaHandler)
+	routes.DuplicateRoutes(api, duplicateHandler, minioClient.GetMinioClient())
+	routes.TrashRoutes(api, trashHandler, minioClient.GetMinioClient())
+	routes.CopyRoutes(api, copyHandler, minioClient.GetMinioClient())
+	routes.BatchRoutes(api, batchHandler, minioClient.GetMinioClient())
 
 	// Schedule revoked tokens ('tokens' table in database) pruning
 	c := cron.New()
@@ -101,12 +161,47 @@ func main() {
 
 	_, err = c.AddFunc(cronSpec, func() {
 		utils.PruneRevokedTokens(db.GetDB())
+		services.PruneRevocationCache()
+		sessionService.PruneExpiredSessions()
+		services.RemoveReleasedObjects(db.GetDB(), minioClient.GetMinioClient())
+	})
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Schedule purging of the trashed items past their owner's retention
+	_, err = c.AddFunc(services.TrashPurgeCronSpec(), func() {
+		services.NewTrashService(db.GetDB(), nil).PurgeExpiredTrash(minioClient.GetMinioClient())
 	})
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Schedule erasure of the deleted accounts past their grace period
+	_, err = c.AddFunc(services.AccountPurgeCronSpec(), userService.PurgeDeletedUsers)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Schedule integrity checks of the stored objects
+	scrubService := services.NewScrubService(db.GetDB(), minioClient.GetMinioClient())
+	_, err = c.AddFunc(services.ScrubCronSpec(), scrubService.Scrub)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Schedule reconciliation of MinIO with the database
+	reconcileService := services.NewReconcileService(db.GetDB(), minioClient.GetMinioClient())
+	_, err = c.AddFunc(services.ReconcileCronSpec(), reconcileService.ReconcileJob)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	c.Start()
 
 	r.Run(":3000")
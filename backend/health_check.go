@@ -27,7 +27,7 @@ func main() {
 	}
 
 
-	var tables = []interface{}{models.User{}, models.Token{}, models.Folder{}, models.File{}, models.Thumbnail{}}
+	var tables = []interface{}{models.User{}, models.Token{}, models.Folder{}, models.File{}, models.Thumbnail{}, models.FileContent{}, models.Tag{}, models.SmartFolder{}, models.FileVersion{}, models.StoredObject{}, models.BatchJob{}, models.FolderClosure{}, models.Session{}, models.RefreshToken{}, models.TwoFactor{}, models.RecoveryCode{}, models.WebAuthnCredential{}, models.PersonalAccessToken{}, models.UserIdentity{}, models.ReleasedObject{}, models.DuplicateScan{}, models.UsedToken{}, models.SchemaVersion{}}
 	for _, table := range tables {
 		if !db.GetDB().Migrator().HasTable(table) {
 			log.Fatal(table)
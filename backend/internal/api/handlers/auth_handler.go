@@ -2,20 +2,93 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 )
 
+const (
+	REFRESH_TOKEN_COOKIE   = "refresh_token"
+	CHALLENGE_TOKEN_COOKIE = "challenge_token"
+)
+
 type AuthHandler struct {
-	AuthService *services.AuthService
+	AuthService    *services.AuthService
+	SessionService *services.SessionService
+	TokenService   *services.TokenService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, sessionService *services.SessionService, tokenService *services.TokenService) *AuthHandler {
 	return &AuthHandler{
-		AuthService: authService,
+		AuthService:    authService,
+		SessionService: sessionService,
+		TokenService:   tokenService,
+	}
+}
+
+// setAuthCookies stores the tokens of a login or a refresh in cookies. The refresh token is only
+// sent back to the auth endpoints.
+func setAuthCookies(c *gin.Context, tokens *models.AuthTokens) {
+	c.SetCookie("token", tokens.AccessToken, int(tokens.ExpiresIn), "/", c.Request.Host, false, true)
+	c.SetCookie(REFRESH_TOKEN_COOKIE, tokens.RefreshToken, int(services.RefreshTokenTTL().Seconds()), "/api/auth", c.Request.Host, false, true)
+	c.SetCookie(CHALLENGE_TOKEN_COOKIE, "", -1, "/api/auth", c.Request.Host, false, true)
+}
+
+// setChallengeCookie stores the challenge token of a login with a provider, which ends with a redirect:
+// in the URL, the token would be kept in the browser history and the logs of proxies.
+func setChallengeCookie(c *gin.Context, challengeToken string) {
+	c.SetCookie(CHALLENGE_TOKEN_COOKIE, challengeToken, int(services.TWO_FACTOR_CHALLENGE_TTL.Seconds()), "/api/auth", c.Request.Host, false, true)
+}
+
+// challengeTokenFromRequest returns the challenge token of the body, or else the one of its cookie.
+func challengeTokenFromRequest(c *gin.Context, bodyToken string) string {
+	if bodyToken != "" {
+		return bodyToken
+	}
+
+	challengeToken, _ := c.Cookie(CHALLENGE_TOKEN_COOKIE)
+	return challengeToken
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie("token", "", -1, "/", c.Request.Host, false, true)
+	c.SetCookie(REFRESH_TOKEN_COOKIE, "", -1, "/api/auth", c.Request.Host, false, true)
+}
+
+// refreshTokenFromRequest reads the refresh token from the JSON body, or from its cookie when
+// there is no body.
+func refreshTokenFromRequest(c *gin.Context) string {
+	var body models.RefreshBody
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err == nil && body.RefreshToken != "" {
+			return body.RefreshToken
+		}
+	}
+
+	refreshToken, _ := c.Cookie(REFRESH_TOKEN_COOKIE)
+	return refreshToken
+}
+
+// respondSessionError writes the response matching a SessionService error.
+func respondSessionError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.InvalidCredentialsError:
+		clearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
 	}
 }
 
@@ -51,7 +124,7 @@ func (ah *AuthHandler) UserLogin(c *gin.Context) {
 		return
 	}
 
-	accessToken, err := ah.AuthService.Login(loginBody.Email, loginBody.Password)
+	tokens, err := ah.AuthService.Login(loginBody.Email, loginBody.Password, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		switch e := err.(type) {
 			case *apperr.NotFoundError:
@@ -62,20 +135,141 @@ func (ah *AuthHandler) UserLogin(c *gin.Context) {
 			case *apperr.InvalidCredentialsError:
 				c.Status(http.StatusUnauthorized)
 				return
+			case *apperr.ForbiddenError:
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": e.Error(),
+				})
+				return
+			case *apperr.ConflictError:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": e.Error(),
+				})
+				return
 			case *apperr.ServerError:
 				c.Status(http.StatusInternalServerError)
 				return
+			default:
+				c.Status(http.StatusInternalServerError)
+				return
 		}
 	}
 
-	c.SetCookie("token", accessToken, 60*60, "/", c.Request.Host, false, true)
+	// No cookies until the second factor is checked
+	if tokens.TwoFactorRequired {
+		c.JSON(http.StatusOK, tokens)
+		return
+	}
+
+	setAuthCookies(c, tokens)
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": accessToken,
-	})
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ah *AuthHandler) UserLoginTwoFactor(c *gin.Context) {
+	var loginBody models.TwoFactorLoginBody
+	if !bindUserBody(c, &loginBody) {
+		return
+	}
+
+	challengeToken := challengeTokenFromRequest(c, loginBody.ChallengeToken)
+	tokens, err := ah.AuthService.LoginWithTwoFactor(challengeToken, loginBody.Code, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	setAuthCookies(c, tokens)
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ah *AuthHandler) TokenRefresh(c *gin.Context) {
+	refreshToken := refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "No refresh token provided.",
+		})
+		return
+	}
+
+	tokens, err := ah.SessionService.Refresh(refreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	setAuthCookies(c, tokens)
+
+	c.JSON(http.StatusOK, tokens)
 }
 
 func (ah *AuthHandler) UserLogout(c *gin.Context) {
-	c.SetCookie("token", "", -1, "/", c.Request.Host, false, true)
+	// Expired or invalid access tokens are left alone, they don't work anyway
+	if userClaims, err := utils.ParseToken(middlewares.RequestToken(c)); err == nil && userClaims.Id != "" {
+		if err := ah.TokenService.RevokeToken(userClaims); err != nil {
+			respondSessionError(c, err)
+			return
+		}
+	}
+
+	if refreshToken := refreshTokenFromRequest(c); refreshToken != "" {
+		if err := ah.SessionService.EndSession(refreshToken); err != nil {
+			respondSessionError(c, err)
+			return
+		}
+	}
+
+	clearAuthCookies(c)
+	c.Status(http.StatusOK)
+}
+
+func (ah *AuthHandler) SessionList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	sessions, err := ah.SessionService.ListSessions(userClaim.ID, userClaim.SessionID)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (ah *AuthHandler) SessionRevoke(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid session ID",
+		})
+		return
+	}
+
+	if err := ah.SessionService.RevokeSession(userClaim.ID, uint(sessionID)); err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	if uint(sessionID) == userClaim.SessionID {
+		clearAuthCookies(c)
+	}
+
 	c.Status(http.StatusOK)
+}
+
+func (ah *AuthHandler) SessionRevokeAll(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	revoked, err := ah.SessionService.RevokeAllSessions(userClaim.ID)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": revoked,
+	})
 }
\ No newline at end of file
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type BatchHandler struct {
+	BatchService *services.BatchService
+}
+
+func NewBatchHandler(batchService *services.BatchService) *BatchHandler {
+	return &BatchHandler{
+		BatchService: batchService,
+	}
+}
+
+func respondBatchError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidParamError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func (bh *BatchHandler) BatchCreate(c *gin.Context) {
+	validate := validator.New()
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var batchBody models.BatchBody
+	if err := c.BindJSON(&batchBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return
+	}
+
+	if err := validate.Struct(batchBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, job, err := bh.BatchService.RunBatch(userClaim.ID, batchBody)
+	if err != nil {
+		respondBatchError(c, err)
+		return
+	}
+
+	// Large batches run in the background, their progress is polled with the job code
+	if job != nil {
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (bh *BatchHandler) BatchJobDetail(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	job, err := bh.BatchService.GetBatchJob(userClaim.ID, c.Param("code"))
+	if err != nil {
+		respondBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
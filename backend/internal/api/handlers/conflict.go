@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// bindConflictPolicy reads the conflict policy of a request from the on_conflict query param.
+// Without one, the service applies the default policy of the operation.
+// It responds with 400 and returns false if it is invalid.
+func bindConflictPolicy(c *gin.Context) (string, bool) {
+	policy := c.Query("on_conflict")
+	if policy != "" && !models.IsConflictPolicy(policy) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "on_conflict must be one of fail, rename, overwrite or skip",
+		})
+		return "", false
+	}
+
+	return policy, true
+}
+
+// bindUploadConflictPolicy reads the conflict policy of an upload like bindConflictPolicy. Uploads
+// overwrite by default, replacing the content of the file they collide with, which an upload-only
+// token mustn't do: its uploads fail or are renamed, renamed by default.
+// It responds with 403 and returns false if an upload-only token asks for another policy.
+func bindUploadConflictPolicy(c *gin.Context) (string, bool) {
+	policy, ok := bindConflictPolicy(c)
+	if !ok {
+		return "", false
+	}
+
+	userClaims := c.MustGet("userClaims").(*utils.UserClaims)
+	if userClaims.Scope != models.TOKEN_SCOPE_UPLOAD {
+		return policy, true
+	}
+
+	switch policy {
+	case "":
+		return models.CONFLICT_RENAME, true
+	case models.CONFLICT_FAIL, models.CONFLICT_RENAME:
+		return policy, true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Upload-only tokens can only fail or rename on a conflict",
+	})
+	return "", false
+}
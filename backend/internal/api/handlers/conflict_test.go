@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindUploadConflictPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		scope      string
+		onConflict string
+		want       string
+		wantStatus int
+	}{
+		// Logins and full tokens keep the policy asked for, or the default of uploads
+		{"", "", "", http.StatusOK},
+		{"", models.CONFLICT_OVERWRITE, models.CONFLICT_OVERWRITE, http.StatusOK},
+		{models.TOKEN_SCOPE_FULL, models.CONFLICT_SKIP, models.CONFLICT_SKIP, http.StatusOK},
+
+		// Upload-only tokens can't replace files
+		{models.TOKEN_SCOPE_UPLOAD, "", models.CONFLICT_RENAME, http.StatusOK},
+		{models.TOKEN_SCOPE_UPLOAD, models.CONFLICT_FAIL, models.CONFLICT_FAIL, http.StatusOK},
+		{models.TOKEN_SCOPE_UPLOAD, models.CONFLICT_RENAME, models.CONFLICT_RENAME, http.StatusOK},
+		{models.TOKEN_SCOPE_UPLOAD, models.CONFLICT_OVERWRITE, "", http.StatusForbidden},
+		{models.TOKEN_SCOPE_UPLOAD, models.CONFLICT_SKIP, "", http.StatusForbidden},
+
+		{models.TOKEN_SCOPE_UPLOAD, "replace", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/folders/abc/files?on_conflict="+tt.onConflict, nil)
+		c.Set("userClaims", &utils.UserClaims{Scope: tt.scope})
+
+		policy, ok := bindUploadConflictPolicy(c)
+		if policy != tt.want || ok != (tt.wantStatus == http.StatusOK) || recorder.Code != tt.wantStatus {
+			t.Errorf("bindUploadConflictPolicy() with scope %q and on_conflict %q = %q, %v (%d), want %q (%d)",
+				tt.scope, tt.onConflict, policy, ok, recorder.Code, tt.want, tt.wantStatus)
+		}
+	}
+}
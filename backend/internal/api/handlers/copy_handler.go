@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type CopyHandler struct {
+	CopyService *services.CopyService
+}
+
+func NewCopyHandler(copyService *services.CopyService) *CopyHandler {
+	return &CopyHandler{
+		CopyService: copyService,
+	}
+}
+
+// respondCopyError writes the response matching a CopyService error.
+func respondCopyError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidParamError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.QuotaExceededError:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ConflictError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// bindCopyBody binds the optional body of a copy request, without one the copy
+// goes next to the original.
+func bindCopyBody(c *gin.Context) (models.CopyBody, bool) {
+	var copyBody models.CopyBody
+	if c.Request.ContentLength == 0 {
+		return copyBody, true
+	}
+
+	if err := c.BindJSON(&copyBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body (JSON).",
+		})
+		return copyBody, false
+	}
+
+	if err := validator.New().Struct(copyBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return copyBody, false
+	}
+
+	return copyBody, true
+}
+
+func (ch *CopyHandler) FileCopy(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	copyBody, ok := bindCopyBody(c)
+	if !ok {
+		return
+	}
+
+	onConflict, ok := bindConflictPolicy(c)
+	if !ok {
+		return
+	}
+
+	file, err := ch.CopyService.CopyFile(userClaim.ID, c.Param("fileCode"), copyBody, onConflict)
+	if err != nil {
+		respondCopyError(c, err)
+		return
+	}
+
+	// Skipped and overwritten files aren't new
+	if file.Skipped || file.Version > 1 {
+		c.JSON(http.StatusOK, file)
+		return
+	}
+
+	c.JSON(http.StatusCreated, file)
+}
+
+func (ch *CopyHandler) FolderCopy(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	copyBody, ok := bindCopyBody(c)
+	if !ok {
+		return
+	}
+
+	onConflict, ok := bindConflictPolicy(c)
+	if !ok {
+		return
+	}
+
+	folder, err := ch.CopyService.CopyFolder(userClaim.ID, c.Param("code"), copyBody, onConflict)
+	if err != nil {
+		respondCopyError(c, err)
+		return
+	}
+
+	if folder.Skipped {
+		c.JSON(http.StatusOK, folder)
+		return
+	}
+
+	c.JSON(http.StatusCreated, folder)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type DuplicateHandler struct {
+	DuplicateService *services.DuplicateService
+}
+
+func NewDuplicateHandler(duplicateService *services.DuplicateService) *DuplicateHandler {
+	return &DuplicateHandler{
+		DuplicateService: duplicateService,
+	}
+}
+
+func respondDuplicateError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.ResourceNotReadyError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func (dh *DuplicateHandler) DuplicateScan(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	validate := validator.New()
+
+	var duplicateQuery models.DuplicateQuery
+	if err := c.ShouldBindQuery(&duplicateQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := validate.Struct(duplicateQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	scan, err := dh.DuplicateService.StartScan(userClaim.ID, duplicateQuery)
+	if err != nil {
+		respondDuplicateError(c, err)
+		return
+	}
+
+	// Scans run in the background, their result is polled with GET /duplicates
+	c.JSON(http.StatusAccepted, scan)
+}
+
+func (dh *DuplicateHandler) DuplicateList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	validate := validator.New()
+
+	var duplicateQuery models.DuplicateQuery
+	if err := c.ShouldBindQuery(&duplicateQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := validate.Struct(duplicateQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	list, err := dh.DuplicateService.ListDuplicates(userClaim.ID, duplicateQuery)
+	if err != nil {
+		respondDuplicateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (dh *DuplicateHandler) DuplicateResolve(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	validate := validator.New()
+
+	var resolveBody models.DuplicateResolveBody
+	if err := c.BindJSON(&resolveBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return
+	}
+
+	if err := validate.Struct(resolveBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	trashed, err := dh.DuplicateService.ResolveDuplicates(userClaim.ID, resolveBody)
+	if err != nil {
+		respondDuplicateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trashed": trashed,
+	})
+}
@@ -28,24 +28,75 @@ func NewFileHandler(fileService *services.FileService) *FileHandler {
 func (h *FileHandler) FileFavorites(c *gin.Context) {
 	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
 
-	files, err := h.FileService.ListFavoriteFiles(userClaim.ID)
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
+	params, ok := bindListParams(c)
+	if !ok {
 		return
 	}
 
+	files, err := h.FileService.ListFavoriteFiles(userClaim.ID, params)
+	if err != nil {
+		switch e := err.(type) {
+		case *apperr.InvalidParamError:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": e.Error(),
+			})
+			return
+		default:
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, files)
 }
 
 func (h *FileHandler) FileTrashCan(c *gin.Context) {
 	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
 
-	files, err := h.FileService.ListTrashCanFiles(userClaim.ID)
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	files, err := h.FileService.ListTrashCanFiles(userClaim.ID, params)
 	if err != nil {
-		c.Status(http.StatusInternalServerError)
+		switch e := err.(type) {
+		case *apperr.InvalidParamError:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": e.Error(),
+			})
+			return
+		default:
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, files)
+}
+
+func (h *FileHandler) FileCorrupted(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	params, ok := bindListParams(c)
+	if !ok {
 		return
 	}
 
+	files, err := h.FileService.ListCorruptedFiles(userClaim.ID, params)
+	if err != nil {
+		switch e := err.(type) {
+		case *apperr.InvalidParamError:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": e.Error(),
+			})
+			return
+		default:
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, files)
 }
 
@@ -137,7 +188,12 @@ func (fh *FileHandler) FileUpdate(c *gin.Context) {
 					"error": e.Error(),
 				})
 				return
-			case *apperr.ServerError:
+			case *apperr.ConflictError:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": e.Error(),
+				})
+				return
+			default:
 				c.Status(http.StatusInternalServerError)
 				return
 		}
@@ -177,7 +233,12 @@ func (fh *FileHandler) FilePatch(c *gin.Context) {
 		return
 	}
 
-	file, err := fh.FileService.PatchFile(userClaim.ID, uint(intFileID), fileUpdateBody)
+	onConflict, ok := bindConflictPolicy(c)
+	if !ok {
+		return
+	}
+
+	file, err := fh.FileService.PatchFile(userClaim.ID, uint(intFileID), fileUpdateBody, onConflict)
 	if err != nil {
 		switch e := err.(type) {
 			case *apperr.NotFoundError:
@@ -185,7 +246,17 @@ func (fh *FileHandler) FilePatch(c *gin.Context) {
 					"error": e.Error(),
 				})
 				return
-			case *apperr.ServerError:
+			case *apperr.InvalidParamError:
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": e.Error(),
+				})
+				return
+			case *apperr.ConflictError:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": e.Error(),
+				})
+				return
+			default:
 				c.Status(http.StatusInternalServerError)
 				return
 		}
@@ -269,3 +340,60 @@ func (h *FileHandler) FileThumbnail(c *gin.Context) {
 
 	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, thumbnail, nil)
 }
+
+func (fh *FileHandler) FileTags(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	fileID := c.Param("fileID")
+	validate := validator.New()
+
+	var fileTagsBody models.FileTagsBody
+	if err := c.BindJSON(&fileTagsBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return
+	}
+
+	if err := validate.Struct(fileTagsBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	intFileID, err := strconv.Atoi(fileID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid file ID",
+		})
+		return
+	}
+
+	file, err := fh.FileService.SetFileTags(userClaim.ID, uint(intFileID), fileTagsBody.Tags)
+	if err != nil {
+		switch e := err.(type) {
+		case *apperr.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": e.Error(),
+			})
+			return
+		default:
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, file)
+}
+
+func (fh *FileHandler) TagList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	tags, err := fh.FileService.ListTags(userClaim.ID)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type FileVersionHandler struct {
+	FileVersionService *services.FileVersionService
+}
+
+func NewFileVersionHandler(fileVersionService *services.FileVersionService) *FileVersionHandler {
+	return &FileVersionHandler{
+		FileVersionService: fileVersionService,
+	}
+}
+
+// respondFileVersionError writes the response matching a FileVersionService error.
+func respondFileVersionError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidParamError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.QuotaExceededError:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func bindVersionParam(c *gin.Context) (uint, bool) {
+	version, err := strconv.ParseUint(c.Param("version"), 10, 64)
+	if err != nil || version == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid version",
+		})
+		return 0, false
+	}
+
+	return uint(version), true
+}
+
+func (fvh *FileVersionHandler) FileVersionList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	versions, err := fvh.FileVersionService.ListFileVersions(userClaim.ID, c.Param("fileCode"))
+	if err != nil {
+		respondFileVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+func (fvh *FileVersionHandler) FileVersionDownload(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	version, ok := bindVersionParam(c)
+	if !ok {
+		return
+	}
+
+	presignedURL, err := fvh.FileVersionService.GetVersionPresignedURL(userClaim.ID, c.Param("fileCode"), version)
+	if err != nil {
+		respondFileVersionError(c, err)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(presignedURL)
+	proxy.Director = func(req *http.Request) {
+		req.Host = presignedURL.Host
+		req.URL.Scheme = presignedURL.Scheme
+		req.URL.Host = presignedURL.Host
+		req.URL.Path = presignedURL.Path
+		req.URL.RawQuery = presignedURL.RawQuery
+	}
+
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+func (fvh *FileVersionHandler) FileVersionRestore(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	version, ok := bindVersionParam(c)
+	if !ok {
+		return
+	}
+
+	file, err := fvh.FileVersionService.RestoreFileVersion(userClaim.ID, c.Param("fileCode"), version)
+	if err != nil {
+		respondFileVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, file)
+}
+
+func (fvh *FileVersionHandler) FileVersionPrune(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var pruneBody models.FileVersionPruneBody
+	if err := c.BindJSON(&pruneBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(pruneBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	pruned, err := fvh.FileVersionService.PruneFileVersions(userClaim.ID, c.Param("fileCode"), pruneBody)
+	if err != nil {
+		respondFileVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pruned": pruned,
+	})
+}
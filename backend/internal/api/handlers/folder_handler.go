@@ -39,7 +39,7 @@ func (fh *FolderHandler) FolderDetail(c *gin.Context) {
 					"error": e.Error(),
 				})
 				return
-			case *apperr.ServerError:
+			default:
 				c.Status(http.StatusInternalServerError)
 				return
 		}
@@ -52,18 +52,40 @@ func (fh *FolderHandler) FolderList(c *gin.Context) {
 	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
 	folderCode := c.Param("code")
 	
-	var folderResp *models.FolderResponse
-	var err error
-
-	// Dirty workaround but meh
-	if folderCode == "favorite" {
-		folderResp, err = fh.FolderService.ListFavoriteFolders(userClaim.ID) 
-	} else if folderCode == "trashcan" {
-		folderResp, err = fh.FolderService.ListTrashFolders(userClaim.ID)
-	} else {
-		folderResp, err = fh.FolderService.ListFolders(userClaim.ID, folderCode)
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	folderResp, err := fh.FolderService.ListFolders(userClaim.ID, folderCode, params)
+	respondFolderList(c, folderResp, err)
+}
+
+func (fh *FolderHandler) FolderFavorites(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	params, ok := bindListParams(c)
+	if !ok {
+		return
 	}
 
+	folderResp, err := fh.FolderService.ListFavoriteFolders(userClaim.ID, params)
+	respondFolderList(c, folderResp, err)
+}
+
+func (fh *FolderHandler) FolderTrashCan(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	folderResp, err := fh.FolderService.ListTrashFolders(userClaim.ID, params)
+	respondFolderList(c, folderResp, err)
+}
+
+func respondFolderList(c *gin.Context, folderResp *models.FolderResponse, err error) {
 	if err != nil {
 		switch e := err.(type) {
 			case *apperr.NotFoundError:
@@ -71,7 +93,12 @@ func (fh *FolderHandler) FolderList(c *gin.Context) {
 					"error": e.Error(),
 				})
 				return
-			case *apperr.ServerError:
+			case *apperr.InvalidParamError:
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": e.Error(),
+				})
+				return
+			default:
 				c.Status(http.StatusInternalServerError)
 				return
 		}
@@ -103,7 +130,12 @@ func (fh *FolderHandler) FolderCreate(c *gin.Context) {
 		return
 	}
 
-	newFolder, err := fh.FolderService.CreateFolder(folderBody.FolderName, parentFolderCode, userClaim.ID)
+	onConflict, ok := bindConflictPolicy(c)
+	if !ok {
+		return
+	}
+
+	newFolder, err := fh.FolderService.CreateFolder(folderBody.FolderName, parentFolderCode, userClaim.ID, onConflict)
 	if err != nil {
 		if errors.Is(err, &apperr.NotFoundError{}) {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -112,11 +144,24 @@ func (fh *FolderHandler) FolderCreate(c *gin.Context) {
 			return
 		}
 
+		if _, ok := err.(*apperr.ConflictError); ok {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		c.Status(http.StatusInternalServerError)
 		log.Println(err.Error())
 		return
 	}
 
+	// A skipped folder already existed
+	if newFolder.Skipped {
+		c.JSON(http.StatusOK, newFolder)
+		return
+	}
+
 	c.JSON(http.StatusCreated, newFolder)
 }
 
@@ -132,7 +177,20 @@ func (fh *FolderHandler) FolderContentsCreate(c *gin.Context) {
 		return
 	}
 
-	newFile, fileBytes, err := fh.FolderService.UploadFile(userClaim.ID, folderCode, file)
+	digests, err := utils.ParseContentDigest(c.GetHeader("Content-Digest"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	onConflict, ok := bindUploadConflictPolicy(c)
+	if !ok {
+		return
+	}
+
+	newFile, fileBytes, err := fh.FolderService.UploadFile(userClaim.ID, folderCode, file, digests, onConflict)
 	if err != nil {
 		switch e := err.(type) {
 			case *apperr.NotFoundError:
@@ -140,24 +198,59 @@ func (fh *FolderHandler) FolderContentsCreate(c *gin.Context) {
 					"error": e.Error(),
 				})
 				return
-			case *apperr.ServerError:
+			case *apperr.InvalidParamError:
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": e.Error(),
+				})
+				return
+			case *apperr.QuotaExceededError:
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+					"error": e.Error(),
+				})
+				return
+			case *apperr.ConflictError:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": e.Error(),
+				})
+				return
+			default:
 				c.Status(http.StatusInternalServerError)
 				return
 		}
 	}
 
-	c.JSON(http.StatusCreated, newFile)
+	// A skipped upload leaves the existing file as it was, there's nothing to process
+	if newFile.Skipped {
+		c.JSON(http.StatusOK, newFile)
+		return
+	}
+
+	// Uploading over an existing file adds a version to it instead of creating a new file
+	if newFile.Version > 1 {
+		c.JSON(http.StatusOK, newFile)
+	} else {
+		c.JSON(http.StatusCreated, newFile)
+	}
 
 	if strings.HasPrefix(newFile.FileType, "image/") || strings.HasPrefix(newFile.FileType, "video/") {
 		fh.FolderService.PostUploadProcess(newFile, fileBytes)
 	}
+
+	if services.IsIndexable(newFile.FileType, newFile.FileName) {
+		fh.FolderService.IndexFileContent(newFile, fileBytes)
+	}
 }
 
 func (fh *FolderHandler) FolderContents(c *gin.Context) {
 	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
 	folderCode := c.Param("code")
 
-	files, err := fh.FolderService.FetchFolderFiles(userClaim.ID, folderCode)
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	files, err := fh.FolderService.FetchFolderFiles(userClaim.ID, folderCode, params)
 	if err != nil {
 		switch err := err.(type) {
 			case *apperr.NotFoundError:
@@ -165,6 +258,11 @@ func (fh *FolderHandler) FolderContents(c *gin.Context) {
 					"error": err.Error(),
 				})
 				return
+			case *apperr.InvalidParamError:
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
 			case *apperr.ServerError:
 				c.Status(http.StatusInternalServerError)
 				return
@@ -197,7 +295,12 @@ func (fh *FolderHandler) FolderPatch(c *gin.Context) {
 		return
 	}
 
-	folder, err := fh.FolderService.PatchFolder(userClaim.ID, folderCode, folderUpdateBody)
+	onConflict, ok := bindConflictPolicy(c)
+	if !ok {
+		return
+	}
+
+	folder, err := fh.FolderService.PatchFolder(userClaim.ID, folderCode, folderUpdateBody, onConflict)
 	if err != nil {
 		switch err := err.(type) {
 			case *apperr.NotFoundError:
@@ -205,7 +308,17 @@ func (fh *FolderHandler) FolderPatch(c *gin.Context) {
 					"error": err.Error(),
 				})
 				return
-			case *apperr.ServerError:
+			case *apperr.InvalidParamError:
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			case *apperr.ConflictError:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": err.Error(),
+				})
+				return
+			default:
 				c.Status(http.StatusInternalServerError)
 				return
 		}
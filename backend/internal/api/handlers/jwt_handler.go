@@ -4,8 +4,10 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 
@@ -19,7 +21,7 @@ func CheckTokenValidation(c *gin.Context) {
 		return
 	}
 	
-	_, err := utils.ParseToken(token)
+	userClaims, err := utils.ParseToken(token)
 
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -28,5 +30,18 @@ func CheckTokenValidation(c *gin.Context) {
 		return
 	}
 
+	revoked, err := services.NewTokenService(c.MustGet("db").(*gorm.DB)).IsTokenRevoked(userClaims.Id)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if userClaims.Id == "" || revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Token is revoked",
+		})
+		return
+	}
+
 	c.Status(http.StatusOK)
 }
\ No newline at end of file
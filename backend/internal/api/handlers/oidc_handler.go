@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const OIDC_STATE_COOKIE = "oidc_state"
+
+type OIDCHandler struct {
+	OIDCService *services.OIDCService
+	AuthService *services.AuthService
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService, authService *services.AuthService) *OIDCHandler {
+	return &OIDCHandler{
+		OIDCService: oidcService,
+		AuthService: authService,
+	}
+}
+
+// respondOIDCError writes the response matching an OIDCService error.
+func respondOIDCError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ConflictError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidCredentialsError:
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ForbiddenError:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// redirectToApp sends the browser back to the frontend at the end of a login with a provider,
+// which is a navigation rather than an API call.
+func redirectToApp(c *gin.Context, path string, query url.Values) {
+	target := services.AppURL() + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+func (oh *OIDCHandler) beginLogin(c *gin.Context, linkUserID uint) {
+	authURL, stateToken, err := oh.OIDCService.BeginLogin(c.Param("provider"), linkUserID)
+	if err != nil {
+		respondOIDCError(c, err)
+		return
+	}
+
+	c.SetCookie(OIDC_STATE_COOKIE, stateToken, int(services.OIDC_STATE_TTL.Seconds()), "/api/auth/oidc", c.Request.Host, false, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (oh *OIDCHandler) OIDCProviderList(c *gin.Context) {
+	c.JSON(http.StatusOK, oh.OIDCService.ListProviders())
+}
+
+func (oh *OIDCHandler) OIDCLogin(c *gin.Context) {
+	oh.beginLogin(c, 0)
+}
+
+func (oh *OIDCHandler) OIDCLink(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	oh.beginLogin(c, userClaim.ID)
+}
+
+func (oh *OIDCHandler) OIDCCallback(c *gin.Context) {
+	stateToken, _ := c.Cookie(OIDC_STATE_COOKIE)
+	c.SetCookie(OIDC_STATE_COOKIE, "", -1, "/api/auth/oidc", c.Request.Host, false, true)
+
+	// The provider reports a refused consent or a failed login as an error parameter
+	if providerErr := c.Query("error"); providerErr != "" {
+		redirectToApp(c, "/login", url.Values{"sso_error": {providerErr}})
+		return
+	}
+
+	user, linked, err := oh.OIDCService.FinishLogin(c.Param("provider"), stateToken, c.Query("state"), c.Query("code"))
+	if err != nil {
+		message := "Login failed"
+		if _, ok := err.(*apperr.ServerError); !ok {
+			message = err.Error()
+		}
+		redirectToApp(c, "/login", url.Values{"sso_error": {message}})
+		return
+	}
+
+	if linked {
+		redirectToApp(c, "/", url.Values{"sso_linked": {c.Param("provider")}})
+		return
+	}
+
+	tokens, err := oh.AuthService.CompleteLogin(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		redirectToApp(c, "/login", url.Values{"sso_error": {"Login failed"}})
+		return
+	}
+
+	// The frontend asks for the second factor like after a password, the challenge token stays in a cookie
+	if tokens.TwoFactorRequired {
+		setChallengeCookie(c, tokens.ChallengeToken)
+		redirectToApp(c, "/login", url.Values{
+			"two_factor_methods": {strings.Join(tokens.TwoFactorMethods, ",")},
+		})
+		return
+	}
+
+	setAuthCookies(c, tokens)
+	redirectToApp(c, "/", nil)
+}
+
+func (oh *OIDCHandler) IdentityList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	identities, err := oh.OIDCService.ListIdentities(userClaim.ID)
+	if err != nil {
+		respondOIDCError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+func (oh *OIDCHandler) IdentityUnlink(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	identityID, err := strconv.ParseUint(c.Param("identityId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid identity ID",
+		})
+		return
+	}
+
+	if err := oh.OIDCService.UnlinkIdentity(userClaim.ID, uint(identityID)); err != nil {
+		respondOIDCError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
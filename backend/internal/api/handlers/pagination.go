@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// bindListParams reads the pagination, sorting and filtering query params.
+// It responds with 400 and returns false if they are invalid.
+func bindListParams(c *gin.Context) (models.ListParams, bool) {
+	validate := validator.New()
+
+	var params models.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return params, false
+	}
+
+	if err := validate.Struct(params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return params, false
+	}
+
+	return params, true
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type PersonalAccessTokenHandler struct {
+	PersonalAccessTokenService *services.PersonalAccessTokenService
+}
+
+func NewPersonalAccessTokenHandler(personalAccessTokenService *services.PersonalAccessTokenService) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{
+		PersonalAccessTokenService: personalAccessTokenService,
+	}
+}
+
+// respondPersonalAccessTokenError writes the response matching a PersonalAccessTokenService error.
+func respondPersonalAccessTokenError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ConflictError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func (pth *PersonalAccessTokenHandler) PersonalAccessTokenList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	tokens, err := pth.PersonalAccessTokenService.ListTokens(userClaim.ID)
+	if err != nil {
+		respondPersonalAccessTokenError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (pth *PersonalAccessTokenHandler) PersonalAccessTokenCreate(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var tokenBody models.PersonalAccessTokenBody
+	if !bindUserBody(c, &tokenBody) {
+		return
+	}
+
+	token, err := pth.PersonalAccessTokenService.CreateToken(userClaim.ID, tokenBody)
+	if err != nil {
+		respondPersonalAccessTokenError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+func (pth *PersonalAccessTokenHandler) PersonalAccessTokenRevoke(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	tokenID, err := strconv.ParseUint(c.Param("tokenId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid token ID",
+		})
+		return
+	}
+
+	if err := pth.PersonalAccessTokenService.RevokeToken(userClaim.ID, uint(tokenID)); err != nil {
+		respondPersonalAccessTokenError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
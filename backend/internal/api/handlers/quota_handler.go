@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type QuotaHandler struct {
+	QuotaService *services.QuotaService
+}
+
+func NewQuotaHandler(quotaService *services.QuotaService) *QuotaHandler {
+	return &QuotaHandler{
+		QuotaService: quotaService,
+	}
+}
+
+func (qh *QuotaHandler) UserUsage(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	usage, err := qh.QuotaService.GetUsage(userClaim.ID)
+	if err != nil {
+		switch e := err.(type) {
+		case *apperr.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": e.Error(),
+			})
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+func (qh *QuotaHandler) UserQuotaUpdate(c *gin.Context) {
+	validate := validator.New()
+
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	var quotaBody models.UserQuotaBody
+	if err := c.BindJSON(&quotaBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return
+	}
+
+	if err := validate.Struct(quotaBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := qh.QuotaService.SetUserQuota(uint(userID), quotaBody.QuotaBytes)
+	if err != nil {
+		switch e := err.(type) {
+		case *apperr.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": e.Error(),
+			})
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": user,
+	})
+}
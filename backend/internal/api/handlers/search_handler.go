@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type SearchHandler struct {
+	SearchService *services.SearchService
+}
+
+func NewSearchHandler(searchService *services.SearchService) *SearchHandler {
+	return &SearchHandler{
+		SearchService: searchService,
+	}
+}
+
+func (sh *SearchHandler) Search(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	validate := validator.New()
+
+	var searchQuery models.SearchQuery
+	if err := c.ShouldBindQuery(&searchQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := validate.Struct(searchQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	results, err := sh.SearchService.Search(userClaim.ID, searchQuery)
+	if err != nil {
+		switch e := err.(type) {
+		case *apperr.InvalidParamError:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": e.Error(),
+			})
+			return
+		default:
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, results)
+}
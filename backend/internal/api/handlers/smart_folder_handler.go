@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type SmartFolderHandler struct {
+	SmartFolderService *services.SmartFolderService
+}
+
+func NewSmartFolderHandler(smartFolderService *services.SmartFolderService) *SmartFolderHandler {
+	return &SmartFolderHandler{
+		SmartFolderService: smartFolderService,
+	}
+}
+
+// respondSmartFolderError writes the response matching a SmartFolderService error.
+func respondSmartFolderError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidParamError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func bindSmartFolderBody(c *gin.Context) (*models.SmartFolderBody, bool) {
+	validate := validator.New()
+
+	var body models.SmartFolderBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return nil, false
+	}
+
+	if err := validate.Struct(body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+
+	return &body, true
+}
+
+func (sfh *SmartFolderHandler) SmartFolderList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	smartFolders, err := sfh.SmartFolderService.ListSmartFolders(userClaim.ID)
+	if err != nil {
+		respondSmartFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, smartFolders)
+}
+
+func (sfh *SmartFolderHandler) SmartFolderDetail(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	smartFolder, err := sfh.SmartFolderService.GetSmartFolder(userClaim.ID, c.Param("code"))
+	if err != nil {
+		respondSmartFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, smartFolder)
+}
+
+func (sfh *SmartFolderHandler) SmartFolderCreate(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	body, ok := bindSmartFolderBody(c)
+	if !ok {
+		return
+	}
+
+	smartFolder, err := sfh.SmartFolderService.CreateSmartFolder(userClaim.ID, *body)
+	if err != nil {
+		respondSmartFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, smartFolder)
+}
+
+func (sfh *SmartFolderHandler) SmartFolderUpdate(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	body, ok := bindSmartFolderBody(c)
+	if !ok {
+		return
+	}
+
+	smartFolder, err := sfh.SmartFolderService.UpdateSmartFolder(userClaim.ID, c.Param("code"), *body)
+	if err != nil {
+		respondSmartFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, smartFolder)
+}
+
+func (sfh *SmartFolderHandler) SmartFolderDelete(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	if err := sfh.SmartFolderService.DeleteSmartFolder(userClaim.ID, c.Param("code")); err != nil {
+		respondSmartFolderError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (sfh *SmartFolderHandler) SmartFolderContents(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	files, err := sfh.SmartFolderService.FetchSmartFolderFiles(userClaim.ID, c.Param("code"), params)
+	if err != nil {
+		respondSmartFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, files)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type TrashHandler struct {
+	TrashService *services.TrashService
+}
+
+func NewTrashHandler(trashService *services.TrashService) *TrashHandler {
+	return &TrashHandler{
+		TrashService: trashService,
+	}
+}
+
+func respondTrashError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidParamError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func (th *TrashHandler) TrashList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	trash, err := th.TrashService.ListTrash(userClaim.ID, params)
+	if err != nil {
+		respondTrashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, trash)
+}
+
+func (th *TrashHandler) TrashEmpty(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	purged, err := th.TrashService.EmptyTrash(userClaim.ID)
+	if err != nil {
+		respondTrashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, purged)
+}
+
+func (th *TrashHandler) TrashRetention(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	retention, err := th.TrashService.GetTrashRetention(userClaim.ID)
+	if err != nil {
+		respondTrashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, retention)
+}
+
+func (th *TrashHandler) TrashRetentionUpdate(c *gin.Context) {
+	validate := validator.New()
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var retentionBody models.TrashRetentionBody
+	if err := c.BindJSON(&retentionBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return
+	}
+
+	if err := validate.Struct(retentionBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	retention, err := th.TrashService.SetTrashRetention(userClaim.ID, retentionBody.Days)
+	if err != nil {
+		respondTrashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, retention)
+}
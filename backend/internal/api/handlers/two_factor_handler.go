@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorHandler struct {
+	TwoFactorService *services.TwoFactorService
+}
+
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		TwoFactorService: twoFactorService,
+	}
+}
+
+// respondTwoFactorError writes the response matching a TwoFactorService error.
+func respondTwoFactorError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ConflictError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidCredentialsError:
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func (tfh *TwoFactorHandler) TwoFactorStatus(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	enabled, err := tfh.TwoFactorService.IsEnabled(userClaim.ID)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": enabled,
+	})
+}
+
+func (tfh *TwoFactorHandler) TwoFactorSetup(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	setup, err := tfh.TwoFactorService.Setup(userClaim.ID)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+func (tfh *TwoFactorHandler) TwoFactorEnable(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var codeBody models.TwoFactorCodeBody
+	if !bindUserBody(c, &codeBody) {
+		return
+	}
+
+	codes, err := tfh.TwoFactorService.Enable(userClaim.ID, codeBody.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (tfh *TwoFactorHandler) TwoFactorDisable(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var passwordBody models.PasswordBody
+	if !bindUserBody(c, &passwordBody) {
+		return
+	}
+
+	if err := tfh.TwoFactorService.Disable(userClaim.ID, userClaim.SessionID, passwordBody.Password); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (tfh *TwoFactorHandler) RecoveryCodesRegenerate(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var passwordBody models.PasswordBody
+	if !bindUserBody(c, &passwordBody) {
+		return
+	}
+
+	codes, err := tfh.TwoFactorService.RegenerateRecoveryCodes(userClaim.ID, userClaim.SessionID, passwordBody.Password)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	
@@ -17,15 +18,246 @@ import (
 )
 
 type UserHandler struct {
-	UserService *services.UserService
+	UserService    *services.UserService
+	SessionService *services.SessionService
+	TokenService   *services.TokenService
 }
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
+func NewUserHandler(userService *services.UserService, sessionService *services.SessionService, tokenService *services.TokenService) *UserHandler {
 	return &UserHandler{
-		UserService: userService,
+		UserService:    userService,
+		SessionService: sessionService,
+		TokenService:   tokenService,
 	}
 }
 
+// respondUserError writes the response matching a UserService error.
+func respondUserError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidParamError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidCredentialsError:
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ConflictError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ForbiddenError:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// bindOwnUserID reads the userId param, users can only manage their own account.
+func bindOwnUserID(c *gin.Context, userClaim *utils.UserClaims) bool {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return false
+	}
+
+	if uint(userID) != userClaim.ID {
+		c.Status(http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// bindUserBody binds and validates the JSON body of a user endpoint.
+func bindUserBody(c *gin.Context, body interface{}) bool {
+	if err := c.BindJSON(body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No request body (JSON) included.",
+		})
+		return false
+	}
+
+	if err := validator.New().Struct(body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	return true
+}
+
+func (uf *UserHandler) UserUpdate(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	if !bindOwnUserID(c, userClaim) {
+		return
+	}
+
+	var updateBody models.UserUpdateBody
+	if !bindUserBody(c, &updateBody) {
+		return
+	}
+
+	user, err := uf.UserService.UpdateUser(userClaim.ID, updateBody)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": user,
+	})
+}
+
+func (uf *UserHandler) UserEmailChangeConfirm(c *gin.Context) {
+	var confirmBody models.TokenBody
+	if !bindUserBody(c, &confirmBody) {
+		return
+	}
+
+	user, err := uf.UserService.ConfirmEmailChange(confirmBody.Token)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": user,
+	})
+}
+
+func (uf *UserHandler) UserEmailVerify(c *gin.Context) {
+	var verifyBody models.TokenBody
+	if !bindUserBody(c, &verifyBody) {
+		return
+	}
+
+	user, err := uf.UserService.VerifyEmail(verifyBody.Token)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": user,
+	})
+}
+
+// UserEmailVerifyResend answers the same whether the email is registered or not.
+func (uf *UserHandler) UserEmailVerifyResend(c *gin.Context) {
+	var emailBody models.EmailBody
+	if !bindUserBody(c, &emailBody) {
+		return
+	}
+
+	if err := uf.UserService.ResendVerificationEmail(emailBody.Email); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// UserPasswordForgot answers the same whether the email is registered or not.
+func (uf *UserHandler) UserPasswordForgot(c *gin.Context) {
+	var emailBody models.EmailBody
+	if !bindUserBody(c, &emailBody) {
+		return
+	}
+
+	if err := uf.UserService.RequestPasswordReset(emailBody.Email); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (uf *UserHandler) UserPasswordReset(c *gin.Context) {
+	var resetBody models.PasswordResetBody
+	if !bindUserBody(c, &resetBody) {
+		return
+	}
+
+	if err := uf.UserService.ResetPassword(resetBody); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (uf *UserHandler) UserPasswordChange(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	if !bindOwnUserID(c, userClaim) {
+		return
+	}
+
+	var passwordBody models.PasswordChangeBody
+	if !bindUserBody(c, &passwordBody) {
+		return
+	}
+
+	user, err := uf.UserService.ChangePassword(userClaim.ID, passwordBody)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	if err := uf.TokenService.RevokeToken(userClaim); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	// Every session was revoked, the device changing the password gets a new one
+	tokens, err := uf.SessionService.StartSession(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	setAuthCookies(c, tokens)
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (uf *UserHandler) UserDelete(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+	if !bindOwnUserID(c, userClaim) {
+		return
+	}
+
+	var deleteBody models.AccountDeleteBody
+	if !bindUserBody(c, &deleteBody) {
+		return
+	}
+
+	if err := uf.UserService.DeleteUser(userClaim.ID, userClaim.SessionID, deleteBody.Password); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	if err := uf.TokenService.RevokeToken(userClaim); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"grace_period_days": services.AccountDeletionGraceDays(),
+	})
+}
+
 func (uf *UserHandler) UserCreate(c *gin.Context) {
 	validate := validator.New()
 	var userBody models.UserBody
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnHandler struct {
+	WebAuthnService  *services.WebAuthnService
+	TwoFactorService *services.TwoFactorService
+	AuthService      *services.AuthService
+}
+
+func NewWebAuthnHandler(webAuthnService *services.WebAuthnService, twoFactorService *services.TwoFactorService, authService *services.AuthService) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		WebAuthnService:  webAuthnService,
+		TwoFactorService: twoFactorService,
+		AuthService:      authService,
+	}
+}
+
+// respondWebAuthnError writes the response matching a WebAuthnService error.
+func respondWebAuthnError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *apperr.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ConflictError:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.InvalidCredentialsError:
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": e.Error(),
+		})
+	case *apperr.ForbiddenError:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": e.Error(),
+		})
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func bindCredentialID(c *gin.Context) (uint, bool) {
+	credentialID, err := strconv.ParseUint(c.Param("credentialId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid credential ID",
+		})
+		return 0, false
+	}
+
+	return uint(credentialID), true
+}
+
+func (wh *WebAuthnHandler) RegistrationBegin(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var reauthBody models.ReauthBody
+	if !bindUserBody(c, &reauthBody) {
+		return
+	}
+
+	if err := wh.TwoFactorService.Reauthenticate(userClaim.ID, userClaim.SessionID, reauthBody); err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	ceremony, err := wh.WebAuthnService.BeginRegistration(userClaim.ID)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+func (wh *WebAuthnHandler) RegistrationFinish(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	var registrationBody models.WebAuthnRegistrationBody
+	if !bindUserBody(c, &registrationBody) {
+		return
+	}
+
+	credential, err := wh.WebAuthnService.FinishRegistration(userClaim.ID, registrationBody)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+func (wh *WebAuthnHandler) LoginBegin(c *gin.Context) {
+	ceremony, err := wh.WebAuthnService.BeginLogin()
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+func (wh *WebAuthnHandler) LoginFinish(c *gin.Context) {
+	var loginBody models.WebAuthnLoginBody
+	if !bindUserBody(c, &loginBody) {
+		return
+	}
+
+	tokens, err := wh.AuthService.LoginWithPasskey(loginBody, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	setAuthCookies(c, tokens)
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (wh *WebAuthnHandler) SecondFactorBegin(c *gin.Context) {
+	var challengeBody models.WebAuthnChallengeBody
+	if !bindUserBody(c, &challengeBody) {
+		return
+	}
+
+	userID, err := wh.TwoFactorService.ParseChallenge(challengeTokenFromRequest(c, challengeBody.ChallengeToken))
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	ceremony, err := wh.WebAuthnService.BeginSecondFactor(userID)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+func (wh *WebAuthnHandler) SecondFactorFinish(c *gin.Context) {
+	var loginBody models.WebAuthnLoginBody
+	if !bindUserBody(c, &loginBody) {
+		return
+	}
+
+	loginBody.ChallengeToken = challengeTokenFromRequest(c, loginBody.ChallengeToken)
+	if loginBody.ChallengeToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Challenge token is missing",
+		})
+		return
+	}
+
+	tokens, err := wh.AuthService.LoginWithSecurityKey(loginBody, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	setAuthCookies(c, tokens)
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (wh *WebAuthnHandler) CredentialList(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	credentials, err := wh.WebAuthnService.ListCredentials(userClaim.ID)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+func (wh *WebAuthnHandler) CredentialRename(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	credentialID, ok := bindCredentialID(c)
+	if !ok {
+		return
+	}
+
+	var credentialBody models.WebAuthnCredentialBody
+	if !bindUserBody(c, &credentialBody) {
+		return
+	}
+
+	credential, err := wh.WebAuthnService.RenameCredential(userClaim.ID, credentialID, credentialBody.Name)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credential)
+}
+
+func (wh *WebAuthnHandler) CredentialDelete(c *gin.Context) {
+	userClaim := c.MustGet("userClaims").(*utils.UserClaims)
+
+	credentialID, ok := bindCredentialID(c)
+	if !ok {
+		return
+	}
+
+	var reauthBody models.ReauthBody
+	if !bindUserBody(c, &reauthBody) {
+		return
+	}
+
+	if err := wh.TwoFactorService.Reauthenticate(userClaim.ID, userClaim.SessionID, reauthBody); err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	if err := wh.WebAuthnService.DeleteCredential(userClaim.ID, credentialID); err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,6 +10,11 @@ func AuthRoutes(route *gin.RouterGroup, authHandler *handlers.AuthHandler) {
 	auth := route.Group("/auth") 
 	{
 		auth.POST("/login", authHandler.UserLogin)
+		auth.POST("/login/2fa", authHandler.UserLoginTwoFactor)
 		auth.POST("/logout", authHandler.UserLogout)
+		auth.POST("/refresh", authHandler.TokenRefresh)
+		auth.GET("/sessions", middlewares.JWTMiddleware(), authHandler.SessionList)
+		auth.DELETE("/sessions", middlewares.JWTMiddleware(), authHandler.SessionRevokeAll)
+		auth.DELETE("/sessions/:sessionId", middlewares.JWTMiddleware(), authHandler.SessionRevoke)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+func BatchRoutes(route *gin.RouterGroup, batchHandler *handlers.BatchHandler, minioClient *minio.Client) {
+	batch := route.Group("/batch")
+	{
+		batch.POST("", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(batchHandler.BatchService, minioClient), batchHandler.BatchCreate)
+		batch.GET("/:code", middlewares.JWTMiddleware(), batchHandler.BatchJobDetail)
+	}
+}
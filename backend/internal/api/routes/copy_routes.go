@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+func CopyRoutes(route *gin.RouterGroup, copyHandler *handlers.CopyHandler, minioClient *minio.Client) {
+	route.POST("/files/:fileCode/copy", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(copyHandler.CopyService, minioClient), copyHandler.FileCopy)
+	route.POST("/folders/:code/copy", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(copyHandler.CopyService, minioClient), copyHandler.FolderCopy)
+}
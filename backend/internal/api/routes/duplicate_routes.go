@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+func DuplicateRoutes(route *gin.RouterGroup, duplicateHandler *handlers.DuplicateHandler, minioClient *minio.Client) {
+	duplicate := route.Group("/duplicates")
+	{
+		duplicate.GET("", middlewares.JWTMiddleware(), duplicateHandler.DuplicateList)
+		duplicate.POST("/scan", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(duplicateHandler.DuplicateService, minioClient), duplicateHandler.DuplicateScan)
+		duplicate.POST("/resolve", middlewares.JWTMiddleware(), duplicateHandler.DuplicateResolve)
+	}
+}
@@ -12,9 +12,12 @@ func FileRoutes(route *gin.RouterGroup, fileHandler *handlers.FileHandler, minio
 	{	
 		file.GET("/favorite", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileFavorites)
 		file.GET("/trashcan", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileTrashCan)
+		file.GET("/tags", middlewares.JWTMiddleware(), fileHandler.TagList)
+		file.GET("/corrupted", middlewares.JWTMiddleware(), fileHandler.FileCorrupted)
 		file.GET("/:fileCode/thumbnail", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileThumbnail)
 		file.GET("/:fileCode/download", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileDownload)
 		file.PUT("/:fileID", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileUpdate)
+		file.PUT("/:fileID/tags", middlewares.JWTMiddleware(), fileHandler.FileTags)
 		file.PATCH("/:fileID", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FilePatch)
 		file.DELETE("", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileDeleteAll)
 		file.DELETE("/:fileID", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileHandler.FileService, minioClient), fileHandler.FileDelete)
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+func FileVersionRoutes(route *gin.RouterGroup, fileVersionHandler *handlers.FileVersionHandler, minioClient *minio.Client) {
+	fileVersion := route.Group("/files/:fileCode/versions")
+	{
+		fileVersion.GET("", middlewares.JWTMiddleware(), fileVersionHandler.FileVersionList)
+		fileVersion.GET("/:version/download", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileVersionHandler.FileVersionService, minioClient), fileVersionHandler.FileVersionDownload)
+		fileVersion.POST("/:version/restore", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileVersionHandler.FileVersionService, minioClient), fileVersionHandler.FileVersionRestore)
+		fileVersion.POST("/prune", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(fileVersionHandler.FileVersionService, minioClient), fileVersionHandler.FileVersionPrune)
+	}
+}
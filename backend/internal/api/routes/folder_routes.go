@@ -11,8 +11,9 @@ func FolderRoutes(route *gin.RouterGroup, folderHandler *handlers.FolderHandler,
 	folder := route.Group("/folders") 
 	{	
 		folder.GET("", middlewares.JWTMiddleware(), folderHandler.FolderList)
+		folder.GET("/favorite", middlewares.JWTMiddleware(), folderHandler.FolderFavorites)
+		folder.GET("/trashcan", middlewares.JWTMiddleware(), folderHandler.FolderTrashCan)
 		folder.GET("/:code", middlewares.JWTMiddleware(), folderHandler.FolderList)
-		// TODO: implement /favorite and /trashcan
 		folder.PATCH("/:code", middlewares.JWTMiddleware(), folderHandler.FolderPatch)
 		folder.GET("/:code/files", middlewares.JWTMiddleware(), folderHandler.FolderContents)
 		folder.GET("/:code/folders", middlewares.JWTMiddleware(), folderHandler.FolderList)
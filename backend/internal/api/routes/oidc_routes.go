@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func OIDCRoutes(route *gin.RouterGroup, oidcHandler *handlers.OIDCHandler) {
+	oidc := route.Group("/auth/oidc")
+	{
+		oidc.GET("/providers", oidcHandler.OIDCProviderList)
+		oidc.GET("/identities", middlewares.JWTMiddleware(), oidcHandler.IdentityList)
+		oidc.DELETE("/identities/:identityId", middlewares.JWTMiddleware(), oidcHandler.IdentityUnlink)
+		oidc.GET("/:provider/login", oidcHandler.OIDCLogin)
+		oidc.GET("/:provider/link", middlewares.JWTMiddleware(), oidcHandler.OIDCLink)
+		oidc.GET("/:provider/callback", oidcHandler.OIDCCallback)
+	}
+}
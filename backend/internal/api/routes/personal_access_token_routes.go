@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func PersonalAccessTokenRoutes(route *gin.RouterGroup, personalAccessTokenHandler *handlers.PersonalAccessTokenHandler) {
+	token := route.Group("/auth/tokens")
+	{
+		token.GET("", middlewares.JWTMiddleware(), personalAccessTokenHandler.PersonalAccessTokenList)
+		token.POST("", middlewares.JWTMiddleware(), personalAccessTokenHandler.PersonalAccessTokenCreate)
+		token.DELETE("/:tokenId", middlewares.JWTMiddleware(), personalAccessTokenHandler.PersonalAccessTokenRevoke)
+	}
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func QuotaRoutes(route *gin.RouterGroup, quotaHandler *handlers.QuotaHandler) {
+	route.GET("/users/me/usage", middlewares.JWTMiddleware(), quotaHandler.UserUsage)
+
+	admin := route.Group("/admin")
+	{
+		admin.PUT("/users/:userId/quota", middlewares.JWTMiddleware(), middlewares.AdminMiddleware(), quotaHandler.UserQuotaUpdate)
+	}
+}
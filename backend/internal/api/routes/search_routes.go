@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func SearchRoutes(route *gin.RouterGroup, searchHandler *handlers.SearchHandler) {
+	search := route.Group("/search")
+	{
+		search.GET("", middlewares.JWTMiddleware(), searchHandler.Search)
+	}
+}
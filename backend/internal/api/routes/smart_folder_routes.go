@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func SmartFolderRoutes(route *gin.RouterGroup, smartFolderHandler *handlers.SmartFolderHandler) {
+	smartFolder := route.Group("/smart-folders")
+	{
+		smartFolder.GET("", middlewares.JWTMiddleware(), smartFolderHandler.SmartFolderList)
+		smartFolder.POST("", middlewares.JWTMiddleware(), smartFolderHandler.SmartFolderCreate)
+		smartFolder.GET("/:code", middlewares.JWTMiddleware(), smartFolderHandler.SmartFolderDetail)
+		smartFolder.PUT("/:code", middlewares.JWTMiddleware(), smartFolderHandler.SmartFolderUpdate)
+		smartFolder.DELETE("/:code", middlewares.JWTMiddleware(), smartFolderHandler.SmartFolderDelete)
+		smartFolder.GET("/:code/files", middlewares.JWTMiddleware(), smartFolderHandler.SmartFolderContents)
+	}
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+func TrashRoutes(route *gin.RouterGroup, trashHandler *handlers.TrashHandler, minioClient *minio.Client) {
+	trash := route.Group("/trash")
+	{
+		trash.GET("", middlewares.JWTMiddleware(), trashHandler.TrashList)
+		trash.DELETE("", middlewares.JWTMiddleware(), middlewares.MinIOMiddleware(trashHandler.TrashService, minioClient), trashHandler.TrashEmpty)
+		trash.GET("/retention", middlewares.JWTMiddleware(), trashHandler.TrashRetention)
+		trash.PUT("/retention", middlewares.JWTMiddleware(), trashHandler.TrashRetentionUpdate)
+	}
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func TwoFactorRoutes(route *gin.RouterGroup, twoFactorHandler *handlers.TwoFactorHandler) {
+	twoFactor := route.Group("/auth/2fa")
+	{
+		twoFactor.GET("", middlewares.JWTMiddleware(), twoFactorHandler.TwoFactorStatus)
+		twoFactor.POST("/setup", middlewares.JWTMiddleware(), twoFactorHandler.TwoFactorSetup)
+		twoFactor.POST("/enable", middlewares.JWTMiddleware(), twoFactorHandler.TwoFactorEnable)
+		twoFactor.POST("/disable", middlewares.JWTMiddleware(), twoFactorHandler.TwoFactorDisable)
+		twoFactor.POST("/recovery-codes", middlewares.JWTMiddleware(), twoFactorHandler.RecoveryCodesRegenerate)
+	}
+}
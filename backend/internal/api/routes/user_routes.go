@@ -10,7 +10,13 @@ func UserRoutes(route *gin.RouterGroup, userHandler *handlers.UserHandler) {
 	user := route.Group("/users") 
 	{
 		user.POST("/register", userHandler.UserCreate)
-		user.PUT("/:userId", middlewares.JWTMiddleware(), func(ctx *gin.Context) {})
-		user.DELETE("/:userId", middlewares.JWTMiddleware(), func(ctx *gin.Context) {})
+		user.POST("/verify-email", userHandler.UserEmailVerify)
+		user.POST("/verify-email/resend", userHandler.UserEmailVerifyResend)
+		user.POST("/verify-email-change", userHandler.UserEmailChangeConfirm)
+		user.POST("/forgot-password", userHandler.UserPasswordForgot)
+		user.POST("/reset-password", userHandler.UserPasswordReset)
+		user.PUT("/:userId", middlewares.JWTMiddleware(), userHandler.UserUpdate)
+		user.PUT("/:userId/password", middlewares.JWTMiddleware(), userHandler.UserPasswordChange)
+		user.DELETE("/:userId", middlewares.JWTMiddleware(), userHandler.UserDelete)
 	}
 }
\ No newline at end of file
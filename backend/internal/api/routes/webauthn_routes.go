@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/api/handlers"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func WebAuthnRoutes(route *gin.RouterGroup, webAuthnHandler *handlers.WebAuthnHandler) {
+	webAuthn := route.Group("/auth/webauthn")
+	{
+		webAuthn.POST("/register/begin", middlewares.JWTMiddleware(), webAuthnHandler.RegistrationBegin)
+		webAuthn.POST("/register/finish", middlewares.JWTMiddleware(), webAuthnHandler.RegistrationFinish)
+		webAuthn.POST("/login/begin", webAuthnHandler.LoginBegin)
+		webAuthn.POST("/login/finish", webAuthnHandler.LoginFinish)
+		webAuthn.POST("/2fa/begin", webAuthnHandler.SecondFactorBegin)
+		webAuthn.POST("/2fa/finish", webAuthnHandler.SecondFactorFinish)
+		webAuthn.GET("/credentials", middlewares.JWTMiddleware(), webAuthnHandler.CredentialList)
+		webAuthn.PATCH("/credentials/:credentialId", middlewares.JWTMiddleware(), webAuthnHandler.CredentialRename)
+		webAuthn.DELETE("/credentials/:credentialId", middlewares.JWTMiddleware(), webAuthnHandler.CredentialDelete)
+	}
+}
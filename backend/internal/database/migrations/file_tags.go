@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// FILE_TAGS_CASCADE_SCHEMA_VERSION is the schema version that made the links of file_tags go
+// away with their file or tag.
+const FILE_TAGS_CASCADE_SCHEMA_VERSION uint = 6
+
+type foreignKey struct {
+	ConstraintName       string
+	ColumnName           string
+	ReferencedTableName  string
+	ReferencedColumnName string
+	DeleteRule           string
+}
+
+// cascadeFileTags recreates the foreign keys of file_tags with ON DELETE CASCADE. AutoMigrate doesn't
+// change the foreign keys of an existing table, and the ones created before restrict hard deleting a
+// tagged file.
+func cascadeFileTags(db *gorm.DB) error {
+	var foreignKeys []foreignKey
+	err := db.Raw(`SELECT k.constraint_name AS constraint_name, k.column_name AS column_name,
+			k.referenced_table_name AS referenced_table_name, k.referenced_column_name AS referenced_column_name,
+			r.delete_rule AS delete_rule
+		FROM information_schema.key_column_usage k
+		JOIN information_schema.referential_constraints r
+			ON r.constraint_schema = k.constraint_schema AND r.constraint_name = k.constraint_name
+		WHERE k.table_schema = DATABASE() AND k.table_name = 'file_tags' AND k.referenced_table_name IS NOT NULL`).
+		Scan(&foreignKeys).Error
+	if err != nil {
+		return err
+	}
+
+	for _, key := range foreignKeys {
+		if key.DeleteRule == "CASCADE" {
+			continue
+		}
+
+		// A key dropped without being added back is recreated by AutoMigrate on the next start
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE file_tags DROP FOREIGN KEY `%s`", key.ConstraintName)).Error; err != nil {
+			return err
+		}
+
+		err := db.Exec(fmt.Sprintf(
+			"ALTER TABLE file_tags ADD CONSTRAINT `%s` FOREIGN KEY (`%s`) REFERENCES `%s` (`%s`) ON DELETE CASCADE",
+			key.ConstraintName, key.ColumnName, key.ReferencedTableName, key.ReferencedColumnName,
+		)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
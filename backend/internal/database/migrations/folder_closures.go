@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// backfillFolderClosures rebuilds the folder closures from the parent of each folder, when some folders
+// have none yet, e.g. folders created before the closure table existed.
+func backfillFolderClosures(db *gorm.DB) error {
+	var folderCount, selfCount int64
+	if err := db.Table("folders").Count(&folderCount).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.FolderClosure{}).Where("depth = 0").Count(&selfCount).Error; err != nil {
+		return err
+	}
+
+	if folderCount == selfCount {
+		return nil
+	}
+
+	type folderRow struct {
+		ID       uint
+		ParentID *uint
+	}
+
+	var folders []folderRow
+	if err := db.Table("folders").Select("id", "parent_id").Scan(&folders).Error; err != nil {
+		return err
+	}
+
+	parents := make(map[uint]*uint, len(folders))
+	for _, folder := range folders {
+		parents[folder.ID] = folder.ParentID
+	}
+
+	closures := []models.FolderClosure{}
+	for _, folder := range folders {
+		seen := map[uint]bool{}
+		ancestorID, depth := folder.ID, uint(0)
+		for !seen[ancestorID] {
+			seen[ancestorID] = true
+			closures = append(closures, models.FolderClosure{AncestorID: ancestorID, DescendantID: folder.ID, Depth: depth})
+
+			parentID, ok := parents[ancestorID]
+			if !ok || parentID == nil {
+				break
+			}
+			ancestorID, depth = *parentID, depth+1
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.FolderClosure{}).Error; err != nil {
+			return err
+		}
+
+		return tx.CreateInBatches(closures, 500).Error
+	})
+}
@@ -0,0 +1,106 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// liveNameIndex is a unique index on the names of the live items of a table, per parent folder.
+type liveNameIndex struct {
+	Table         string
+	NameColumn    string
+	ParentColumn  string
+	Index         string
+	KeepExtension bool
+}
+
+var liveNameIndexes = []liveNameIndex{
+	{Table: "files", NameColumn: "file_name", ParentColumn: "folder_id", Index: "idx_files_folder_live_name", KeepExtension: true},
+	{Table: "folders", NameColumn: "name", ParentColumn: "parent_id", Index: "idx_folders_parent_live_name"},
+}
+
+// migrateLiveNames makes names unique per folder in the database. Trashed items give up their name,
+// so the unique indexes are on a generated live_name column, NULL while an item is in the trash.
+// Duplicates left from before are renamed "name (1)", "name (2)"... first, the oldest item keeps its name.
+func migrateLiveNames(db *gorm.DB) error {
+	for _, index := range liveNameIndexes {
+		if !db.Migrator().HasColumn(index.Table, "live_name") {
+			err := db.Exec(fmt.Sprintf(
+				"ALTER TABLE %s ADD COLUMN live_name VARCHAR(255) AS (IF(deleted_at IS NULL, %s, NULL)) PERSISTENT",
+				index.Table, index.NameColumn,
+			)).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if db.Migrator().HasIndex(index.Table, index.Index) {
+			continue
+		}
+
+		if err := renameDuplicateNames(db, index); err != nil {
+			return err
+		}
+
+		err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s, live_name)", index.Index, index.Table, index.ParentColumn)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renameDuplicateNames(db *gorm.DB, index liveNameIndex) error {
+	type item struct {
+		ID     uint
+		Parent uint
+		Name   string
+	}
+
+	var duplicates []item
+	err := db.Raw(fmt.Sprintf(`SELECT t.id, t.%[2]s AS parent, t.%[3]s AS name FROM %[1]s t
+		JOIN (SELECT %[2]s, live_name FROM %[1]s WHERE live_name IS NOT NULL GROUP BY %[2]s, live_name HAVING COUNT(*) > 1) d
+		ON t.%[2]s = d.%[2]s AND t.live_name = d.live_name
+		ORDER BY t.id`, index.Table, index.ParentColumn, index.NameColumn)).Scan(&duplicates).Error
+	if err != nil {
+		return err
+	}
+
+	// The oldest item of each name keeps it, names are compared by the database like the index does
+	for _, duplicate := range duplicates {
+		var keepers int64
+		err := db.Table(index.Table).Where(fmt.Sprintf("%s = ? AND live_name = ? AND id < ?", index.ParentColumn), duplicate.Parent, duplicate.Name, duplicate.ID).
+			Count(&keepers).Error
+		if err != nil {
+			return err
+		}
+
+		if keepers == 0 {
+			continue
+		}
+
+		for n := 1; ; n++ {
+			candidate := utils.NumberedName(duplicate.Name, n, index.KeepExtension)
+
+			var taken int64
+			err := db.Table(index.Table).Where(fmt.Sprintf("%s = ? AND live_name = ?", index.ParentColumn), duplicate.Parent, candidate).Count(&taken).Error
+			if err != nil {
+				return err
+			}
+
+			if taken == 0 {
+				log.Printf("(Migrate) Renaming duplicate %s %d from %s to %s\n", index.Table, duplicate.ID, duplicate.Name, candidate)
+				if err := db.Table(index.Table).Where("id = ?", duplicate.ID).Update(index.NameColumn, candidate).Error; err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	return nil
+}
@@ -15,8 +15,55 @@ func init() {
 func Migrate(db database.Database) error {  
 	gormDB := db.GetDB()
 
+	// Accounts from before email verification existed are taken as verified
+	backfillEmailVerification := gormDB.Migrator().HasTable(&models.User{}) && !gormDB.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+	previousVersion := storedSchemaVersion(gormDB)
+
 	log.Println("(Migrate) Migrating...")
-	migErr := gormDB.AutoMigrate(&models.User{}, &models.Token{}, &models.Folder{}, &models.File{}, &models.Thumbnail{})
+	migErr := gormDB.AutoMigrate(&models.User{}, &models.Token{}, &models.Folder{}, &models.File{}, &models.Thumbnail{}, &models.FileContent{}, &models.Tag{}, &models.SmartFolder{}, &models.FileVersion{}, &models.StoredObject{}, &models.BatchJob{}, &models.FolderClosure{}, &models.Session{}, &models.RefreshToken{}, &models.TwoFactor{}, &models.RecoveryCode{}, &models.WebAuthnCredential{}, &models.PersonalAccessToken{}, &models.UserIdentity{}, &models.ReleasedObject{}, &models.DuplicateScan{}, &models.UsedToken{}, &models.SchemaVersion{})
+
+	if migErr != nil {
+		return migErr
+	}
+
+	if backfillEmailVerification {
+		log.Println("(Migrate) Marking existing accounts as verified...")
+		if err := gormDB.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error; err != nil {
+			return err
+		}
+	}
+
+	log.Println("(Migrate) Backfilling folder closures...")
+	if err := backfillFolderClosures(gormDB); err != nil {
+		return err
+	}
+
+	log.Println("(Migrate) Enforcing unique names per folder...")
+	if err := migrateLiveNames(gormDB); err != nil {
+		return err
+	}
+
+	if previousVersion < STORAGE_SIZES_SCHEMA_VERSION {
+		log.Println("(Migrate) Backfilling thumbnail and HLS sizes...")
+		if err := backfillStorageSizes(gormDB); err != nil {
+			return err
+		}
+	}
+
+	if previousVersion < FILE_TAGS_CASCADE_SCHEMA_VERSION {
+		log.Println("(Migrate) Cascading deletes to file tags...")
+		if err := cascadeFileTags(gormDB); err != nil {
+			return err
+		}
+	}
+
+	// Duplicates are clustered when listed, scans don't keep clusters anymore
+	if gormDB.Migrator().HasColumn(&models.DuplicateScan{}, "clusters") {
+		log.Println("(Migrate) Dropping stored duplicate clusters...")
+		if err := gormDB.Migrator().DropColumn(&models.DuplicateScan{}, "clusters"); err != nil {
+			return err
+		}
+	}
 
-  	return migErr
+	return recordSchemaVersion(gormDB)
 }
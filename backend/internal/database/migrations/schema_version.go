@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"errors"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/database"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// SCHEMA_VERSION is the schema version this build expects. Bump it whenever a model or a
+// data migration changes, so existing databases are migrated on their next start.
+const SCHEMA_VERSION uint = 7
+
+// IsMigrated reports whether the database is already at SCHEMA_VERSION.
+//
+// Databases created before schema versions were recorded are reported as not migrated.
+func IsMigrated(db database.Database) bool {
+	return storedSchemaVersion(db.GetDB()) >= SCHEMA_VERSION
+}
+
+// storedSchemaVersion returns the schema version the database was last migrated to,
+// 0 if none was recorded.
+func storedSchemaVersion(db *gorm.DB) uint {
+	if !db.Migrator().HasTable(&models.SchemaVersion{}) {
+		return 0
+	}
+
+	var current models.SchemaVersion
+	if err := db.Order("version DESC").First(&current).Error; err != nil {
+		return 0
+	}
+
+	return current.Version
+}
+
+// recordSchemaVersion stores SCHEMA_VERSION as the version the database was migrated to.
+func recordSchemaVersion(db *gorm.DB) error {
+	var current models.SchemaVersion
+	err := db.Order("version DESC").First(&current).Error
+	if err == nil && current.Version >= SCHEMA_VERSION {
+		return nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return db.Create(&models.SchemaVersion{Version: SCHEMA_VERSION, MigratedAt: time.Now()}).Error
+}
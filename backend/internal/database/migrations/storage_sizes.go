@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/database"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// STORAGE_SIZES_SCHEMA_VERSION is the schema version that started recording the size of
+// thumbnails and HLS playlists.
+const STORAGE_SIZES_SCHEMA_VERSION uint = 2
+
+type thumbnailSize struct {
+	ID       uint
+	FilePath string
+	Bucket   string
+}
+
+type hlsSize struct {
+	ID       uint
+	FileCode string
+	Bucket   string
+}
+
+// backfillStorageSizes reads back from MinIO the size of the thumbnails and HLS playlists
+// generated before their size was recorded, so they count toward the quota of their owner.
+// Objects that can't be read are logged and left at 0.
+func backfillStorageSizes(db *gorm.DB) error {
+	var thumbnails []thumbnailSize
+	err := db.Table("thumbnails").
+		Select("thumbnails.id, thumbnails.file_path, users.minio_service_bucket AS bucket").
+		Joins("JOIN files ON files.id = thumbnails.file_id").
+		Joins("JOIN users ON users.id = files.user_id").
+		Where("thumbnails.file_size = 0").
+		Scan(&thumbnails).Error
+	if err != nil {
+		return err
+	}
+
+	var playlists []hlsSize
+	err = db.Table("files").
+		Select("files.id, files.file_code, users.minio_service_bucket AS bucket").
+		Joins("JOIN users ON users.id = files.user_id").
+		Where("files.file_type LIKE ? AND files.is_previewable = ? AND files.hls_size = 0", "video/%", true).
+		Scan(&playlists).Error
+	if err != nil {
+		return err
+	}
+
+	if len(thumbnails) == 0 && len(playlists) == 0 {
+		return nil
+	}
+
+	minioStorage, err := database.ConnectToMinIO()
+	if err != nil {
+		return err
+	}
+	minioClient := minioStorage.GetMinioClient()
+	ctx := context.Background()
+
+	for _, thumbnail := range thumbnails {
+		info, err := minioClient.StatObject(ctx, thumbnail.Bucket, thumbnail.FilePath, minio.StatObjectOptions{})
+		if err != nil {
+			log.Printf("(Migrate) Error while reading thumbnail size: %s -> %v\n", thumbnail.FilePath, err)
+			continue
+		}
+
+		if err := db.Table("thumbnails").Where("id = ?", thumbnail.ID).Update("file_size", info.Size).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, playlist := range playlists {
+		var size int64
+		for object := range minioClient.ListObjects(ctx, playlist.Bucket, minio.ListObjectsOptions{
+			Prefix:    "hls/" + playlist.FileCode + "/",
+			Recursive: true,
+		}) {
+			if object.Err != nil {
+				log.Printf("(Migrate) Error while listing HLS files: %s -> %v\n", playlist.FileCode, object.Err)
+				size = 0
+				break
+			}
+			size += object.Size
+		}
+
+		if size == 0 {
+			continue
+		}
+
+		if err := db.Table("files").Where("id = ?", playlist.ID).Update("hls_size", size).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
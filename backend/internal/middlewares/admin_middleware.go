@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminMiddleware only lets administrators through. It must come after JWTMiddleware.
+// The role is read from the database, so demoting a user takes effect right away.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("db").(*gorm.DB)
+		userClaims := c.MustGet("userClaims").(*utils.UserClaims)
+
+		var user models.User
+		if err := db.Select("id", "role").First(&user, userClaims.ID).Error; err != nil || user.Role != models.ROLE_ADMIN {
+			c.Status(http.StatusForbidden)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
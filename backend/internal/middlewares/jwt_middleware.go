@@ -4,30 +4,155 @@ import (
 	"net/http"
 	"strings"
 
-	_ "github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/services"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/gin-gonic/gin"
-	_ "gorm.io/gorm"
+	"gorm.io/gorm"
 )
 
+// RequestToken returns the access token of a request, from the token cookie or else from the
+// Authorization header.
+func RequestToken(c *gin.Context) string {
+	if tokenCookie, err := c.Cookie("token"); err == nil && tokenCookie != "" {
+		return tokenCookie
+	}
+
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// sessionOnlyRoutes are the route prefixes personal access tokens can't reach whatever their scope,
+// so a leaked token can't mint more tokens, manage sessions or use administrator rights.
+var sessionOnlyRoutes = []string{"/api/auth/", "/api/admin/"}
+
+// accountRoutes are the route prefixes personal access tokens can only read, so a leaked token
+// can't change the profile or the password, or delete the account.
+var accountRoutes = []string{"/api/users/"}
+
+// uploadScopeRoutes are the routes an upload-only token can reach: uploading files and creating
+// folders, and browsing the folder tree to find where to put them.
+var uploadScopeRoutes = map[string]bool{
+	http.MethodGet + " /api/folders":                true,
+	http.MethodGet + " /api/folders/:code":          true,
+	http.MethodGet + " /api/folders/:code/folders":  true,
+	http.MethodPost + " /api/folders/:code/files":   true,
+	http.MethodPost + " /api/folders/:code/folders": true,
+}
+
+// scopeAllows tells whether a personal access token of a scope can make a request.
+func scopeAllows(scope string, c *gin.Context) bool {
+	route := c.FullPath()
+	isRead := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+	for _, prefix := range sessionOnlyRoutes {
+		if strings.HasPrefix(route, prefix) {
+			return false
+		}
+	}
+
+	for _, prefix := range accountRoutes {
+		if strings.HasPrefix(route, prefix) && !isRead {
+			return false
+		}
+	}
+
+	switch scope {
+	case models.TOKEN_SCOPE_FULL:
+		return true
+	case models.TOKEN_SCOPE_READ:
+		return isRead
+	case models.TOKEN_SCOPE_UPLOAD:
+		return uploadScopeRoutes[c.Request.Method+" "+route]
+	}
+
+	return false
+}
+
+// personalAccessTokenMiddleware authenticates a request made with a personal access token, as long
+// as its scope allows the request.
+func personalAccessTokenMiddleware(c *gin.Context, db *gorm.DB, tokenString string) {
+	accessToken, err := services.NewPersonalAccessTokenService(db).Authenticate(tokenString, c.ClientIP())
+	if err != nil {
+		if _, ok := err.(*apperr.InvalidCredentialsError); ok {
+			c.Status(http.StatusUnauthorized)
+		} else {
+			c.Status(http.StatusInternalServerError)
+		}
+		c.Abort()
+		return
+	}
+
+	if !scopeAllows(accessToken.Scope, c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "The access token's scope does not allow this request",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("userClaims", &utils.UserClaims{
+		ID:            accessToken.User.ID,
+		Bucket:        accessToken.User.MinioBucket,
+		ServiceBucket: accessToken.User.MinioServiceBucket,
+		Scope:         accessToken.Scope,
+	})
+	c.Next()
+}
+
+// JWTMiddleware only lets requests with a valid access token through. The token must not be revoked
+// and its session must still be open, so revoking a session logs its device out right away.
+// Personal access tokens are accepted too, in the Authorization header.
 func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// db := c.MustGet("db").(*gorm.DB)
-		tokenString := strings.ReplaceAll(c.GetHeader("Authorization"), "Bearer ", "")
-		tokenCookie, err := c.Cookie("token")
+		db := c.MustGet("db").(*gorm.DB)
+
+		if headerToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); services.IsPersonalAccessToken(headerToken) {
+			personalAccessTokenMiddleware(c, db, headerToken)
+			return
+		}
 
+		tokenString := RequestToken(c)
+		if tokenString == "" {
+			c.Status(http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+
+		userClaims, err := utils.ParseToken(tokenString)
 		if err != nil {
 			c.Status(http.StatusUnauthorized)
 			c.Abort()
 			return
 		}
 
-		if tokenCookie != "" {
-			tokenString = tokenCookie
+		// Tokens without a jti can't be revoked, they are issued no more
+		if userClaims.Id == "" {
+			c.Status(http.StatusUnauthorized)
+			c.Abort()
+			return
 		}
 
-		userClaims, err := utils.ParseToken(tokenString)
+		revoked, err := services.NewTokenService(db).IsTokenRevoked(userClaims.Id)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+
+		if revoked {
+			c.Status(http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+
+		open, err := services.NewSessionService(db).IsSessionOpen(userClaims.ID, userClaims.SessionID)
 		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+
+		if !open {
 			c.Status(http.StatusUnauthorized)
 			c.Abort()
 			return
@@ -36,4 +161,4 @@ func JWTMiddleware() gin.HandlerFunc {
 		c.Set("userClaims", userClaims)
 		c.Next()
 	}
-}	
\ No newline at end of file
+}
@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// allowedByScope builds a router over a few real routes and tells whether scopeAllows lets a
+// token of the scope through.
+func allowedByScope(t *testing.T, scope, method, path string) bool {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	allowed := false
+	check := func(c *gin.Context) {
+		allowed = scopeAllows(scope, c)
+	}
+
+	router := gin.New()
+	router.GET("/api/folders/:code", check)
+	router.POST("/api/folders/:code/files", check)
+	router.DELETE("/api/files/:fileID", check)
+	router.GET("/api/users/me/usage", check)
+	router.PUT("/api/users/:userId", check)
+	router.DELETE("/api/users/:userId", check)
+	router.POST("/api/auth/tokens", check)
+	router.GET("/api/auth/sessions", check)
+	router.PUT("/api/admin/users/:userId/quota", check)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	return allowed
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		scope  string
+		method string
+		path   string
+		want   bool
+	}{
+		{models.TOKEN_SCOPE_READ, http.MethodGet, "/api/folders/abc", true},
+		{models.TOKEN_SCOPE_READ, http.MethodPost, "/api/folders/abc/files", false},
+		{models.TOKEN_SCOPE_READ, http.MethodGet, "/api/users/me/usage", true},
+		{models.TOKEN_SCOPE_UPLOAD, http.MethodGet, "/api/folders/abc", true},
+		{models.TOKEN_SCOPE_UPLOAD, http.MethodPost, "/api/folders/abc/files", true},
+		{models.TOKEN_SCOPE_UPLOAD, http.MethodDelete, "/api/files/1", false},
+		{models.TOKEN_SCOPE_UPLOAD, http.MethodGet, "/api/users/me/usage", false},
+		{models.TOKEN_SCOPE_FULL, http.MethodDelete, "/api/files/1", true},
+		{models.TOKEN_SCOPE_FULL, http.MethodGet, "/api/users/me/usage", true},
+		{"unknown", http.MethodGet, "/api/folders/abc", false},
+
+		// Account changes, tokens, sessions and administration take a login
+		{models.TOKEN_SCOPE_FULL, http.MethodPut, "/api/users/1", false},
+		{models.TOKEN_SCOPE_FULL, http.MethodDelete, "/api/users/1", false},
+		{models.TOKEN_SCOPE_FULL, http.MethodPost, "/api/auth/tokens", false},
+		{models.TOKEN_SCOPE_READ, http.MethodGet, "/api/auth/sessions", false},
+		{models.TOKEN_SCOPE_FULL, http.MethodPut, "/api/admin/users/1/quota", false},
+	}
+
+	for _, tt := range tests {
+		if got := allowedByScope(t, tt.scope, tt.method, tt.path); got != tt.want {
+			t.Errorf("scopeAllows(%s) of %s %s = %v, want %v", tt.scope, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
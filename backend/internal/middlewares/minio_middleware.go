@@ -19,6 +19,7 @@ func MinIOMiddleware(service services.Service, client *minio.Client) gin.Handler
 			Client: client,
 			Bucket: userClaims.Bucket,
 			ServiceBucket: userClaims.ServiceBucket,
+			SharedBucket: services.SharedBucketName(),
 		}
 
 		service.SetBucketClient(bucketClient)
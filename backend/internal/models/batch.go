@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BATCH_OP_MOVE       = "move"
+	BATCH_OP_TRASH      = "trash"
+	BATCH_OP_RESTORE    = "restore"
+	BATCH_OP_FAVORITE   = "favorite"
+	BATCH_OP_UNFAVORITE = "unfavorite"
+	BATCH_OP_TAG        = "tag"
+	BATCH_OP_UNTAG      = "untag"
+	BATCH_OP_DELETE     = "delete"
+	BATCH_OP_COPY       = "copy"
+
+	BATCH_ITEM_FILE   = "file"
+	BATCH_ITEM_FOLDER = "folder"
+
+	BATCH_ITEM_OK          = "ok"
+	BATCH_ITEM_FAILED      = "failed"
+	BATCH_ITEM_ROLLED_BACK = "rolled_back"
+	BATCH_ITEM_SKIPPED     = "skipped"
+
+	BATCH_JOB_PENDING   = "pending"
+	BATCH_JOB_RUNNING   = "running"
+	BATCH_JOB_COMPLETED = "completed"
+	BATCH_JOB_FAILED    = "failed"
+)
+
+// BatchBody applies one operation to many files and folders. TargetFolderCode is the
+// destination of a move or copy, "root" or empty for the root folder. Tags are added or removed
+// by tag and untag. OnConflict is the conflict policy of moves, restores and copies.
+// When Atomic is set, the batch is rolled back if any item fails.
+type BatchBody struct {
+	Operation        string   `validate:"required,oneof=move trash restore favorite unfavorite tag untag delete copy" json:"operation"`
+	FileCodes        []string `validate:"omitempty,dive,required" json:"file_codes"`
+	FolderCodes      []string `validate:"omitempty,dive,required" json:"folder_codes"`
+	TargetFolderCode string   `json:"target_folder_code,omitempty"`
+	Tags             []string `validate:"omitempty,dive,required" json:"tags,omitempty"`
+	OnConflict       string   `validate:"omitempty,oneof=fail rename overwrite skip" json:"on_conflict,omitempty"`
+	Atomic           bool     `json:"atomic,omitempty"`
+}
+
+// BatchItemResult is the outcome of the operation of a batch on one file or folder.
+type BatchItemResult struct {
+	Type   string `json:"type"`
+	Code   string `json:"code"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResult is the outcome of a batch, item by item. Items skipped by the conflict policy
+// count as succeeded.
+type BatchResult struct {
+	Operation string            `json:"operation" gorm:"type:varchar(20);not null"`
+	Total     int               `json:"total" gorm:"not null"`
+	Succeeded int               `json:"succeeded" gorm:"not null;default:0"`
+	Failed    int               `json:"failed" gorm:"not null;default:0"`
+	Skipped   int               `json:"skipped" gorm:"not null;default:0"`
+	Results   []BatchItemResult `json:"results" gorm:"type:longtext;serializer:json"`
+}
+
+// BatchJob is a batch too large to run within its request, run in the background instead.
+// Its counts are updated as items are processed, Results is saved once the job is finished.
+type BatchJob struct {
+	gorm.Model
+	UserID      uint      `json:"-" gorm:"not null;index"`
+	Code        string    `gorm:"type:varchar(100);uniqueIndex"`
+	Status      string    `gorm:"type:varchar(20);not null"`
+	Request     BatchBody `json:"-" gorm:"type:text;serializer:json"`
+	BatchResult `gorm:"embedded"`
+	Error       string `json:",omitempty" gorm:"type:text"`
+	FinishedAt  *time.Time
+}
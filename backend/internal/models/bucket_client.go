@@ -4,18 +4,23 @@ import (
 	"context"
 	"io"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/minio/minio-go/v7"
 )
 
+// SHARED_OBJECT_PREFIX marks the deduplicated objects kept in the bucket shared by all users.
+const SHARED_OBJECT_PREFIX = "shared/"
+
 // Create a struct that holds the client and bucket name
 type BucketClient struct {
 	Context context.Context
 	Client            *minio.Client
 	Bucket        string
 	ServiceBucket string
+	SharedBucket  string
 }
 
 func NewBucketClient(minioClient *minio.Client, userClaim utils.UserClaims) *BucketClient {
@@ -26,12 +31,20 @@ func NewBucketClient(minioClient *minio.Client, userClaim utils.UserClaims) *Buc
 	}
 }
 
+// BucketFor returns the bucket holding objectName, shared objects live outside of the user's bucket.
+func (bc *BucketClient) BucketFor(objectName string) string {
+	if bc.SharedBucket != "" && strings.HasPrefix(objectName, SHARED_OBJECT_PREFIX) {
+		return bc.SharedBucket
+	}
+	return bc.Bucket
+}
+
 func (bc *BucketClient) PutObject(objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
-	return bc.Client.PutObject(bc.Context, bc.Bucket, objectName, reader, objectSize, opts)
+	return bc.Client.PutObject(bc.Context, bc.BucketFor(objectName), objectName, reader, objectSize, opts)
 }
 
 func (bc *BucketClient) GetObject(objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
-	return bc.Client.GetObject(bc.Context, bc.Bucket, objectName, opts)
+	return bc.Client.GetObject(bc.Context, bc.BucketFor(objectName), objectName, opts)
 }
 
 func (bc *BucketClient) GetServiceObject(objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
@@ -44,7 +57,7 @@ func (bc *BucketClient) PutServiceObject(objectName string, reader io.Reader, ob
 }
 
 func (bc *BucketClient) RemoveObject(objectName string, opts minio.RemoveObjectOptions) error {
-	return bc.Client.RemoveObject(bc.Context, bc.Bucket, objectName, opts)
+	return bc.Client.RemoveObject(bc.Context, bc.BucketFor(objectName), objectName, opts)
 }
 
 func (bc *BucketClient) RemoveServiceObject(objectName string, opts minio.RemoveObjectOptions) error {
@@ -52,5 +65,19 @@ func (bc *BucketClient) RemoveServiceObject(objectName string, opts minio.Remove
 }
 
 func (bc *BucketClient) PresignedGetObject(objectName string, expires time.Duration, reqParams url.Values) (u *url.URL, err error) {
-	return bc.Client.PresignedGetObject(bc.Context, bc.Bucket, objectName, expires, reqParams)
-}
\ No newline at end of file
+	return bc.Client.PresignedGetObject(bc.Context, bc.BucketFor(objectName), objectName, expires, reqParams)
+}
+
+func (bc *BucketClient) CopyObject(srcObjectName, dstObjectName string) (minio.UploadInfo, error) {
+	return bc.Client.CopyObject(bc.Context,
+		minio.CopyDestOptions{Bucket: bc.BucketFor(dstObjectName), Object: dstObjectName},
+		minio.CopySrcOptions{Bucket: bc.BucketFor(srcObjectName), Object: srcObjectName},
+	)
+}
+
+func (bc *BucketClient) CopyServiceObject(srcObjectName, dstObjectName string) (minio.UploadInfo, error) {
+	return bc.Client.CopyObject(bc.Context,
+		minio.CopyDestOptions{Bucket: bc.ServiceBucket, Object: dstObjectName},
+		minio.CopySrcOptions{Bucket: bc.ServiceBucket, Object: srcObjectName},
+	)
+}
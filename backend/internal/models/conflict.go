@@ -0,0 +1,18 @@
+package models
+
+// Conflict policies decide what happens when a file or folder takes a name already used in its folder.
+const (
+	CONFLICT_FAIL      = "fail"      // The operation fails with a conflict
+	CONFLICT_RENAME    = "rename"    // The item is renamed "name (1).ext", "name (2).ext"...
+	CONFLICT_OVERWRITE = "overwrite" // The existing file gets the content as a new version, files only
+	CONFLICT_SKIP      = "skip"      // The operation leaves both items as they are
+)
+
+// IsConflictPolicy reports whether policy is one of the conflict policies.
+func IsConflictPolicy(policy string) bool {
+	switch policy {
+	case CONFLICT_FAIL, CONFLICT_RENAME, CONFLICT_OVERWRITE, CONFLICT_SKIP:
+		return true
+	}
+	return false
+}
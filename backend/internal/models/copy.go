@@ -0,0 +1,9 @@
+package models
+
+// CopyBody copies a file or folder into TargetFolderCode, "root" for the root folder.
+// Without a target, the copy is made next to the original. Name renames the copy,
+// it keeps the name of the original by default.
+type CopyBody struct {
+	TargetFolderCode string `json:"target_folder_code,omitempty"`
+	Name             string `validate:"omitempty,max=255" json:"name,omitempty"`
+}
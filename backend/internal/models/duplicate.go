@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DUPLICATE_SCAN_PENDING   = "pending"
+	DUPLICATE_SCAN_RUNNING   = "running"
+	DUPLICATE_SCAN_COMPLETED = "completed"
+	DUPLICATE_SCAN_FAILED    = "failed"
+)
+
+// DuplicateQuery configures how similar two images must be to be reported as duplicates.
+// Distance is the maximum number of differing bits between their perceptual hashes.
+type DuplicateQuery struct {
+	Distance *int `form:"distance" validate:"omitempty,min=0,max=20" json:"distance"`
+}
+
+// DuplicateCluster is a group of files holding the same or a similar picture.
+// Files are ordered from the best copy to the worst, Keep is the best one. Every file
+// is a duplicate of Keep itself, not only of another file of the cluster.
+type DuplicateCluster struct {
+	Keep  uint    `json:"keep"`
+	Files []*File `json:"files"`
+}
+
+// DuplicateScan is the latest search for the duplicates of a user. Scans hash the images in the
+// background, the files are clustered from their hashes whenever duplicates are listed or resolved.
+// Distance is the distance used when none is given then.
+type DuplicateScan struct {
+	gorm.Model
+	UserID     uint   `json:"-" gorm:"not null;uniqueIndex"`
+	Distance   int    `gorm:"not null"`
+	Status     string `gorm:"type:varchar(20);not null"`
+	Error      string `json:",omitempty" gorm:"type:text"`
+	FinishedAt *time.Time
+}
+
+// DuplicateList is the latest scan of a user along with the clusters found at Distance, Clusters
+// is empty until the scan is completed.
+type DuplicateList struct {
+	Scan     *DuplicateScan     `json:"scan"`
+	Distance int                `json:"distance"`
+	Clusters []DuplicateCluster `json:"clusters"`
+}
+
+// DuplicateResolveBody trashes every file of the clusters found at Distance but the best one.
+// Keep overrides the best file of the clusters containing one of the listed files, only the
+// files that are duplicates of the file kept are trashed then.
+type DuplicateResolveBody struct {
+	Distance *int   `validate:"omitempty,min=0,max=20" json:"distance"`
+	Keep     []uint `json:"keep"`
+}
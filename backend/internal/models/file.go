@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type FileUpdateBody struct {
 	FileName   string `validate:"required" json:"file_name"`
@@ -15,16 +19,43 @@ type FilePatchBody struct {
 	Restore    bool   `validate:"boolean" json:"is_restore,omitempty"`
 }
 
+// File is a file of a user. CorruptedAt is set by the scrub job while the stored
+// content doesn't match its checksum. Deduplicated isn't stored, it's only set in
+// upload responses when the uploaded content was already stored. TrashDaysRemaining
+// isn't stored either, it's only set in trash listings when trashed items expire.
+// Skipped is set when a name conflict left the file as it was.
 type File struct {
 	gorm.Model
-	UserID        uint       `gorm:"not null"`
-	FolderID      uint       `gorm:"not null"`
-	FileName      string     `gorm:"type:varchar(255);not null"`
-	FileCode      string     `gorm:"type:char(36);not null"`
-	FileSize      uint       `gorm:"not null"`
-	FileType      string     `gorm:"type:varchar(100);not null"`
-	IsFavorite    bool       `gorm:"not null;default:0"`
-	IsPreviewable bool       `gorm:"not null;default:0"`
-	Folder        *Folder    `gorm:"foreignKey:FolderID"`
-	Thumbnail     *Thumbnail `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE;"`
+	UserID             uint           `gorm:"not null"`
+	FolderID           uint           `gorm:"not null"`
+	FileName           string         `gorm:"type:varchar(255);not null"`
+	FileCode           string         `gorm:"type:char(36);not null"`
+	ObjectKey          string         `json:"-" gorm:"type:varchar(255)"`
+	ContentHash        string         `gorm:"type:char(64);index"`
+	ContentMD5         string         `gorm:"type:char(32)"`
+	PerceptualHash     *uint64        `json:"-"`
+	Version            uint           `gorm:"not null;default:1"`
+	FileSize           uint           `gorm:"not null"`
+	FileType           string         `gorm:"type:varchar(100);not null"`
+	IsFavorite         bool           `gorm:"not null;default:0"`
+	IsPreviewable      bool           `gorm:"not null;default:0"`
+	HLSSize            uint           `json:"-" gorm:"not null;default:0"`
+	Folder             *Folder        `gorm:"foreignKey:FolderID"`
+	Thumbnail          *Thumbnail     `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE;"`
+	Content            *FileContent   `json:"-" gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE;"`
+	Tags               []*Tag         `gorm:"many2many:file_tags;constraint:OnDelete:CASCADE;"`
+	Versions           []*FileVersion `json:"-" gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE;"`
+	CorruptedAt        *time.Time     `json:",omitempty" gorm:"index"`
+	Deduplicated       bool           `json:",omitempty" gorm:"-"`
+	TrashDaysRemaining *int           `json:",omitempty" gorm:"-"`
+	Skipped            bool           `json:",omitempty" gorm:"-"`
+}
+
+// StorageKey returns the key of the current content of the file in MinIO.
+// Files uploaded before versioning have no ObjectKey and are stored under their code.
+func (f *File) StorageKey() string {
+	if f.ObjectKey == "" {
+		return f.FileCode
+	}
+	return f.ObjectKey
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// FileContent holds the plain text extracted from a document so it can be
+// searched by what's inside it, not only by its name.
+type FileContent struct {
+	ID        uint   `gorm:"primarykey"`
+	FileID    uint   `gorm:"not null;uniqueIndex"`
+	UserID    uint   `gorm:"not null;index"`
+	Content   string `gorm:"type:longtext;index:idx_file_contents_content,class:FULLTEXT"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
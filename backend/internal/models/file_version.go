@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FileVersion is an older content of a file. The current content stays on the
+// File itself, a FileVersion is created every time it gets replaced.
+type FileVersion struct {
+	gorm.Model
+	FileID      uint       `gorm:"not null;index"`
+	UserID      uint       `gorm:"not null;index"`
+	Version     uint       `gorm:"not null"`
+	ObjectKey   string     `json:"-" gorm:"type:varchar(255);not null"`
+	ContentHash string     `gorm:"type:char(64)"`
+	ContentMD5  string     `gorm:"type:char(32)"`
+	FileSize    uint       `gorm:"not null"`
+	FileType    string     `gorm:"type:varchar(100);not null"`
+	CorruptedAt *time.Time `json:",omitempty"`
+}
+
+type FileVersionList struct {
+	Current  *File          `json:"current"`
+	Versions []*FileVersion `json:"versions"`
+}
+
+type FileVersionPruneBody struct {
+	KeepLatest *uint  `validate:"omitempty,min=0" json:"keep_latest"`
+	Versions   []uint `validate:"omitempty,dive,min=1" json:"versions"`
+}
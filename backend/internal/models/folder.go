@@ -6,15 +6,18 @@ import (
 
 type Folder struct {
 	gorm.Model
-	UserID       uint `gorm:"not null"`
-	ParentID     *uint
-	Name         string    `gorm:"type:varchar(255);not null"`
-	Code         string    `gorm:"type:varchar(100)"`
-	HasChild     bool      `gorm:"default:0"`
-	IsFavorite   bool      `gorm:"default:0"`
-	ChildFolders []*Folder `gorm:"foreignKey:ParentID"`
-	Files        []*File   `gorm:"foreignKey:FolderID"`
-	ParentFolder *Folder   `gorm:"foreignKey:ParentID"` // Root folder does not have a parent, so its nil-able
+	UserID             uint `gorm:"not null"`
+	ParentID           *uint
+	Name               string       `gorm:"type:varchar(255);not null"`
+	Code               string       `gorm:"type:varchar(100)"`
+	HasChild           bool         `gorm:"default:0"`
+	IsFavorite         bool         `gorm:"default:0"`
+	ChildFolders       []*Folder    `gorm:"foreignKey:ParentID"`
+	Files              []*File      `gorm:"foreignKey:FolderID"`
+	ParentFolder       *Folder      `gorm:"foreignKey:ParentID"` // Root folder does not have a parent, so its nil-able
+	TrashDaysRemaining *int         `json:",omitempty" gorm:"-"` // Only set in trash listings when trashed items expire
+	Skipped            bool         `json:",omitempty" gorm:"-"` // Only set when a name conflict left the folder as it was
+	Stats              *FolderStats `json:",omitempty" gorm:"-"` // Only set in folder details
 }
 
 type FolderHierarchy struct {
@@ -25,6 +28,8 @@ type FolderHierarchy struct {
 type FolderResponse struct {
 	Folders []*Folder `json:"folders"`
 	Hierarchies []FolderHierarchy `json:"hierarchies"`
+	Total int64 `json:"total"`
+	NextCursor string `json:"next_cursor"`
 }
 
 type FolderUpdateBody struct {
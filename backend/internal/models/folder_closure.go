@@ -0,0 +1,11 @@
+package models
+
+// FolderClosure links a folder to each of its ancestors, and to itself at depth 0, so a whole
+// branch of the folder hierarchy is read or changed in a single query.
+type FolderClosure struct {
+	AncestorID   uint    `gorm:"primaryKey;autoIncrement:false"`
+	DescendantID uint    `gorm:"primaryKey;autoIncrement:false;index"`
+	Depth        uint    `gorm:"not null"`
+	Ancestor     *Folder `json:"-" gorm:"foreignKey:AncestorID;constraint:OnDelete:CASCADE;"`
+	Descendant   *Folder `json:"-" gorm:"foreignKey:DescendantID;constraint:OnDelete:CASCADE;"`
+}
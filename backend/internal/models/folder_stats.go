@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+	MEDIA_TYPE_IMAGE    = "image"
+	MEDIA_TYPE_VIDEO    = "video"
+	MEDIA_TYPE_AUDIO    = "audio"
+	MEDIA_TYPE_DOCUMENT = "document"
+	MEDIA_TYPE_OTHER    = "other"
+)
+
+// MediaTypeStats is the number and size, in bytes, of the files of one media type in a folder tree.
+type MediaTypeStats struct {
+	MediaType string `json:"media_type"`
+	FileCount int64  `json:"file_count"`
+	Size      int64  `json:"size"`
+}
+
+// FolderStats sums up a folder and everything inside it, trashed items left out. Size only counts
+// the original files, FolderCount doesn't count the folder itself. LastModified is the newest
+// modification of the folder or anything inside it.
+type FolderStats struct {
+	Size         int64            `json:"size"`
+	FileCount    int64            `json:"file_count"`
+	FolderCount  int64            `json:"folder_count"`
+	MediaTypes   []MediaTypeStats `json:"media_types"`
+	LastModified *time.Time       `json:"last_modified"`
+}
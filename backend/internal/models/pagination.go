@@ -0,0 +1,16 @@
+package models
+
+// ListParams are the pagination, sorting and filtering options shared by every listing endpoint.
+type ListParams struct {
+	Cursor string `form:"cursor" json:"cursor,omitempty"`
+	Limit  int    `form:"limit" validate:"omitempty,min=1,max=500" json:"limit,omitempty"`
+	Sort   string `form:"sort" validate:"omitempty,oneof=name size created modified type" json:"sort,omitempty"`
+	Order  string `form:"order" validate:"omitempty,oneof=asc desc" json:"order,omitempty"`
+	Type   string `form:"type" validate:"omitempty,oneof=image video audio document archive" json:"type,omitempty"`
+}
+
+type FileListResponse struct {
+	Files      []*File `json:"files"`
+	Total      int64   `json:"total"`
+	NextCursor string  `json:"next_cursor"`
+}
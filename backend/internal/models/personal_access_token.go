@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	TOKEN_SCOPE_READ   = "read"
+	TOKEN_SCOPE_UPLOAD = "upload"
+	TOKEN_SCOPE_FULL   = "full"
+)
+
+// PersonalAccessToken is a long-lived token scripts authenticate with instead of a password.
+// Only its SHA-256 hash is stored, Prefix is the start of the token to tell tokens apart.
+type PersonalAccessToken struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uint       `json:"-" gorm:"index;not null"`
+	Name       string     `gorm:"type:varchar(100);not null"`
+	Prefix     string     `gorm:"type:varchar(16);not null"`
+	TokenHash  string     `json:"-" gorm:"type:char(64);uniqueIndex;not null"`
+	Scope      string     `gorm:"type:varchar(20);not null"`
+	ExpiresAt  *time.Time // Never expires when unset
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"type:varchar(45)"`
+	User       *User  `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type PersonalAccessTokenBody struct {
+	Name          string `validate:"required,max=100"`
+	Scope         string `validate:"required,oneof=read upload full"`
+	ExpiresInDays int    `json:"expires_in_days" validate:"omitempty,min=1,max=3650"`
+}
+
+// PersonalAccessTokenCreated is the response to a new token, the only time the token itself is shown.
+type PersonalAccessTokenCreated struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
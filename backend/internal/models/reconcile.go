@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+const (
+	RECONCILE_KIND_FILE          = "file"
+	RECONCILE_KIND_VERSION       = "version"
+	RECONCILE_KIND_STORED_OBJECT = "stored_object"
+	RECONCILE_KIND_THUMBNAIL     = "thumbnail"
+	RECONCILE_KIND_HLS           = "hls"
+	RECONCILE_KIND_OBJECT        = "object"
+	RECONCILE_KIND_TEMP          = "temp"
+)
+
+// ReconcileIssue is a single difference found between MinIO, MariaDB and the temporary directory.
+// Key is an object key, or a path for temporary files.
+type ReconcileIssue struct {
+	Kind     string `json:"kind"`
+	Bucket   string `json:"bucket,omitempty"`
+	Key      string `json:"key"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReconcileReport lists everything a reconciliation run found, and whether it was repaired.
+type ReconcileReport struct {
+	Repair             bool             `json:"repair"`
+	StartedAt          time.Time        `json:"started_at"`
+	FinishedAt         time.Time        `json:"finished_at"`
+	OrphanObjects      []ReconcileIssue `json:"orphan_objects"`
+	MissingObjects     []ReconcileIssue `json:"missing_objects"`
+	RefCountMismatches []ReconcileIssue `json:"ref_count_mismatches"`
+	StaleTempPaths     []ReconcileIssue `json:"stale_temp_paths"`
+}
+
+// IssueCount returns the number of issues found.
+func (r *ReconcileReport) IssueCount() int {
+	return len(r.OrphanObjects) + len(r.MissingObjects) + len(r.RefCountMismatches) + len(r.StaleTempPaths)
+}
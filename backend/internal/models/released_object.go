@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// ReleasedObject is an object whose last reference was dropped. It is removed from MinIO only
+// once the transaction that released it is committed, so a rollback never loses content.
+type ReleasedObject struct {
+	ID        uint   `gorm:"primarykey"`
+	Bucket    string `gorm:"type:varchar(63);not null"`
+	ObjectKey string `gorm:"type:varchar(255);not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SchemaVersion records the schema version a database was last migrated to.
+type SchemaVersion struct {
+	ID         uint `gorm:"primarykey"`
+	Version    uint `gorm:"not null"`
+	MigratedAt time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+// SearchQuery describes which files to look for. It is used both for ad-hoc
+// searches and as the stored definition of a smart folder.
+type SearchQuery struct {
+	Query         string   `form:"q" json:"query,omitempty"`
+	MediaType     string   `form:"type" validate:"omitempty,oneof=image video audio document archive" json:"media_type,omitempty"`
+	MinSize       *uint    `form:"min_size" json:"min_size,omitempty"`
+	MaxSize       *uint    `form:"max_size" json:"max_size,omitempty"`
+	CreatedAfter  string   `form:"created_after" validate:"omitempty,datetime=2006-01-02" json:"created_after,omitempty"`
+	CreatedBefore string   `form:"created_before" validate:"omitempty,datetime=2006-01-02" json:"created_before,omitempty"`
+	Tags          []string `form:"tags" json:"tags,omitempty"`
+	FavoriteOnly  bool     `form:"favorite" json:"favorite_only,omitempty"`
+}
+
+// IsEmpty reports whether the query has no criteria at all.
+func (sq SearchQuery) IsEmpty() bool {
+	return sq.Query == "" && sq.MediaType == "" && sq.MinSize == nil && sq.MaxSize == nil &&
+		sq.CreatedAfter == "" && sq.CreatedBefore == "" && len(sq.Tags) == 0 && !sq.FavoriteOnly
+}
+
+type SearchResult struct {
+	File     *File    `json:"file"`
+	Snippets []string `json:"snippets"`
+}
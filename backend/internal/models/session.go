@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Session is a login on a device. It lives as long as its refresh tokens keep being rotated,
+// until it expires or is revoked.
+type Session struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uint   `gorm:"index;not null"`
+	Device     string `gorm:"type:varchar(255)"`
+	IP         string `gorm:"type:varchar(45)"`
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time `json:"-" gorm:"index"`
+	Current    bool       `gorm:"-"`
+	User       *User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// RefreshToken is a refresh token issued for a session. Only its SHA-256 hash is stored.
+// A token is used once, refreshing rotates it and sets RotatedAt.
+type RefreshToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	SessionID uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"type:char(64);uniqueIndex;not null"`
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	Session   *Session `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// AuthTokens is the response of a login or a refresh. When the user has two-factor authentication,
+// the login only returns a ChallengeToken and the methods it can be answered with, the tokens come
+// once the second factor is checked.
+type AuthTokens struct {
+	AccessToken       string   `json:"token,omitempty"`
+	RefreshToken      string   `json:"refresh_token,omitempty"`
+	ExpiresIn         int64    `json:"expires_in,omitempty"`
+	TwoFactorRequired bool     `json:"two_factor_required,omitempty"`
+	TwoFactorMethods  []string `json:"two_factor_methods,omitempty"`
+	ChallengeToken    string   `json:"challenge_token,omitempty"`
+}
+
+type RefreshBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
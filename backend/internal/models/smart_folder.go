@@ -0,0 +1,18 @@
+package models
+
+import "gorm.io/gorm"
+
+// SmartFolder is a saved search. Its files are not stored, they are evaluated
+// from Query every time the smart folder is opened.
+type SmartFolder struct {
+	gorm.Model
+	UserID uint        `gorm:"not null;index"`
+	Name   string      `gorm:"type:varchar(255);not null"`
+	Code   string      `gorm:"type:varchar(100);uniqueIndex"`
+	Query  SearchQuery `gorm:"type:text;serializer:json"`
+}
+
+type SmartFolderBody struct {
+	Name  string      `validate:"required" json:"name"`
+	Query SearchQuery `json:"query"`
+}
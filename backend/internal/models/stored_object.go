@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// StoredObject is a deduplicated object. Identical contents are stored once under
+// the SHA-256 of their bytes and shared by every file and version referencing them.
+//
+// CheckedAt is the last time the scrub job read the object back, CorruptedAt is set
+// while the object doesn't match its hash.
+type StoredObject struct {
+	ID          uint       `gorm:"primarykey"`
+	Bucket      string     `gorm:"type:varchar(63);not null;uniqueIndex:idx_stored_objects_bucket_hash;index:idx_stored_objects_bucket_key"`
+	Hash        string     `gorm:"type:char(64);not null;uniqueIndex:idx_stored_objects_bucket_hash"`
+	MD5         string     `gorm:"type:char(32)"`
+	ObjectKey   string     `gorm:"type:varchar(255);not null;index:idx_stored_objects_bucket_key"`
+	Size        uint       `gorm:"not null"`
+	RefCount    uint       `gorm:"not null;default:0"`
+	CheckedAt   *time.Time `gorm:"index"`
+	CorruptedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+type Tag struct {
+	ID     uint   `gorm:"primarykey"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_tags_user_name"`
+	Name   string `gorm:"type:varchar(100);not null;uniqueIndex:idx_tags_user_name"`
+}
+
+type FileTagsBody struct {
+	Tags []string `validate:"dive,required,max=100" json:"tags"`
+}
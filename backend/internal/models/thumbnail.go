@@ -6,4 +6,5 @@ type Thumbnail struct {
 	gorm.Model
 	FileID   uint   `gorm:"not null"`
 	FilePath string `gorm:"type:varchar(255);not null"`
+	FileSize uint   `gorm:"not null;default:0"`
 }
@@ -2,7 +2,8 @@ package models
 
 import "time"
 
+// Token is a revoked access token, by its jti. It's kept until the token expires.
 type Token struct {
-	Token  string `gorm:"type:varchar(255)"`
+	Token  string `gorm:"type:varchar(255);index"`
 	ExpirationDate time.Time
 }
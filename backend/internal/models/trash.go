@@ -0,0 +1,28 @@
+package models
+
+// TrashRetention is how long trashed items are kept before being purged.
+// Days is nil when they are kept until the trash is emptied.
+type TrashRetention struct {
+	Days      *uint `json:"days"`
+	IsDefault bool  `json:"is_default"`
+}
+
+// TrashRetentionBody overrides the trash retention of a user. A nil Days reverts
+// the user to the default retention, 0 keeps trashed items until the trash is emptied.
+type TrashRetentionBody struct {
+	Days *uint `validate:"omitempty,max=3650" json:"days"`
+}
+
+// TrashListResponse is a page of the unified trash. Folders holds every trashed folder that
+// isn't inside another trashed folder, it is only filled on the first page. Files are paginated
+// and only hold the trashed files that aren't inside a trashed folder.
+type TrashListResponse struct {
+	Folders []*Folder `json:"folders"`
+	FileListResponse
+}
+
+// TrashPurgeResponse counts what emptying the trash deleted permanently.
+type TrashPurgeResponse struct {
+	PurgedFolders int `json:"purged_folders"`
+	PurgedFiles   int `json:"purged_files"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// TwoFactor is the TOTP setup of a user. It waits with EnabledAt unset until the user proves
+// their authenticator app works by giving a first code.
+type TwoFactor struct {
+	UserID         uint   `gorm:"primarykey;autoIncrement:false"`
+	Secret         string `gorm:"type:varchar(64);not null"`
+	EnabledAt      *time.Time
+	LastUsedStep   int64 `gorm:"not null;default:0"` // Codes of this step or before are refused, so a code works once
+	FailedAttempts int   `gorm:"not null;default:0"`
+	LockedUntil    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	User           *User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// RecoveryCode is a one-time code logging in when the authenticator app is lost.
+// Only its SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID       uint   `gorm:"primarykey"`
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"type:char(64);not null"`
+	UsedAt   *time.Time
+	User     *User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorCodeBody struct {
+	Code string `validate:"required"`
+}
+
+type TwoFactorLoginBody struct {
+	ChallengeToken string `json:"challenge_token"` // Read from its cookie after a login with a provider
+	Code           string `validate:"required"`
+}
+
+// PasswordBody confirms a sensitive change. Users without a password leave it out, they log in again instead.
+type PasswordBody struct {
+	Password string
+}
+
+// ReauthBody confirms a sensitive change with the password, or else a fresh TOTP or recovery code.
+// Users without a password may leave both out, they log in again instead.
+type ReauthBody struct {
+	Password string
+	Code     string
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
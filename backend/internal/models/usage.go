@@ -0,0 +1,13 @@
+package models
+
+// StorageUsage is the storage used by a user, in bytes, broken down by category.
+type StorageUsage struct {
+	Originals  int64 `json:"originals"`
+	Versions   int64 `json:"versions"`
+	Thumbnails int64 `json:"thumbnails"`
+	HLS        int64 `json:"hls"`
+	Total      int64 `json:"total"`
+	// Quota and Remaining are nil when the user has no quota
+	Quota     *int64 `json:"quota"`
+	Remaining *int64 `json:"remaining"`
+}
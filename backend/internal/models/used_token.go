@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// UsedToken is a single-use token that was used, by its jti. It's kept until the token expires.
+type UsedToken struct {
+	ID        string    `gorm:"type:varchar(64);primarykey"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
@@ -1,6 +1,18 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ROLE_USER  = "user"
+	ROLE_ADMIN = "admin"
+
+	AUTH_SOURCE_LOCAL = "local"
+	AUTH_SOURCE_LDAP  = "ldap"
+)
 
 type UserBody struct {
 	FirstName string `json:"first_name" validate:"required,ascii"`
@@ -11,12 +23,52 @@ type UserBody struct {
 
 type User struct {
 	gorm.Model
-	FirstName          string `gorm:"type:varchar(50)"`
-	LastName           string `gorm:"type:varchar(50)"`
-	Email              string `gorm:"unique;type:varchar(255)"`
+	FirstName          string  `gorm:"type:varchar(50)"`
+	LastName           string  `gorm:"type:varchar(50)"`
+	Email              string  `gorm:"unique;type:varchar(255)"`
+	PendingEmail       *string `json:",omitempty" gorm:"type:varchar(255)"` // Set while an email change waits for verification
+	EmailVerifiedAt    *time.Time
+	WebAuthnHandle     []byte `json:"-" gorm:"type:varbinary(64);index"` // Random user handle of the passkeys, set on the first one
 	Password           string `json:"-" gorm:"min:6;type:varchar(64)"`
 	MinioBucket        string `json:"-"`
 	MinioServiceBucket string `json:"-"`
+	Role               string `gorm:"type:varchar(20);not null;default:user"`
+	AuthSource         string `gorm:"type:varchar(20);not null;default:local"` // Where the password is checked, "ldap" users have none here
+	QuotaBytes         *int64
+	TrashRetentionDays *uint
 	Folders            []*Folder
 	Files              []*File
 }
+
+type UserQuotaBody struct {
+	QuotaBytes *int64 `validate:"omitempty,min=0" json:"quota_bytes"`
+}
+
+type UserUpdateBody struct {
+	FirstName *string `json:"first_name" validate:"omitempty,ascii"`
+	LastName  *string `json:"last_name" validate:"omitempty,ascii"`
+	Email     *string `validate:"omitempty,email"`
+}
+
+type PasswordChangeBody struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+// AccountDeleteBody confirms an account deletion. Users without a password leave it out, they log in again instead.
+type AccountDeleteBody struct {
+	Password string
+}
+
+type TokenBody struct {
+	Token string `validate:"required"`
+}
+
+type EmailBody struct {
+	Email string `validate:"required,email"`
+}
+
+type PasswordResetBody struct {
+	Token       string `validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=6"`
+}
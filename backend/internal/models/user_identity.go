@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to their account at an OpenID Connect provider, by the provider's
+// subject identifier, which unlike the email never changes.
+type UserIdentity struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uint   `json:"-" gorm:"index;not null"`
+	Provider    string `gorm:"type:varchar(50);not null;uniqueIndex:idx_user_identities_subject"`
+	Subject     string `json:"-" gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identities_subject"`
+	Email       string `gorm:"type:varchar(255)"` // Email at the provider when the identity was last used
+	LastLoginAt *time.Time
+	User        *User `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type OIDCProvider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
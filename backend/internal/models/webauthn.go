@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthnCredential is a hardware key or a passkey of a user. Credential is what the WebAuthn
+// library needs to check assertions, CredentialHash is the SHA-256 of its ID to look it up by.
+type WebAuthnCredential struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	UserID         uint                `json:"-" gorm:"index;not null"`
+	Name           string              `gorm:"type:varchar(100);not null"`
+	CredentialHash string              `json:"-" gorm:"type:char(64);uniqueIndex;not null"`
+	Credential     webauthn.Credential `json:"-" gorm:"type:text;serializer:json"`
+	Discoverable   bool                // Set when the credential can log in without a password, as a passkey
+	LastUsedAt     *time.Time
+	User           *User `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// WebAuthnCeremony is the first step of a registration or a login: the options for the browser's
+// navigator.credentials call, and the token to send back with its result.
+type WebAuthnCeremony struct {
+	Options       interface{} `json:"options"`
+	CeremonyToken string      `json:"ceremony_token"`
+}
+
+type WebAuthnRegistrationBody struct {
+	CeremonyToken string          `json:"ceremony_token" validate:"required"`
+	Name          string          `validate:"omitempty,max=100"`
+	Credential    json.RawMessage `validate:"required"`
+}
+
+type WebAuthnLoginBody struct {
+	CeremonyToken  string          `json:"ceremony_token" validate:"required"`
+	ChallengeToken string          `json:"challenge_token"` // Only when the passkey is a second factor
+	Credential     json.RawMessage `validate:"required"`
+}
+
+type WebAuthnCredentialBody struct {
+	Name string `validate:"required,max=100"`
+}
+
+type WebAuthnChallengeBody struct {
+	ChallengeToken string `json:"challenge_token"` // Read from its cookie after a login with a provider
+}
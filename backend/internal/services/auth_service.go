@@ -2,17 +2,15 @@ package services
 
 import (
 	"errors"
-	"time"
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
-	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
-	"github.com/golang-jwt/jwt"
 	"gorm.io/gorm"
 )
 
 type AuthService struct {
-	DB *gorm.DB
+	DB             *gorm.DB
+	Authenticators []Authenticator
 }
 
 func NewAuthService(db *gorm.DB) *AuthService {
@@ -21,53 +19,168 @@ func NewAuthService(db *gorm.DB) *AuthService {
 	}
 }
 
-func (authS *AuthService) Login(email, password string) (string, error) {
-	var user models.User
-	if err := authS.DB.First(&user, "email = ?", email).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", &apperr.NotFoundError{
-				BaseError: &apperr.BaseError{
-					Message: "User not found",
-					Err: err,
-				},
-			}
-		}
+// Login checks the credentials of a user with the authenticator chain and opens a session for the
+// device logging in, with a short-lived access token and a refresh token. Users with two-factor
+// authentication get a challenge token instead, the session is opened by LoginWithTwoFactor.
+//
+// If no authenticator knows the user, it returns a NotFoundError.
+// If the password is wrong, it returns an InvalidCredentialsError.
+// If the email must be verified first, it returns a ForbiddenError.
+// If other errors occur, it returns a ServerError.
+func (authS *AuthService) Login(email, password, device, ip string) (*models.AuthTokens, error) {
+	user, err := authS.authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
 
-		return "", &apperr.ServerError{
+	if RequireEmailVerification() && user.EmailVerifiedAt == nil {
+		return nil, &apperr.ForbiddenError{
 			BaseError: &apperr.BaseError{
-				Message: "Internal server error ocurred",
-				Err: err,
+				Message: "Email not verified",
 			},
 		}
 	}
 
-	if !utils.CheckPassword(password, user.Password) {
-		return "", &apperr.InvalidCredentialsError{
-			BaseError: &apperr.BaseError{
-				Message: "Invalid credentials",
-			},
+	return authS.CompleteLogin(user, device, ip)
+}
+
+func (authS *AuthService) authenticators() []Authenticator {
+	if len(authS.Authenticators) == 0 {
+		return []Authenticator{NewLocalAuthenticator(authS.DB)}
+	}
+	return authS.Authenticators
+}
+
+// authenticate asks the authenticators in turn, until one knows the email.
+func (authS *AuthService) authenticate(email, password string) (*models.User, error) {
+	for _, authenticator := range authS.authenticators() {
+		user, err := authenticator.Authenticate(email, password)
+		if err != nil {
+			return nil, err
+		}
+
+		if user != nil {
+			return user, nil
 		}
 	}
 
-	userClaim := utils.UserClaims{
-		ID: user.ID,
-		Bucket: user.MinioBucket,
-		ServiceBucket: user.MinioServiceBucket,
-		StandardClaims: jwt.StandardClaims{
-			IssuedAt:  time.Now().Unix(),
-			ExpiresAt: time.Now().Add(time.Minute * 60).Unix(),
+	return nil, &apperr.NotFoundError{
+		BaseError: &apperr.BaseError{
+			Message: "User not found",
 		},
 	}
+}
 
-	accessToken, err := utils.GenerateToken(userClaim)
+// CompleteLogin opens a session for a user whose first factor was checked, by password or by
+// single sign-on. Users with two-factor authentication get a challenge token instead.
+//
+// If any errors occur, it returns a ServerError.
+func (authS *AuthService) CompleteLogin(user *models.User, device, ip string) (*models.AuthTokens, error) {
+	// The first factor is only the first step with two-factor authentication
+	twoFactorService := NewTwoFactorService(authS.DB)
+	methods, err := twoFactorService.Methods(user.ID)
 	if err != nil {
-		return "", &apperr.ServerError{
+		return nil, err
+	}
+
+	if len(methods) > 0 {
+		challengeToken, err := twoFactorService.NewChallenge(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &models.AuthTokens{
+			TwoFactorRequired: true,
+			TwoFactorMethods:  methods,
+			ChallengeToken:    challengeToken,
+		}, nil
+	}
+
+	return NewSessionService(authS.DB).StartSession(user, device, ip)
+}
+
+// LoginWithTwoFactor finishes a login of a user with two-factor authentication, with the challenge
+// token Login returned and a TOTP or recovery code.
+//
+// If the challenge token or the code is invalid, it returns an InvalidCredentialsError.
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (authS *AuthService) LoginWithTwoFactor(challengeToken, code, device, ip string) (*models.AuthTokens, error) {
+	userID, err := NewTwoFactorService(authS.DB).VerifyChallenge(challengeToken, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return authS.startUserSession(userID, device, ip)
+}
+
+// LoginWithSecurityKey finishes a login of a user with two-factor authentication, with the challenge
+// token Login returned and the assertion of one of their hardware keys or passkeys. The challenge
+// token is used up once the assertion is verified.
+//
+// If the challenge token or the assertion is invalid, it returns an InvalidCredentialsError.
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (authS *AuthService) LoginWithSecurityKey(body models.WebAuthnLoginBody, device, ip string) (*models.AuthTokens, error) {
+	claims, err := parseChallenge(body.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := NewWebAuthnService(authS.DB).FinishSecondFactor(claims.UserID, body); err != nil {
+		return nil, err
+	}
+
+	if err := useToken(authS.DB, claims); err != nil {
+		return nil, err
+	}
+
+	return authS.startUserSession(claims.UserID, device, ip)
+}
+
+// LoginWithPasskey logs a user in without a password, with the assertion of a passkey. The passkey
+// verifies the user itself, so no second factor is asked.
+//
+// If the ceremony token or the assertion is invalid, it returns an InvalidCredentialsError.
+// If the email must be verified first, it returns a ForbiddenError.
+// If other errors occur, it returns a ServerError.
+func (authS *AuthService) LoginWithPasskey(body models.WebAuthnLoginBody, device, ip string) (*models.AuthTokens, error) {
+	user, err := NewWebAuthnService(authS.DB).FinishLogin(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if RequireEmailVerification() && user.EmailVerifiedAt == nil {
+		return nil, &apperr.ForbiddenError{
+			BaseError: &apperr.BaseError{
+				Message: "Email not verified",
+			},
+		}
+	}
+
+	return NewSessionService(authS.DB).StartSession(user, device, ip)
+}
+
+// startUserSession opens a session for a user once their second factor is checked.
+func (authS *AuthService) startUserSession(userID uint, device, ip string) (*models.AuthTokens, error) {
+	var user models.User
+	if err := authS.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "User not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
-				Err: err,
+				Err:     err,
 			},
 		}
 	}
 
-	return accessToken, nil
-}
\ No newline at end of file
+	return NewSessionService(authS.DB).StartSession(&user, device, ip)
+}
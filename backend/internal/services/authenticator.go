@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	AUTH_BACKEND_LOCAL    = "local"
+	AUTH_BACKEND_LDAP     = "ldap"
+	DEFAULT_AUTH_BACKENDS = AUTH_BACKEND_LOCAL
+)
+
+// Authenticator checks the email and password of a login. Login asks the authenticators in turn:
+// one that doesn't know the email returns a nil user, and the next one is asked.
+type Authenticator interface {
+	// Authenticate returns the user the credentials belong to, or nil when the email is unknown.
+	// A known email with a wrong password is an InvalidCredentialsError.
+	Authenticate(email, password string) (*models.User, error)
+}
+
+// AuthenticatorsFromEnv returns the authenticator chain named in AUTH_BACKENDS (comma separated),
+// "local" by default. "local" checks the passwords stored here, "ldap" binds to the directory
+// configured by the LDAP_* variables.
+func AuthenticatorsFromEnv(db *gorm.DB, userService *UserService) ([]Authenticator, error) {
+	backends := os.Getenv("AUTH_BACKENDS")
+	if backends == "" {
+		backends = DEFAULT_AUTH_BACKENDS
+	}
+
+	authenticators := []Authenticator{}
+	for _, backend := range strings.Split(backends, ",") {
+		switch strings.TrimSpace(backend) {
+		case AUTH_BACKEND_LOCAL:
+			authenticators = append(authenticators, NewLocalAuthenticator(db))
+		case AUTH_BACKEND_LDAP:
+			config, err := LDAPConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, NewLDAPAuthenticator(db, userService, config))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown auth backend %q", backend)
+		}
+	}
+
+	if len(authenticators) == 0 {
+		return nil, errors.New("no auth backend configured")
+	}
+
+	return authenticators, nil
+}
+
+// LocalAuthenticator checks the bcrypt password hashes stored with the users.
+type LocalAuthenticator struct {
+	DB *gorm.DB
+}
+
+func NewLocalAuthenticator(db *gorm.DB) *LocalAuthenticator {
+	return &LocalAuthenticator{
+		DB: db,
+	}
+}
+
+// Authenticate checks the password of a local user. Users of another source, like the directory,
+// are left to their authenticator.
+//
+// If the password is wrong, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (la *LocalAuthenticator) Authenticate(email, password string) (*models.User, error) {
+	var user models.User
+	if err := la.DB.First(&user, "email = ?", email).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	if user.AuthSource != models.AUTH_SOURCE_LOCAL {
+		return nil, nil
+	}
+
+	if !utils.CheckPassword(password, user.Password) {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid credentials",
+			},
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,623 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// Batches with more items than this run in the background
+	BATCH_SYNC_LIMIT = 100
+	MAX_BATCH_SIZE   = 10000
+	// Background jobs save their progress every this many items
+	BATCH_PROGRESS_INTERVAL = 25
+)
+
+var (
+	errBatchRolledBack  = errors.New("batch rolled back")
+	errBatchItemSkipped = errors.New("batch item skipped")
+)
+
+type BatchService struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func (bs *BatchService) SetDB(db *gorm.DB) {
+	bs.DB = db
+}
+
+func (bs *BatchService) SetBucketClient(bc *models.BucketClient) {
+	bs.BucketClient = bc
+}
+
+func NewBatchService(db *gorm.DB, bc *models.BucketClient) *BatchService {
+	return &BatchService{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+type batchItem struct {
+	Type string
+	Code string
+}
+
+// batchItems lists the items of a batch, files first. Files inside a folder of the same
+// batch are then handled on their own before the folder.
+func batchItems(body models.BatchBody) []batchItem {
+	items := make([]batchItem, 0, len(body.FileCodes)+len(body.FolderCodes))
+	for _, code := range body.FileCodes {
+		items = append(items, batchItem{Type: models.BATCH_ITEM_FILE, Code: code})
+	}
+	for _, code := range body.FolderCodes {
+		items = append(items, batchItem{Type: models.BATCH_ITEM_FOLDER, Code: code})
+	}
+	return items
+}
+
+// batchTargetFolderCode returns the code moveFile and moveFolder expect for the target folder,
+// the root folder has none.
+func batchTargetFolderCode(body models.BatchBody) string {
+	if body.TargetFolderCode == "root" {
+		return ""
+	}
+	return body.TargetFolderCode
+}
+
+// batchCopyBody returns the body CopyFile and CopyFolder expect for a batch copy. Batches copy
+// into the root folder by default, not next to the original.
+func batchCopyBody(body models.BatchBody) models.CopyBody {
+	if batchTargetFolderCode(body) == "" {
+		return models.CopyBody{TargetFolderCode: "root"}
+	}
+	return models.CopyBody{TargetFolderCode: body.TargetFolderCode}
+}
+
+// batchRunsInTransaction reports whether the items of a batch can run in a transaction. Permanent
+// deletions, copies and overwrites change objects in MinIO, which a rollback wouldn't undo.
+func batchRunsInTransaction(body models.BatchBody) bool {
+	switch body.Operation {
+	case models.BATCH_OP_DELETE, models.BATCH_OP_COPY:
+		return false
+	case models.BATCH_OP_MOVE, models.BATCH_OP_RESTORE:
+		return body.OnConflict != models.CONFLICT_OVERWRITE
+	}
+	return true
+}
+
+// batchItemOutcome returns errBatchItemSkipped for an item the conflict policy left as it was.
+func batchItemOutcome(skipped bool) error {
+	if skipped {
+		return errBatchItemSkipped
+	}
+	return nil
+}
+
+// batchItemError returns the error reported for an item. Server errors only report their
+// message, their cause is logged instead.
+func batchItemError(err error) string {
+	if e, ok := err.(*apperr.ServerError); ok {
+		log.Printf("Error while applying batch item: %v\n", e)
+		return e.Message
+	}
+	return err.Error()
+}
+
+// validateBatch checks what validator tags can't express.
+func (bs *BatchService) validateBatch(userID uint, body models.BatchBody) error {
+	total := len(body.FileCodes) + len(body.FolderCodes)
+	if total == 0 {
+		return &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Batch has no files or folders",
+			},
+		}
+	}
+
+	if total > MAX_BATCH_SIZE {
+		return &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: fmt.Sprintf("Batch can't hold more than %d files and folders", MAX_BATCH_SIZE),
+			},
+		}
+	}
+
+	if body.OnConflict == models.CONFLICT_OVERWRITE && body.Atomic {
+		return &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Overwritten files can't be rolled back, overwriting batches can't be atomic",
+			},
+		}
+	}
+
+	switch body.Operation {
+	case models.BATCH_OP_TAG, models.BATCH_OP_UNTAG:
+		if len(normalizeTags(body.Tags)) == 0 {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "Tag operations need at least one tag",
+				},
+			}
+		}
+
+	case models.BATCH_OP_DELETE:
+		if body.Atomic {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "Permanent deletions can't be rolled back, they can't be atomic",
+				},
+			}
+		}
+
+	case models.BATCH_OP_MOVE, models.BATCH_OP_COPY:
+		if body.Operation == models.BATCH_OP_COPY && body.Atomic {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "Copies can't be rolled back, they can't be atomic",
+				},
+			}
+		}
+
+		query := bs.DB.Where("code = ? AND user_id = ?", batchTargetFolderCode(body), userID)
+		if batchTargetFolderCode(body) == "" {
+			query = bs.DB.Where("user_id = ? AND (code IS NULL OR code = '')", userID)
+		}
+
+		if err := query.First(&models.Folder{}).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apperr.NotFoundError{
+					BaseError: &apperr.BaseError{
+						Message: "Target folder not found",
+						Err:     err,
+					},
+				}
+			}
+
+			return &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to fetch target folder",
+					Err:     err,
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// RunBatch applies the operation of a batch to every file and folder it lists, and reports the
+// outcome of each of them. Items already in the requested state, like restoring a file that isn't
+// trashed, succeed without changes.
+//
+// Each item is committed in its own transaction, so a failing item leaves nothing half done and the
+// items already applied don't wait for the rest of the batch. Atomic batches run in a single
+// transaction instead, rolled back entirely when an item fails. Permanent deletions and copies change
+// objects in MinIO, so they can't be rolled back and run outside of a transaction.
+//
+// Moves, restores and copies follow the conflict policy of the batch, or the default policy of the
+// operation. Items the policy skips are reported as skipped.
+//
+// Batches of more than BATCH_SYNC_LIMIT items run in the background, a BatchJob to poll is returned
+// instead of their result.
+//
+// If the batch is invalid, it returns an InvalidParamError.
+// If the target folder of a move or copy is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (bs *BatchService) RunBatch(userID uint, body models.BatchBody) (*models.BatchResult, *models.BatchJob, error) {
+	if err := bs.validateBatch(userID, body); err != nil {
+		return nil, nil, err
+	}
+
+	// The bucket client of the request is kept, the service may serve another request meanwhile
+	bc := bs.BucketClient
+
+	if len(body.FileCodes)+len(body.FolderCodes) <= BATCH_SYNC_LIMIT {
+		result, err := bs.execute(userID, body, bc, nil)
+		return result, nil, err
+	}
+
+	code, err := gonanoid.New()
+	if err != nil {
+		return nil, nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to generate batch job code",
+				Err:     err,
+			},
+		}
+	}
+
+	job := &models.BatchJob{
+		UserID:  userID,
+		Code:    code,
+		Status:  models.BATCH_JOB_PENDING,
+		Request: body,
+		BatchResult: models.BatchResult{
+			Operation: body.Operation,
+			Total:     len(body.FileCodes) + len(body.FolderCodes),
+			Results:   []models.BatchItemResult{},
+		},
+	}
+
+	if err := bs.DB.Create(job).Error; err != nil {
+		return nil, nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to create batch job",
+				Err:     err,
+			},
+		}
+	}
+
+	go bs.runJob(*job, bc)
+
+	return nil, job, nil
+}
+
+// runJob runs a batch job in the background and saves its result.
+func (bs *BatchService) runJob(job models.BatchJob, bc *models.BucketClient) {
+	if err := bs.DB.Model(&job).UpdateColumn("status", models.BATCH_JOB_RUNNING).Error; err != nil {
+		log.Printf("Error while starting batch job %s: %v\n", job.Code, err)
+	}
+
+	result, err := bs.execute(job.UserID, job.Request, bc, func(progress *models.BatchResult) {
+		processed := progress.Succeeded + progress.Failed
+		if processed%BATCH_PROGRESS_INTERVAL != 0 {
+			return
+		}
+
+		err := bs.DB.Model(&job).UpdateColumns(map[string]interface{}{
+			"succeeded": progress.Succeeded,
+			"failed":    progress.Failed,
+			"skipped":   progress.Skipped,
+		}).Error
+		if err != nil {
+			log.Printf("Error while saving progress of batch job %s: %v\n", job.Code, err)
+		}
+	})
+
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = models.BATCH_JOB_FAILED
+		job.Error = err.Error()
+	} else {
+		job.Status = models.BATCH_JOB_COMPLETED
+		job.BatchResult = *result
+	}
+
+	if err := bs.DB.Select("status", "succeeded", "failed", "skipped", "results", "error", "finished_at").Updates(&job).Error; err != nil {
+		log.Printf("Error while saving result of batch job %s: %v\n", job.Code, err)
+	}
+}
+
+// execute applies a batch, calling onItem after each item when set.
+func (bs *BatchService) execute(userID uint, body models.BatchBody, bc *models.BucketClient, onItem func(*models.BatchResult)) (*models.BatchResult, error) {
+	items := batchItems(body)
+	result := &models.BatchResult{
+		Operation: body.Operation,
+		Total:     len(items),
+		Results:   make([]models.BatchItemResult, 0, len(items)),
+	}
+
+	record := func(item batchItem, err error) {
+		itemResult := models.BatchItemResult{
+			Type:   item.Type,
+			Code:   item.Code,
+			Status: models.BATCH_ITEM_OK,
+		}
+
+		if errors.Is(err, errBatchItemSkipped) {
+			itemResult.Status = models.BATCH_ITEM_SKIPPED
+			result.Skipped++
+			result.Succeeded++
+		} else if err != nil {
+			itemResult.Status = models.BATCH_ITEM_FAILED
+			itemResult.Error = batchItemError(err)
+			result.Failed++
+		} else {
+			result.Succeeded++
+		}
+
+		result.Results = append(result.Results, itemResult)
+		if onItem != nil {
+			onItem(result)
+		}
+	}
+
+	if !batchRunsInTransaction(body) {
+		for _, item := range items {
+			record(item, bs.applyItem(bs.DB, userID, body, bc, item))
+		}
+		return result, nil
+	}
+
+	if !body.Atomic {
+		for _, item := range items {
+			err := bs.DB.Transaction(func(tx *gorm.DB) error {
+				return bs.applyItem(tx, userID, body, bc, item)
+			})
+			record(item, err)
+		}
+		return result, nil
+	}
+
+	err := bs.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			err := tx.Transaction(func(itemTx *gorm.DB) error {
+				return bs.applyItem(itemTx, userID, body, bc, item)
+			})
+			record(item, err)
+		}
+
+		if body.Atomic && result.Failed > 0 {
+			for i := range result.Results {
+				if result.Results[i].Status == models.BATCH_ITEM_OK {
+					result.Results[i].Status = models.BATCH_ITEM_ROLLED_BACK
+				}
+			}
+			result.Succeeded = 0
+			return errBatchRolledBack
+		}
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errBatchRolledBack) {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to run batch",
+				Err:     err,
+			},
+		}
+	}
+
+	return result, nil
+}
+
+// applyItem applies the operation of a batch to a single file or folder, through the same
+// services as the file and folder endpoints.
+func (bs *BatchService) applyItem(db *gorm.DB, userID uint, body models.BatchBody, bc *models.BucketClient, item batchItem) error {
+	if item.Type == models.BATCH_ITEM_FILE {
+		fileService := NewFileService(db)
+		fileService.SetBucketClient(bc)
+		return bs.applyFile(fileService, userID, body, item.Code)
+	}
+
+	folderService := NewFolderService(db)
+	folderService.SetBucketClient(bc)
+	return bs.applyFolder(folderService, userID, body, item.Code)
+}
+
+func (bs *BatchService) applyFile(fs *FileService, userID uint, body models.BatchBody, fileCode string) error {
+	var file models.File
+	err := fs.DB.Unscoped().
+		Preload("Folder", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("user_id = ? AND file_code = ?", userID, fileCode).
+		First(&file).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "File not found",
+				},
+			}
+		}
+
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch file",
+				Err:     err,
+			},
+		}
+	}
+
+	trashed := file.DeletedAt.Valid
+
+	switch body.Operation {
+	case models.BATCH_OP_MOVE:
+		if trashed {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "File is in the trash",
+				},
+			}
+		}
+
+		if err := fs.moveFile(&file, batchTargetFolderCode(body), userID, body.OnConflict); err != nil {
+			return err
+		}
+		return batchItemOutcome(file.Skipped)
+
+	case models.BATCH_OP_TRASH:
+		if trashed {
+			return nil
+		}
+		return fs.DeleteFileTemp(userID, file.ID)
+
+	case models.BATCH_OP_RESTORE:
+		if !trashed {
+			return nil
+		}
+
+		if err := fs.restoreFile(&file, body.OnConflict); err != nil {
+			return err
+		}
+		return batchItemOutcome(file.Skipped)
+
+	case models.BATCH_OP_FAVORITE, models.BATCH_OP_UNFAVORITE:
+		return fs.toggleFileFavorite(&file, body.Operation == models.BATCH_OP_FAVORITE)
+
+	case models.BATCH_OP_TAG, models.BATCH_OP_UNTAG:
+		var err error
+		if body.Operation == models.BATCH_OP_TAG {
+			err = fs.addFileTags(&file, body.Tags)
+		} else {
+			err = fs.removeFileTags(&file, body.Tags)
+		}
+
+		if err != nil {
+			return &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to update file tags",
+					Err:     err,
+				},
+			}
+		}
+		return nil
+
+	case models.BATCH_OP_DELETE:
+		return fs.DeleteFilePermanent(userID, file.ID)
+
+	case models.BATCH_OP_COPY:
+		if trashed {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "File is in the trash",
+				},
+			}
+		}
+
+		copied, err := NewCopyService(fs.DB, fs.BucketClient).CopyFile(userID, fileCode, batchCopyBody(body), body.OnConflict)
+		if err != nil {
+			return err
+		}
+		return batchItemOutcome(copied.Skipped)
+	}
+
+	return nil
+}
+
+func (bs *BatchService) applyFolder(fs *FolderService, userID uint, body models.BatchBody, folderCode string) error {
+	var folder models.Folder
+	err := fs.DB.Unscoped().
+		Preload("ParentFolder", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("user_id = ? AND code = ?", userID, folderCode).
+		First(&folder).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Folder not found",
+				},
+			}
+		}
+
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch folder",
+				Err:     err,
+			},
+		}
+	}
+
+	trashed := folder.DeletedAt.Valid
+
+	switch body.Operation {
+	case models.BATCH_OP_MOVE:
+		if trashed {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "Folder is in the trash",
+				},
+			}
+		}
+
+		if err := fs.moveFolder(&folder, batchTargetFolderCode(body), userID, body.OnConflict); err != nil {
+			return err
+		}
+		return batchItemOutcome(folder.Skipped)
+
+	case models.BATCH_OP_TRASH:
+		if trashed {
+			return nil
+		}
+		return fs.DeleteFolderTemp(folderCode, userID)
+
+	case models.BATCH_OP_RESTORE:
+		if !trashed {
+			return nil
+		}
+
+		if err := fs.restoreFolder(&folder, body.OnConflict); err != nil {
+			return err
+		}
+		return batchItemOutcome(folder.Skipped)
+
+	case models.BATCH_OP_FAVORITE, models.BATCH_OP_UNFAVORITE:
+		return fs.toggleFolderFavorite(&folder, body.Operation == models.BATCH_OP_FAVORITE)
+
+	case models.BATCH_OP_TAG, models.BATCH_OP_UNTAG:
+		return &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Folders can't be tagged",
+			},
+		}
+
+	case models.BATCH_OP_DELETE:
+		_, err := fs.DeleteFolderPermanent(folderCode, userID)
+		return err
+
+	case models.BATCH_OP_COPY:
+		if trashed {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "Folder is in the trash",
+				},
+			}
+		}
+
+		copied, err := NewCopyService(fs.DB, fs.BucketClient).CopyFolder(userID, folderCode, batchCopyBody(body), body.OnConflict)
+		if err != nil {
+			return err
+		}
+		return batchItemOutcome(copied.Skipped)
+	}
+
+	return nil
+}
+
+// GetBatchJob fetches a batch job of a user by its code, with its progress or result.
+//
+// If the batch job is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (bs *BatchService) GetBatchJob(userID uint, code string) (*models.BatchJob, error) {
+	var job models.BatchJob
+	if err := bs.DB.Where("user_id = ? AND code = ?", userID, code).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Batch job not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch batch job",
+				Err:     err,
+			},
+		}
+	}
+
+	return &job, nil
+}
+
+// FailInterruptedJobs marks the batch jobs left pending or running by a previous run of the
+// server as failed. Background jobs don't survive a restart.
+func (bs *BatchService) FailInterruptedJobs() error {
+	return bs.DB.Model(&models.BatchJob{}).
+		Where("status IN ?", []string{models.BATCH_JOB_PENDING, models.BATCH_JOB_RUNNING}).
+		Updates(map[string]interface{}{
+			"status":      models.BATCH_JOB_FAILED,
+			"error":       "Interrupted by a server restart",
+			"finished_at": time.Now(),
+		}).Error
+}
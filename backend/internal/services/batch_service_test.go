@@ -0,0 +1,179 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// createBatchFiles creates count files in a new root folder, and returns their codes.
+func createBatchFiles(t *testing.T, db *gorm.DB, userID uint, count int) []string {
+	folder := createTestFolder(t, db, userID, nil, "root")
+
+	codes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		codes = append(codes, createTestFile(t, db, userID, folder, fmt.Sprintf("file-%d", i)).FileCode)
+	}
+	return codes
+}
+
+// favoriteCount returns how many of the files are favorites.
+func favoriteCount(t *testing.T, db *gorm.DB, codes []string) int64 {
+	var count int64
+	if err := db.Model(&models.File{}).Where("file_code IN ? AND is_favorite = ?", codes, true).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count favorites: %v", err)
+	}
+	return count
+}
+
+func TestRunBatchAtomicRollsBack(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+	codes := createBatchFiles(t, db, userID, 2)
+
+	result, job, err := NewBatchService(db, nil).RunBatch(userID, models.BatchBody{
+		Operation: models.BATCH_OP_FAVORITE,
+		FileCodes: append(codes, "missing"),
+		Atomic:    true,
+	})
+	if err != nil || job != nil {
+		t.Fatalf("RunBatch() = %v, %v, want a result", job, err)
+	}
+
+	if result.Succeeded != 0 || result.Failed != 1 {
+		t.Errorf("RunBatch() succeeded %d and failed %d, want 0 and 1", result.Succeeded, result.Failed)
+	}
+
+	want := []string{models.BATCH_ITEM_ROLLED_BACK, models.BATCH_ITEM_ROLLED_BACK, models.BATCH_ITEM_FAILED}
+	for i, itemResult := range result.Results {
+		if itemResult.Status != want[i] {
+			t.Errorf("item %s status = %s, want %s", itemResult.Code, itemResult.Status, want[i])
+		}
+	}
+
+	if favorites := favoriteCount(t, db, codes); favorites != 0 {
+		t.Errorf("%d files left favorite by a rolled back batch", favorites)
+	}
+}
+
+func TestRunBatchReportsEachItem(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+	codes := createBatchFiles(t, db, userID, 2)
+
+	result, _, err := NewBatchService(db, nil).RunBatch(userID, models.BatchBody{
+		Operation: models.BATCH_OP_FAVORITE,
+		FileCodes: []string{codes[0], "missing", codes[1]},
+	})
+	if err != nil {
+		t.Fatalf("RunBatch() error = %v", err)
+	}
+
+	if result.Total != 3 || result.Succeeded != 2 || result.Failed != 1 || len(result.Results) != 3 {
+		t.Fatalf("RunBatch() = %+v, want 2 of 3 items succeeded", result)
+	}
+
+	failed := result.Results[1]
+	if failed.Code != "missing" || failed.Status != models.BATCH_ITEM_FAILED || failed.Error != "File not found" {
+		t.Errorf("missing file result = %+v, want it failed as not found", failed)
+	}
+
+	for _, i := range []int{0, 2} {
+		if result.Results[i].Status != models.BATCH_ITEM_OK {
+			t.Errorf("item %s status = %s, want ok", result.Results[i].Code, result.Results[i].Status)
+		}
+	}
+
+	// The failing item doesn't undo the others
+	if favorites := favoriteCount(t, db, codes); favorites != 2 {
+		t.Errorf("%d files favorite, want 2", favorites)
+	}
+}
+
+func TestRunJobReportsProgress(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+	codes := createBatchFiles(t, db, userID, BATCH_PROGRESS_INTERVAL+1)
+	body := models.BatchBody{
+		Operation: models.BATCH_OP_FAVORITE,
+		FileCodes: append(codes, "missing"),
+	}
+
+	// Progress is saved with UpdateColumns, the counts it saves are recorded on their way to the database
+	var progress []interface{}
+	err := db.Callback().Update().Before("gorm:update").Register("test:batch_progress", func(tx *gorm.DB) {
+		if columns, ok := tx.Statement.Dest.(map[string]interface{}); ok && tx.Statement.Table == "batch_jobs" {
+			if succeeded, ok := columns["succeeded"]; ok {
+				progress = append(progress, succeeded)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %v", err)
+	}
+
+	job := models.BatchJob{
+		UserID:  userID,
+		Code:    fmt.Sprintf("job-%d", time.Now().UnixNano()),
+		Status:  models.BATCH_JOB_PENDING,
+		Request: body,
+		BatchResult: models.BatchResult{
+			Operation: body.Operation,
+			Total:     len(body.FileCodes),
+		},
+	}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("failed to create batch job: %v", err)
+	}
+
+	service := NewBatchService(db, nil)
+	service.runJob(job, nil)
+
+	if len(progress) != 1 || progress[0] != BATCH_PROGRESS_INTERVAL {
+		t.Errorf("progress saved = %v, want once after %d items", progress, BATCH_PROGRESS_INTERVAL)
+	}
+
+	saved, err := service.GetBatchJob(userID, job.Code)
+	if err != nil {
+		t.Fatalf("GetBatchJob() error = %v", err)
+	}
+
+	if saved.Status != models.BATCH_JOB_COMPLETED || saved.FinishedAt == nil {
+		t.Errorf("batch job status = %s, finished at %v, want completed", saved.Status, saved.FinishedAt)
+	}
+	if saved.Succeeded != len(codes) || saved.Failed != 1 || len(saved.Results) != len(body.FileCodes) {
+		t.Errorf("batch job succeeded %d, failed %d with %d results, want %d, 1 and %d",
+			saved.Succeeded, saved.Failed, len(saved.Results), len(codes), len(body.FileCodes))
+	}
+}
+
+func TestFailInterruptedJobs(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+
+	job := models.BatchJob{
+		UserID:      userID,
+		Code:        fmt.Sprintf("job-%d", time.Now().UnixNano()),
+		Status:      models.BATCH_JOB_RUNNING,
+		BatchResult: models.BatchResult{Operation: models.BATCH_OP_TRASH, Total: 1},
+	}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("failed to create batch job: %v", err)
+	}
+
+	service := NewBatchService(db, nil)
+	if err := service.FailInterruptedJobs(); err != nil {
+		t.Fatalf("FailInterruptedJobs() error = %v", err)
+	}
+
+	saved, err := service.GetBatchJob(userID, job.Code)
+	if err != nil {
+		t.Fatalf("GetBatchJob() error = %v", err)
+	}
+	if saved.Status != models.BATCH_JOB_FAILED || saved.Error == "" || saved.FinishedAt == nil {
+		t.Errorf("interrupted batch job = %s (%q), want failed with an error", saved.Status, saved.Error)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// Renamed items are named "name (1)", "name (2)"... up to this many
+	MAX_RENAME_ATTEMPTS = 1000
+)
+
+// Conflict policies used when a request doesn't pick one. Uploads keep adding a version to the
+// file they collide with, renames and new folders fail, moves, copies and restores are renamed.
+const (
+	DEFAULT_UPLOAD_CONFLICT  = models.CONFLICT_OVERWRITE
+	DEFAULT_RENAME_CONFLICT  = models.CONFLICT_FAIL
+	DEFAULT_CREATE_CONFLICT  = models.CONFLICT_FAIL
+	DEFAULT_MOVE_CONFLICT    = models.CONFLICT_RENAME
+	DEFAULT_COPY_CONFLICT    = models.CONFLICT_RENAME
+	DEFAULT_RESTORE_CONFLICT = models.CONFLICT_RENAME
+)
+
+func conflictPolicy(policy, fallback string) string {
+	if policy == "" {
+		return fallback
+	}
+	return policy
+}
+
+// availableName returns the first of "name (1)", "name (2)"... that isn't taken. The extension
+// of file names is kept at the end.
+func availableName(name string, keepExtension bool, taken func(name string) (bool, error)) (string, error) {
+	for n := 1; n <= MAX_RENAME_ATTEMPTS; n++ {
+		candidate := utils.NumberedName(name, n, keepExtension)
+
+		isTaken, err := taken(candidate)
+		if err != nil || !isTaken {
+			return candidate, err
+		}
+	}
+
+	return "", &apperr.ConflictError{
+		BaseError: &apperr.BaseError{
+			Message: "Too many items named " + name,
+		},
+	}
+}
+
+func fileNameTaken(db *gorm.DB, folderID, exceptID uint) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		var count int64
+		err := db.Model(&models.File{}).Where("folder_id = ? AND file_name = ? AND id <> ?", folderID, name, exceptID).Count(&count).Error
+		return count > 0, err
+	}
+}
+
+func folderNameTaken(db *gorm.DB, parentID, exceptID uint) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		var count int64
+		err := db.Model(&models.Folder{}).Where("parent_id = ? AND name = ? AND id <> ?", parentID, name, exceptID).Count(&count).Error
+		return count > 0, err
+	}
+}
+
+// resolveFileConflict applies a conflict policy to a file named name going into a folder. exceptID is
+// the file being renamed, moved or restored, it doesn't conflict with itself.
+// It returns the name to give the file, and the file holding the name when it is overwritten or skipped.
+//
+// If the name is taken and the policy is to fail, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func resolveFileConflict(db *gorm.DB, folderID, exceptID uint, name, policy string) (string, *models.File, error) {
+	var existing models.File
+	err := db.Where("folder_id = ? AND file_name = ? AND id <> ?", folderID, name, exceptID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return name, nil, nil
+	} else if err != nil {
+		return "", nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to check file name",
+				Err:     err,
+			},
+		}
+	}
+
+	switch policy {
+	case models.CONFLICT_RENAME:
+		renamed, err := availableName(name, true, fileNameTaken(db, folderID, exceptID))
+		if err != nil {
+			return "", nil, nameConflictError(err, "Failed to check file name")
+		}
+		return renamed, nil, nil
+
+	case models.CONFLICT_OVERWRITE, models.CONFLICT_SKIP:
+		return name, &existing, nil
+	}
+
+	return "", nil, &apperr.ConflictError{
+		BaseError: &apperr.BaseError{
+			Message: fmt.Sprintf("A file named %s already exists in this folder", name),
+		},
+	}
+}
+
+// resolveFolderConflict applies a conflict policy to a folder named name going into a parent folder,
+// the same way resolveFileConflict does for files. Folders hold no content to overwrite, so the
+// overwrite policy fails like the fail policy.
+//
+// If the name is taken and the policy is to fail or overwrite, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func resolveFolderConflict(db *gorm.DB, parentID, exceptID uint, name, policy string) (string, *models.Folder, error) {
+	var existing models.Folder
+	err := db.Where("parent_id = ? AND name = ? AND id <> ?", parentID, name, exceptID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return name, nil, nil
+	} else if err != nil {
+		return "", nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to check folder name",
+				Err:     err,
+			},
+		}
+	}
+
+	switch policy {
+	case models.CONFLICT_RENAME:
+		renamed, err := availableName(name, false, folderNameTaken(db, parentID, exceptID))
+		if err != nil {
+			return "", nil, nameConflictError(err, "Failed to check folder name")
+		}
+		return renamed, nil, nil
+
+	case models.CONFLICT_SKIP:
+		return name, &existing, nil
+
+	case models.CONFLICT_OVERWRITE:
+		return "", nil, &apperr.ConflictError{
+			BaseError: &apperr.BaseError{
+				Message: fmt.Sprintf("A folder named %s already exists in this folder, folders can't be overwritten", name),
+			},
+		}
+	}
+
+	return "", nil, &apperr.ConflictError{
+		BaseError: &apperr.BaseError{
+			Message: fmt.Sprintf("A folder named %s already exists in this folder", name),
+		},
+	}
+}
+
+// nameConflictError turns the duplicate key error of the unique name indexes, raised when another
+// request took the name in the meantime, into a ConflictError. Other errors of the services are
+// returned as they are, the rest become a ServerError.
+func nameConflictError(err error, message string) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return &apperr.ConflictError{
+			BaseError: &apperr.BaseError{
+				Message: "An item with the same name already exists in this folder",
+				Err:     err,
+			},
+		}
+	}
+
+	switch err.(type) {
+	case *apperr.ConflictError, *apperr.NotFoundError, *apperr.InvalidParamError, *apperr.QuotaExceededError, *apperr.ServerError:
+		return err
+	}
+
+	return &apperr.ServerError{
+		BaseError: &apperr.BaseError{
+			Message: message,
+			Err:     err,
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+)
+
+func takenNames(names ...string) func(string) (bool, error) {
+	taken := map[string]bool{}
+	for _, name := range names {
+		taken[name] = true
+	}
+	return func(name string) (bool, error) {
+		return taken[name], nil
+	}
+}
+
+func TestAvailableNameSkipsTakenNames(t *testing.T) {
+	got, err := availableName("photo.jpg", true, takenNames("photo.jpg", "photo (1).jpg", "photo (2).jpg"))
+	if err != nil {
+		t.Fatalf("availableName() error = %v", err)
+	}
+	if got != "photo (3).jpg" {
+		t.Errorf("availableName() = %q, want %q", got, "photo (3).jpg")
+	}
+}
+
+func TestAvailableNameGivesUp(t *testing.T) {
+	alwaysTaken := func(string) (bool, error) { return true, nil }
+
+	_, err := availableName("photo.jpg", true, alwaysTaken)
+	if _, ok := err.(*apperr.ConflictError); !ok {
+		t.Errorf("availableName() error = %v, want a ConflictError", err)
+	}
+}
+
+func TestAvailableNameReturnsLookupErrors(t *testing.T) {
+	lookupErr := errors.New("lookup failed")
+	failing := func(string) (bool, error) { return false, lookupErr }
+
+	if _, err := availableName("docs", false, failing); !errors.Is(err, lookupErr) {
+		t.Errorf("availableName() error = %v, want %v", err, lookupErr)
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	if got := conflictPolicy("", DEFAULT_MOVE_CONFLICT); got != DEFAULT_MOVE_CONFLICT {
+		t.Errorf("conflictPolicy(\"\") = %q, want the fallback %q", got, DEFAULT_MOVE_CONFLICT)
+	}
+	if got := conflictPolicy("skip", DEFAULT_MOVE_CONFLICT); got != "skip" {
+		t.Errorf("conflictPolicy(\"skip\") = %q, want %q", got, "skip")
+	}
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MAX_INDEXED_CONTENT_SIZE = 1 << 20
+)
+
+type ContentIndexService struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func (cs *ContentIndexService) SetDB(db *gorm.DB) {
+	cs.DB = db
+}
+
+func (cs *ContentIndexService) SetBucketClient(bc *models.BucketClient) {
+	cs.BucketClient = bc
+}
+
+func NewContentIndexService(db *gorm.DB, bc *models.BucketClient) *ContentIndexService {
+	return &ContentIndexService{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+type documentKind int
+
+const (
+	kindUnsupported documentKind = iota
+	kindPlainText
+	kindPDF
+	kindOfficeOpenXML
+	kindOpenDocument
+)
+
+var officeOpenXMLTypes = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "docx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "xlsx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "pptx",
+}
+
+var openDocumentTypes = map[string]string{
+	"application/vnd.oasis.opendocument.text":         "odt",
+	"application/vnd.oasis.opendocument.spreadsheet":  "ods",
+	"application/vnd.oasis.opendocument.presentation": "odp",
+}
+
+var plainTextTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-yaml",
+	"application/x-sh",
+}
+
+// Browsers often send application/octet-stream for documents they don't know,
+// so the file extension is used as a fallback.
+var extensionKinds = map[string]documentKind{
+	"txt":  kindPlainText,
+	"md":   kindPlainText,
+	"csv":  kindPlainText,
+	"log":  kindPlainText,
+	"json": kindPlainText,
+	"xml":  kindPlainText,
+	"yaml": kindPlainText,
+	"yml":  kindPlainText,
+	"pdf":  kindPDF,
+	"docx": kindOfficeOpenXML,
+	"xlsx": kindOfficeOpenXML,
+	"pptx": kindOfficeOpenXML,
+	"odt":  kindOpenDocument,
+	"ods":  kindOpenDocument,
+	"odp":  kindOpenDocument,
+}
+
+func detectDocumentKind(fileType, fileName string) documentKind {
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(fileType, ";")[0]))
+
+	switch {
+	case strings.HasPrefix(mimeType, "text/"):
+		return kindPlainText
+	case mimeType == "application/pdf":
+		return kindPDF
+	}
+
+	if _, ok := officeOpenXMLTypes[mimeType]; ok {
+		return kindOfficeOpenXML
+	}
+
+	if _, ok := openDocumentTypes[mimeType]; ok {
+		return kindOpenDocument
+	}
+
+	for _, t := range plainTextTypes {
+		if mimeType == t {
+			return kindPlainText
+		}
+	}
+
+	return extensionKinds[strings.ToLower(utils.GetFileExtension(fileName))]
+}
+
+// IsIndexable reports whether the content of a file can be extracted for search.
+func IsIndexable(fileType, fileName string) bool {
+	return detectDocumentKind(fileType, fileName) != kindUnsupported
+}
+
+// IndexFile extracts the plain text of the given file and stores it in the
+// search index, replacing any previously indexed content of the file.
+//
+// It is meant to run in the background after an upload, so errors are logged
+// instead of returned.
+func (cs *ContentIndexService) IndexFile(file *models.File, fileBytes []byte) {
+	content, err := extractText(detectDocumentKind(file.FileType, file.FileName), fileBytes)
+	if err != nil {
+		log.Printf("Error while extracting content: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		return
+	}
+
+	if len(content) > MAX_INDEXED_CONTENT_SIZE {
+		content = content[:MAX_INDEXED_CONTENT_SIZE]
+	}
+	content = strings.ToValidUTF8(content, "")
+
+	fileContent := models.FileContent{
+		FileID:  file.ID,
+		UserID:  file.UserID,
+		Content: content,
+	}
+
+	err = cs.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content", "updated_at"}),
+	}).Create(&fileContent).Error
+	if err != nil {
+		log.Printf("Error while indexing content: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		return
+	}
+
+	log.Printf("Content indexed: %s (%s)\n", file.FileName, file.FileCode)
+}
+
+// DeleteFileContent removes the indexed content of the given files.
+func (cs *ContentIndexService) DeleteFileContent(fileIDs ...uint) error {
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	return cs.DB.Where("file_id IN ?", fileIDs).Delete(&models.FileContent{}).Error
+}
+
+func extractText(kind documentKind, data []byte) (string, error) {
+	switch kind {
+	case kindPlainText:
+		if !utf8.Valid(data) && bytes.IndexByte(data, 0) != -1 {
+			return "", fmt.Errorf("file looks like binary data")
+		}
+		return string(data), nil
+	case kindPDF:
+		return extractPDFText(data)
+	case kindOfficeOpenXML:
+		return extractZippedXMLText(data, officeOpenXMLParts)
+	case kindOpenDocument:
+		return extractZippedXMLText(data, openDocumentParts)
+	}
+
+	return "", fmt.Errorf("unsupported document type")
+}
+
+// extractPDFText relies on pdftotext (poppler-utils), the same way thumbnails
+// and HLS rely on ffmpeg being installed.
+func extractPDFText(data []byte) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("pdftotext", "-q", "-enc", "UTF-8", "-", "-")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pdftotext failed: %v %s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+func officeOpenXMLParts(name string) bool {
+	switch {
+	case name == "word/document.xml", name == "xl/sharedStrings.xml":
+		return true
+	case strings.HasPrefix(name, "ppt/slides/slide") && path.Ext(name) == ".xml":
+		return true
+	}
+	return false
+}
+
+func openDocumentParts(name string) bool {
+	return name == "content.xml"
+}
+
+func extractZippedXMLText(data []byte, isTextPart func(name string) bool) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open document archive: %v", err)
+	}
+
+	var parts []*zip.File
+	for _, f := range archive.File {
+		if isTextPart(f.Name) {
+			parts = append(parts, f)
+		}
+	}
+
+	// Keep slides in their natural order (slide2 before slide10)
+	sort.Slice(parts, func(i, j int) bool {
+		if len(parts[i].Name) != len(parts[j].Name) {
+			return len(parts[i].Name) < len(parts[j].Name)
+		}
+		return parts[i].Name < parts[j].Name
+	})
+
+	var sb strings.Builder
+	for _, part := range parts {
+		rc, err := part.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to open %s: %v", part.Name, err)
+		}
+
+		err = collectXMLText(io.LimitReader(rc, MAX_INDEXED_CONTENT_SIZE*4), &sb)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %v", part.Name, err)
+		}
+
+		if sb.Len() >= MAX_INDEXED_CONTENT_SIZE {
+			break
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// collectXMLText writes the character data of an XML document to sb, breaking
+// lines at paragraphs, table cells and slides.
+func collectXMLText(r io.Reader, sb *strings.Builder) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.StartElement:
+			if t.Name.Local == "tab" || t.Name.Local == "s" {
+				sb.WriteByte(' ')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p", "h", "si", "tc", "table-cell", "br", "line-break":
+				sb.WriteByte('\n')
+			}
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/gofrs/uuid/v5"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+type CopyService struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func (cs *CopyService) SetDB(db *gorm.DB) {
+	cs.DB = db
+}
+
+func (cs *CopyService) SetBucketClient(bc *models.BucketClient) {
+	cs.BucketClient = bc
+}
+
+func NewCopyService(db *gorm.DB, bc *models.BucketClient) *CopyService {
+	return &CopyService{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+// copyRun tracks the objects a copy created in MinIO, so they can be removed if the copy fails.
+// References taken on deduplicated objects are rolled back with the transaction instead.
+type copyRun struct {
+	tx             *gorm.DB
+	bc             *models.BucketClient
+	userID         uint
+	objects        []string
+	serviceObjects []string
+}
+
+func (run *copyRun) cleanup() {
+	for _, key := range run.objects {
+		if err := run.bc.RemoveObject(key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Error while removing copied object: %s -> %v\n", key, err)
+		}
+	}
+
+	for _, key := range run.serviceObjects {
+		if err := run.bc.RemoveServiceObject(key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Error while removing copied object: %s -> %v\n", key, err)
+		}
+	}
+}
+
+// resolveCopyTarget fetches the folder a copy goes into. Without a target code, the copy
+// goes into fallbackID, the folder of the original.
+func resolveCopyTarget(db *gorm.DB, userID uint, targetFolderCode string, fallbackID uint) (*models.Folder, error) {
+	var query *gorm.DB
+	switch targetFolderCode {
+	case "":
+		query = db.Where("user_id = ? AND id = ?", userID, fallbackID)
+	case "root":
+		query = db.Where("user_id = ? AND (code IS NULL OR code = '')", userID)
+	default:
+		query = db.Where("user_id = ? AND code = ?", userID, targetFolderCode)
+	}
+
+	var folder models.Folder
+	if err := query.First(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Target folder not found",
+					Err:     err,
+				},
+			}
+		}
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// fileCopySize returns the storage a copy of the file is accounted for, derivatives included.
+func fileCopySize(file *models.File) int64 {
+	size := int64(file.FileSize) + int64(file.HLSSize)
+	if file.Thumbnail != nil {
+		size += int64(file.Thumbnail.FileSize)
+	}
+	return size
+}
+
+func folderCopySize(folder *models.Folder) int64 {
+	var size int64
+	for _, file := range folder.Files {
+		size += fileCopySize(file)
+	}
+	for _, child := range folder.ChildFolders {
+		size += folderCopySize(child)
+	}
+	return size
+}
+
+// copyFile copies a file into a folder under a new code. The original is shared when it is
+// deduplicated and copied server-side otherwise, its thumbnail and HLS files are copied server-side.
+func (cs *CopyService) copyFile(run *copyRun, src *models.File, folderID uint, name string) (*models.File, error) {
+	fileCode, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	newCode := fileCode.String()
+
+	objectStore := NewObjectStore(run.tx, run.bc)
+	key, err := objectStore.Duplicate(src.StorageKey(), newCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != src.StorageKey() {
+		run.objects = append(run.objects, key)
+	}
+
+	newFile := models.File{
+		UserID:         run.userID,
+		FolderID:       folderID,
+		FileName:       name,
+		FileCode:       newCode,
+		ObjectKey:      key,
+		ContentHash:    src.ContentHash,
+		ContentMD5:     src.ContentMD5,
+		PerceptualHash: src.PerceptualHash,
+		FileSize:       src.FileSize,
+		FileType:       src.FileType,
+		IsPreviewable:  src.IsPreviewable,
+		HLSSize:        src.HLSSize,
+		CorruptedAt:    src.CorruptedAt,
+		Tags:           src.Tags,
+	}
+
+	if src.Thumbnail != nil {
+		thumbPath := fmt.Sprintf("/thumb/%s.jpg", newCode)
+		if _, err := run.bc.CopyServiceObject(src.Thumbnail.FilePath, thumbPath); err != nil {
+			return nil, err
+		}
+		run.serviceObjects = append(run.serviceObjects, thumbPath)
+
+		newFile.Thumbnail = &models.Thumbnail{
+			FilePath: thumbPath,
+			FileSize: src.Thumbnail.FileSize,
+		}
+	}
+
+	if src.IsPreviewable {
+		if err := cs.copyHLSFiles(run, src.FileCode, newCode); err != nil {
+			return nil, err
+		}
+	}
+
+	if src.Content != nil {
+		newFile.Content = &models.FileContent{
+			UserID:  run.userID,
+			Content: src.Content.Content,
+		}
+	}
+
+	if err := run.tx.Create(&newFile).Error; err != nil {
+		return nil, err
+	}
+
+	return &newFile, nil
+}
+
+// copyHLSFiles copies the playlist and segments of a video. The playlist points at the segments
+// through the file code, so it is rewritten for the new code instead of being copied as is.
+func (cs *CopyService) copyHLSFiles(run *copyRun, srcCode, newCode string) error {
+	prefix := fmt.Sprintf("hls/%s/", srcCode)
+
+	for object := range run.bc.Client.ListObjects(run.bc.Context, run.bc.ServiceBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		name := strings.TrimPrefix(object.Key, prefix)
+		dst := fmt.Sprintf("hls/%s/%s", newCode, strings.ReplaceAll(name, srcCode, newCode))
+
+		if strings.HasSuffix(name, ".m3u8") {
+			playlist, err := run.bc.GetServiceObject(object.Key, minio.GetObjectOptions{})
+			if err != nil {
+				return err
+			}
+
+			content, err := io.ReadAll(playlist)
+			playlist.Close()
+			if err != nil {
+				return err
+			}
+
+			rewritten := strings.ReplaceAll(string(content), fmt.Sprintf("/api/hls/%s/", srcCode), fmt.Sprintf("/api/hls/%s/", newCode))
+			if _, err := run.bc.PutServiceObject(dst, strings.NewReader(rewritten), int64(len(rewritten)), minio.PutObjectOptions{ContentType: "application/vnd.apple.mpegurl"}); err != nil {
+				return err
+			}
+		} else if _, err := run.bc.CopyServiceObject(object.Key, dst); err != nil {
+			return err
+		}
+
+		run.serviceObjects = append(run.serviceObjects, dst)
+	}
+
+	return nil
+}
+
+// copyFolder copies a folder loaded by loadCopyTree, with everything inside it.
+func (cs *CopyService) copyFolder(run *copyRun, src *models.Folder, parentID uint, name string) (*models.Folder, error) {
+	code, err := gonanoid.New()
+	if err != nil {
+		return nil, err
+	}
+
+	newFolder := models.Folder{
+		UserID:   run.userID,
+		ParentID: &parentID,
+		Name:     name,
+		Code:     code,
+		HasChild: len(src.ChildFolders) > 0,
+	}
+
+	if err := run.tx.Create(&newFolder).Error; err != nil {
+		return nil, err
+	}
+
+	if err := insertFolderClosure(run.tx, &newFolder); err != nil {
+		return nil, err
+	}
+
+	for _, file := range src.Files {
+		if _, err := cs.copyFile(run, file, newFolder.ID, file.FileName); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, child := range src.ChildFolders {
+		if _, err := cs.copyFolder(run, child, newFolder.ID, child.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &newFolder, nil
+}
+
+// loadCopyTree loads the files and child folders of a folder, whatever the depth of the tree.
+// Trashed items aren't copied.
+func (cs *CopyService) loadCopyTree(folder *models.Folder) error {
+	return loadFolderTree(cs.DB, folder, "Thumbnail", "Tags", "Content")
+}
+
+// CopyFile copies a file into a folder, with its thumbnail, HLS files, tags and search index.
+// The copy gets a new code. onConflict is the conflict policy applied when the target folder already
+// has a file with the same name, the copy is renamed "name (1).ext", "name (2).ext"... by default.
+// When that file is skipped or overwritten, it is returned instead of a copy.
+//
+// If the file or the target folder is not found, it returns a NotFoundError.
+// If the copy would go over the user's quota, it returns a QuotaExceededError.
+// If the name is taken and the policy is to fail, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (cs *CopyService) CopyFile(userID uint, fileCode string, copyBody models.CopyBody, onConflict string) (*models.File, error) {
+	var src models.File
+	err := cs.DB.Preload("Thumbnail").Preload("Tags").Preload("Content").
+		Where("user_id = ? AND file_code = ?", userID, fileCode).
+		First(&src).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "File not found",
+					Err:     err,
+				},
+			}
+		}
+		return nil, nameConflictError(err, "Failed to fetch file")
+	}
+
+	quotaService := NewQuotaService(cs.DB)
+	if err := quotaService.CheckQuota(userID, fileCopySize(&src)); err != nil {
+		return nil, err
+	}
+
+	policy := conflictPolicy(onConflict, DEFAULT_COPY_CONFLICT)
+	run := &copyRun{bc: cs.BucketClient, userID: userID}
+
+	// The file holding the name in the target folder, when it is skipped or overwritten
+	var existing *models.File
+
+	var newFile *models.File
+	err = cs.DB.Transaction(func(tx *gorm.DB) error {
+		run.tx = tx
+
+		target, err := resolveCopyTarget(tx, userID, copyBody.TargetFolderCode, src.FolderID)
+		if err != nil {
+			return err
+		}
+
+		name := src.FileName
+		if copyBody.Name != "" {
+			name = copyBody.Name
+		}
+
+		name, existing, err = resolveFileConflict(tx, target.ID, 0, name, policy)
+		if err != nil || existing != nil {
+			return err
+		}
+
+		newFile, err = cs.copyFile(run, &src, target.ID, name)
+		return err
+	})
+
+	if err != nil {
+		run.cleanup()
+		return nil, nameConflictError(err, "Failed to copy file")
+	}
+
+	if existing != nil {
+		// Overwriting a file with itself would only add an identical version
+		if policy == models.CONFLICT_SKIP || existing.ID == src.ID {
+			existing.Skipped = true
+			return existing, nil
+		}
+
+		fileVersionService := NewFileVersionService(cs.DB, cs.BucketClient)
+		if err := fileVersionService.OverwriteWithFile(existing, &src); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	return newFile, nil
+}
+
+// CopyFolder copies a folder and everything inside it into another folder. Every copied
+// folder and file gets a new code. onConflict is the conflict policy applied when the target folder
+// already has a folder with the same name, the copy is renamed "name (1)", "name (2)"... by default.
+// When that folder is skipped, it is returned instead of a copy.
+//
+// Copying a folder into itself or one of its subfolders copies its content as it was before the copy.
+//
+// If the folder or the target folder is not found, it returns a NotFoundError.
+// If the folder is the root folder, it returns an InvalidParamError.
+// If the copy would go over the user's quota, it returns a QuotaExceededError.
+// If the name is taken and the policy is to fail or overwrite, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (cs *CopyService) CopyFolder(userID uint, folderCode string, copyBody models.CopyBody, onConflict string) (*models.Folder, error) {
+	if folderCode == "" || folderCode == "root" {
+		return nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "The root folder can't be copied",
+			},
+		}
+	}
+
+	var src models.Folder
+	if err := cs.DB.Where("user_id = ? AND code = ?", userID, folderCode).First(&src).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Folder not found",
+					Err:     err,
+				},
+			}
+		}
+		return nil, nameConflictError(err, "Failed to fetch folder")
+	}
+
+	if src.ParentID == nil {
+		return nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "The root folder can't be copied",
+			},
+		}
+	}
+
+	if err := cs.loadCopyTree(&src); err != nil {
+		return nil, nameConflictError(err, "Failed to load folder")
+	}
+
+	quotaService := NewQuotaService(cs.DB)
+	if err := quotaService.CheckQuota(userID, folderCopySize(&src)); err != nil {
+		return nil, err
+	}
+
+	policy := conflictPolicy(onConflict, DEFAULT_COPY_CONFLICT)
+	run := &copyRun{bc: cs.BucketClient, userID: userID}
+
+	var newFolder *models.Folder
+	err := cs.DB.Transaction(func(tx *gorm.DB) error {
+		run.tx = tx
+
+		target, err := resolveCopyTarget(tx, userID, copyBody.TargetFolderCode, *src.ParentID)
+		if err != nil {
+			return err
+		}
+
+		name := src.Name
+		if copyBody.Name != "" {
+			name = copyBody.Name
+		}
+
+		name, existing, err := resolveFolderConflict(tx, target.ID, 0, name, policy)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			existing.Skipped = true
+			newFolder = existing
+			return nil
+		}
+
+		newFolder, err = cs.copyFolder(run, &src, target.ID, name)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(target).Update("has_child", true).Error
+	})
+
+	if err != nil {
+		run.cleanup()
+		return nil, nameConflictError(err, "Failed to copy folder")
+	}
+
+	return newFolder, nil
+}
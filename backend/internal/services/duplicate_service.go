@@ -0,0 +1,354 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/disintegration/imaging"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_DUPLICATE_DISTANCE = 5
+	// Scans still running after this long were interrupted, a new scan can replace them
+	DUPLICATE_SCAN_TIMEOUT = time.Hour
+)
+
+type DuplicateService struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func (ds *DuplicateService) SetDB(db *gorm.DB) {
+	ds.DB = db
+}
+
+func (ds *DuplicateService) SetBucketClient(bc *models.BucketClient) {
+	ds.BucketClient = bc
+}
+
+func NewDuplicateService(db *gorm.DB, bc *models.BucketClient) *DuplicateService {
+	return &DuplicateService{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+// backfillPerceptualHashes hashes the images uploaded before perceptual hashes were computed.
+// Their thumbnails are small and already decoded as JPEG, so they are used instead of the originals.
+// Images that can't be hashed are logged and skipped, only failing to list them is an error.
+func (ds *DuplicateService) backfillPerceptualHashes(userID uint, bc *models.BucketClient) error {
+	if bc == nil {
+		return nil
+	}
+
+	var files []*models.File
+	err := ds.DB.Preload("Thumbnail").
+		Where("user_id = ? AND file_type LIKE 'image/%' AND perceptual_hash IS NULL", userID).
+		Find(&files).Error
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Thumbnail == nil {
+			continue
+		}
+
+		thumbnail, err := bc.GetServiceObject(file.Thumbnail.FilePath, minio.GetObjectOptions{})
+		if err != nil {
+			log.Printf("Error while fetching thumbnail: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+			continue
+		}
+
+		img, err := imaging.Decode(thumbnail)
+		thumbnail.Close()
+		if err != nil {
+			log.Printf("Error while decoding thumbnail: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+			continue
+		}
+
+		if err := ds.DB.Model(file).Update("perceptual_hash", utils.DHash(img)).Error; err != nil {
+			log.Printf("Error while saving perceptual hash: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		}
+	}
+
+	return nil
+}
+
+// isDuplicate reports whether two files hold the same content, or are images whose
+// perceptual hashes are at most distance bits apart.
+func isDuplicate(a, b *models.File, distance int) bool {
+	if a.ContentHash != "" && a.ContentHash == b.ContentHash {
+		return true
+	}
+
+	return a.PerceptualHash != nil && b.PerceptualHash != nil &&
+		utils.HammingDistance(*a.PerceptualHash, *b.PerceptualHash) <= distance
+}
+
+// clusterFiles groups files around the best copy of each picture. Every file of a cluster is a
+// duplicate of the first one, so two files of a cluster can't be further apart than twice distance.
+func clusterFiles(files []*models.File, distance int) []models.DuplicateCluster {
+	// The largest copy is usually the one with the highest resolution or quality,
+	// ties go to the oldest one
+	sorted := make([]*models.File, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].FileSize != sorted[j].FileSize {
+			return sorted[i].FileSize > sorted[j].FileSize
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	clustered := make([]bool, len(sorted))
+	clusters := []models.DuplicateCluster{}
+	for i, keep := range sorted {
+		if clustered[i] {
+			continue
+		}
+
+		group := []*models.File{keep}
+		for j := i + 1; j < len(sorted); j++ {
+			if !clustered[j] && isDuplicate(keep, sorted[j], distance) {
+				clustered[j] = true
+				group = append(group, sorted[j])
+			}
+		}
+
+		if len(group) > 1 {
+			clusters = append(clusters, models.DuplicateCluster{
+				Keep:  keep.ID,
+				Files: group,
+			})
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Keep < clusters[j].Keep
+	})
+
+	return clusters
+}
+
+// duplicateDistance returns the distance asked for, or else fallback.
+func duplicateDistance(distance *int, fallback int) int {
+	if distance == nil {
+		return fallback
+	}
+	return *distance
+}
+
+// findClusters clusters the live files of a user that have a content or perceptual hash.
+func (ds *DuplicateService) findClusters(userID uint, distance int) ([]models.DuplicateCluster, error) {
+	var files []*models.File
+	err := ds.DB.Where("user_id = ? AND (perceptual_hash IS NOT NULL OR content_hash <> '')", userID).Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return clusterFiles(files, distance), nil
+}
+
+// StartScan starts hashing the images of a user in the background, replacing the previous scan.
+// Exact duplicates share the same content hash, near duplicates are images whose perceptual hashes
+// differ by at most a distance, the one of the query unless another one is listed.
+// A scan already running is returned as is.
+//
+// If an internal server error occurs, it returns a ServerError.
+func (ds *DuplicateService) StartScan(userID uint, query models.DuplicateQuery) (*models.DuplicateScan, error) {
+	// The bucket client of the request is kept, the service may serve another request meanwhile
+	bc := ds.BucketClient
+
+	var scan models.DuplicateScan
+	err := ds.DB.Where("user_id = ?", userID).First(&scan).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch duplicate scan",
+				Err:     err,
+			},
+		}
+	}
+
+	inProgress := scan.Status == models.DUPLICATE_SCAN_PENDING || scan.Status == models.DUPLICATE_SCAN_RUNNING
+	if err == nil && inProgress && time.Since(scan.UpdatedAt) < DUPLICATE_SCAN_TIMEOUT {
+		return &scan, nil
+	}
+
+	scan.UserID = userID
+	scan.Distance = duplicateDistance(query.Distance, DEFAULT_DUPLICATE_DISTANCE)
+	scan.Status = models.DUPLICATE_SCAN_PENDING
+	scan.Error = ""
+	scan.FinishedAt = nil
+
+	if err := ds.DB.Save(&scan).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to start duplicate scan",
+				Err:     err,
+			},
+		}
+	}
+
+	go ds.runScan(scan, bc)
+
+	return &scan, nil
+}
+
+// runScan hashes the images missing a perceptual hash and saves the outcome of the scan.
+func (ds *DuplicateService) runScan(scan models.DuplicateScan, bc *models.BucketClient) {
+	if err := ds.DB.Model(&scan).UpdateColumn("status", models.DUPLICATE_SCAN_RUNNING).Error; err != nil {
+		log.Printf("Error while starting duplicate scan of user %d: %v\n", scan.UserID, err)
+	}
+
+	err := ds.backfillPerceptualHashes(scan.UserID, bc)
+
+	now := time.Now()
+	scan.FinishedAt = &now
+	if err != nil {
+		scan.Status = models.DUPLICATE_SCAN_FAILED
+		scan.Error = "Failed to list images"
+		log.Printf("Error while listing images of duplicate scan of user %d: %v\n", scan.UserID, err)
+	} else {
+		scan.Status = models.DUPLICATE_SCAN_COMPLETED
+	}
+
+	if err := ds.DB.Select("status", "error", "finished_at").Updates(&scan).Error; err != nil {
+		log.Printf("Error while saving duplicate scan of user %d: %v\n", scan.UserID, err)
+	}
+}
+
+// ListDuplicates returns the latest duplicate scan of a user and, once it is completed, the
+// clusters of their files at the distance of the query, or else the one of the scan. Files are
+// clustered again on every call, so the distance can be tuned without scanning again.
+// Scan is nil when the user never started one.
+//
+// If an internal server error occurs, it returns a ServerError.
+func (ds *DuplicateService) ListDuplicates(userID uint, query models.DuplicateQuery) (*models.DuplicateList, error) {
+	list := &models.DuplicateList{Clusters: []models.DuplicateCluster{}}
+
+	var scan models.DuplicateScan
+	if err := ds.DB.Where("user_id = ?", userID).First(&scan).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return list, nil
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch duplicate scan",
+				Err:     err,
+			},
+		}
+	}
+
+	list.Scan = &scan
+	list.Distance = duplicateDistance(query.Distance, scan.Distance)
+	if scan.Status != models.DUPLICATE_SCAN_COMPLETED {
+		return list, nil
+	}
+
+	clusters, err := ds.findClusters(userID, list.Distance)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch duplicates",
+				Err:     err,
+			},
+		}
+	}
+
+	list.Clusters = clusters
+	return list, nil
+}
+
+// ResolveDuplicates keeps the best file of every cluster, found at the distance of the body or else
+// the one of the latest scan, and moves the others to the trash. Files listed in the body are kept instead of the best file of their cluster,
+// only the files that are duplicates of the kept file are trashed then.
+//
+// It returns the IDs of the trashed files.
+//
+// If the user has no completed scan, it returns a ResourceNotReadyError.
+// If an internal server error occurs, it returns a ServerError.
+func (ds *DuplicateService) ResolveDuplicates(userID uint, resolveBody models.DuplicateResolveBody) ([]uint, error) {
+	var scan models.DuplicateScan
+	err := ds.DB.Where("user_id = ? AND status = ?", userID, models.DUPLICATE_SCAN_COMPLETED).First(&scan).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.ResourceNotReadyError{
+				BaseError: &apperr.BaseError{
+					Message: "No completed duplicate scan",
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch duplicate scan",
+				Err:     err,
+			},
+		}
+	}
+
+	distance := duplicateDistance(resolveBody.Distance, scan.Distance)
+	clusters, err := ds.findClusters(userID, distance)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to find duplicates",
+				Err:     err,
+			},
+		}
+	}
+
+	kept := map[uint]bool{}
+	for _, fileID := range resolveBody.Keep {
+		kept[fileID] = true
+	}
+
+	trashed := []uint{}
+	err = ds.DB.Transaction(func(tx *gorm.DB) error {
+		fileService := NewFileService(tx)
+
+		for _, cluster := range clusters {
+			keep := cluster.Files[0]
+			for _, file := range cluster.Files {
+				if kept[file.ID] {
+					keep = file
+					break
+				}
+			}
+
+			for _, file := range cluster.Files {
+				// Files are duplicates of the best file of their cluster, not always of the one kept
+				if file.ID == keep.ID || kept[file.ID] || !isDuplicate(keep, file, distance) {
+					continue
+				}
+
+				if err := fileService.DeleteFileTemp(userID, file.ID); err != nil {
+					return err
+				}
+				trashed = append(trashed, file.ID)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to trash duplicates",
+				Err:     err,
+			},
+		}
+	}
+
+	return trashed, nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+)
+
+func fileWithID(id uint, file models.File) *models.File {
+	file.ID = id
+	return &file
+}
+
+func imageWithHash(id, size uint, hash uint64) *models.File {
+	return fileWithID(id, models.File{FileSize: size, PerceptualHash: &hash})
+}
+
+func TestClusterFilesIsNotTransitive(t *testing.T) {
+	// 2 is 3 bits from 1, 3 is 3 bits from 2 but 6 bits from 1
+	files := []*models.File{
+		imageWithHash(1, 300, 0b000000),
+		imageWithHash(2, 200, 0b000111),
+		imageWithHash(3, 100, 0b111111),
+	}
+
+	clusters := clusterFiles(files, 3)
+	if len(clusters) != 1 {
+		t.Fatalf("clusterFiles() returned %d clusters, want 1", len(clusters))
+	}
+
+	cluster := clusters[0]
+	if cluster.Keep != 1 || len(cluster.Files) != 2 || cluster.Files[1].ID != 2 {
+		t.Fatalf("clusterFiles() = keep %d with %d files, want file 1 and 2 only", cluster.Keep, len(cluster.Files))
+	}
+}
+
+func TestClusterFilesKeepsLargestCopy(t *testing.T) {
+	files := []*models.File{
+		fileWithID(1, models.File{FileSize: 100, ContentHash: "a"}),
+		fileWithID(2, models.File{FileSize: 100, ContentHash: "a"}),
+		imageWithHash(3, 50, 0xff),
+		imageWithHash(4, 80, 0xfe),
+		imageWithHash(5, 10, 0xff00),
+	}
+
+	clusters := clusterFiles(files, 2)
+	if len(clusters) != 2 {
+		t.Fatalf("clusterFiles() returned %d clusters, want 2", len(clusters))
+	}
+
+	if clusters[0].Keep != 1 || len(clusters[0].Files) != 2 {
+		t.Errorf("exact duplicates: keep %d with %d files, want keep 1 with 2 files", clusters[0].Keep, len(clusters[0].Files))
+	}
+
+	if clusters[1].Keep != 4 || len(clusters[1].Files) != 2 {
+		t.Errorf("near duplicates: keep %d with %d files, want keep 4 with 2 files", clusters[1].Keep, len(clusters[1].Files))
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	a := imageWithHash(1, 1, 0b1111)
+	b := imageWithHash(2, 1, 0b0000)
+
+	if !isDuplicate(a, b, 4) {
+		t.Error("isDuplicate() = false for hashes 4 bits apart with distance 4")
+	}
+	if isDuplicate(a, b, 3) {
+		t.Error("isDuplicate() = true for hashes 4 bits apart with distance 3")
+	}
+	if isDuplicate(fileWithID(3, models.File{}), fileWithID(4, models.File{}), 64) {
+		t.Error("isDuplicate() = true for files without hashes")
+	}
+}
+
+func TestListDuplicatesAtDistance(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+	folder := createTestFolder(t, db, userID, nil, "root")
+
+	// Hashes 3 bits apart, duplicates at the distance of the scan but not at 2
+	for i, hash := range []uint64{0b000000, 0b000111} {
+		file := createTestFile(t, db, userID, folder, []string{"a.jpg", "b.jpg"}[i])
+		if err := db.Model(file).Update("perceptual_hash", hash).Error; err != nil {
+			t.Fatalf("failed to hash file: %v", err)
+		}
+	}
+
+	scan := models.DuplicateScan{UserID: userID, Distance: 3, Status: models.DUPLICATE_SCAN_COMPLETED}
+	if err := db.Create(&scan).Error; err != nil {
+		t.Fatalf("failed to create scan: %v", err)
+	}
+
+	service := NewDuplicateService(db, nil)
+	list, err := service.ListDuplicates(userID, models.DuplicateQuery{})
+	if err != nil {
+		t.Fatalf("ListDuplicates() error = %v", err)
+	}
+	if list.Distance != 3 || len(list.Clusters) != 1 {
+		t.Errorf("ListDuplicates() = %d clusters at distance %d, want 1 at the distance of the scan", len(list.Clusters), list.Distance)
+	}
+
+	distance := 2
+	list, err = service.ListDuplicates(userID, models.DuplicateQuery{Distance: &distance})
+	if err != nil {
+		t.Fatalf("ListDuplicates() error = %v", err)
+	}
+	if list.Distance != 2 || len(list.Clusters) != 0 {
+		t.Errorf("ListDuplicates() = %d clusters at distance %d, want none at distance 2", len(list.Clusters), list.Distance)
+	}
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
-	"github.com/minio/minio-go/v7"
 	"gorm.io/gorm"
 )
 
@@ -40,41 +39,64 @@ func NewFileService(db *gorm.DB) *FileService {
 	}
 }
 
-// ListFavoriteFiles lists all files of a user that are marked as favorite.
+// ListFavoriteFiles lists the files of a user that are marked as favorite, one page at a time.
 //
+// If the params are invalid, it returns an InvalidParamError.
 // If an internal server error occurs, it returns a ServerError.
-// Otherwise, it returns a list of favorite files of the user.
-func (fs *FileService) ListFavoriteFiles(userID uint) ([]models.File, error) {
-	var favoriteFiles []models.File
-	if err := fs.DB.Where("user_id = ? AND is_favorite = ?", userID, true).Find(&favoriteFiles).Error; err != nil {
+// Otherwise, it returns a page of favorite files of the user.
+func (fs *FileService) ListFavoriteFiles(userID uint, params models.ListParams) (*models.FileListResponse, error) {
+	query := fs.DB.Model(&models.File{}).Where("files.user_id = ? AND files.is_favorite = ?", userID, true)
+
+	favoriteFiles, err := paginateFiles(query, params)
+	if err != nil {
 		log.Println(err.Error())
-		return nil, &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: "Internal server error ocurred",
-				Err:     err,
-			},
-		}
+		return nil, err
 	}
 
 	return favoriteFiles, nil
 }
 
-// ListTrashCanFiles lists all files of a user that are trashed.
+// ListTrashCanFiles lists the files of a user that are trashed, one page at a time.
+// When the user has a trash retention, each file has the days remaining before it's purged.
 //
+// If the params are invalid, it returns an InvalidParamError.
 // If an internal server error occurs, it returns a ServerError.
-// Otherwise, it returns a list of trashed files of the user.
-func (fs *FileService) ListTrashCanFiles(userID uint) ([]models.File, error) {
-	var trashedFiles []models.File
-	if err := fs.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).Find(&trashedFiles).Error; err != nil {
+// Otherwise, it returns a page of trashed files of the user.
+func (fs *FileService) ListTrashCanFiles(userID uint, params models.ListParams) (*models.FileListResponse, error) {
+	query := fs.DB.Unscoped().Model(&models.File{}).Where("files.user_id = ? AND files.deleted_at IS NOT NULL", userID)
+
+	trashFiles, err := paginateFiles(query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	daysRemaining, err := trashDaysRemaining(fs.DB, userID)
+	if err != nil {
 		return nil, &apperr.ServerError{
 			BaseError: &apperr.BaseError{
-				Message: "Failed to fetch trashed files",
+				Message: "Failed to fetch trash retention",
 				Err:     err,
 			},
 		}
 	}
 
-	return trashedFiles, nil
+	if daysRemaining != nil {
+		for _, file := range trashFiles.Files {
+			file.TrashDaysRemaining = daysRemaining(file.DeletedAt)
+		}
+	}
+
+	return trashFiles, nil
+}
+
+// ListCorruptedFiles lists the files of a user whose content failed an integrity check, one page at a time.
+//
+// If the params are invalid, it returns an InvalidParamError.
+// If an internal server error occurs, it returns a ServerError.
+func (fs *FileService) ListCorruptedFiles(userID uint, params models.ListParams) (*models.FileListResponse, error) {
+	query := fs.DB.Model(&models.File{}).Where("files.user_id = ? AND files.corrupted_at IS NOT NULL", userID)
+
+	return paginateFiles(query, params)
 }
 
 // DeleteFileTemp soft deletes a file by setting its deleted_at field to the current time.
@@ -105,7 +127,7 @@ func (fs *FileService) DeleteFileTemp(userID, fileID uint) error {
 // does not exist, or if there was an internal server error.
 func (fs *FileService) DeleteFilePermanent(userID, fileID uint) error {
 	var file models.File
-	if err := fs.DB.Unscoped().Where("user_id = ? AND id = ?", userID, fileID).First(&file).Error; err != nil {
+	if err := fs.DB.Unscoped().Preload("Thumbnail").Where("user_id = ? AND id = ?", userID, fileID).First(&file).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return &apperr.NotFoundError{
 				BaseError: &apperr.BaseError{
@@ -136,7 +158,29 @@ func (fs *FileService) DeleteFilePermanent(userID, fileID uint) error {
 
 			if strings.HasPrefix(file.FileType, "video/") && file.IsPreviewable {
 				hlsService := NewHLSService(fs.DB, fs.BucketClient)
-				hlsService.DeleteHLSFiles(&file)
+				if err := hlsService.DeleteHLSFiles(&file); err != nil {
+					return err
+				}
+			}
+		}
+
+		contentIndexService := NewContentIndexService(tx, fs.BucketClient)
+		if err := contentIndexService.DeleteFileContent(file.ID); err != nil {
+			return &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to remove file from search index",
+					Err:     err,
+				},
+			}
+		}
+
+		fileVersionService := NewFileVersionService(tx, fs.BucketClient)
+		if err := fileVersionService.DeleteAllVersions(file.ID); err != nil {
+			return &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to delete file versions",
+					Err:     err,
+				},
 			}
 		}
 
@@ -158,7 +202,8 @@ func (fs *FileService) DeleteFilePermanent(userID, fileID uint) error {
 			}
 		}
 
-		if err := fs.BucketClient.RemoveObject(file.FileCode, minio.RemoveObjectOptions{}); err != nil {
+		objectStore := NewObjectStore(tx, fs.BucketClient)
+		if err := objectStore.Release(file.StorageKey()); err != nil {
 			return &apperr.ServerError{
 				BaseError: &apperr.BaseError{
 					Message: "Internal server error ocurred",
@@ -170,27 +215,26 @@ func (fs *FileService) DeleteFilePermanent(userID, fileID uint) error {
 		return nil
 	})
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Released objects are removed once the deletion is committed, the scheduled cleanup
+	// removes them later if this fails
+	if err := NewObjectStore(fs.DB, fs.BucketClient).RemoveReleased(); err != nil {
+		log.Printf("Error while removing released objects: %v\n", err)
+	}
+
+	return nil
 }
 
-// EmptyTrashCan deletes all files in a user's trash can.
+// EmptyTrashCan deletes all files and folders in a user's trash can.
 // It returns an error if there was an internal server error.
 func (fs *FileService) EmptyTrashCan(userID uint) error {
-	var deletedFiles []models.File
-	if err := fs.DB.Unscoped().Preload("Thumbnail").Where("user_id = ? AND deleted_at IS NOT NULL", userID).Find(&deletedFiles).Error; err != nil {
+	trashService := NewTrashService(fs.DB, fs.BucketClient)
+	if _, err := trashService.EmptyTrash(userID); err != nil {
 		return err
 	}
-
-	for _, file := range deletedFiles {
-		if err := fs.DeleteFilePermanent(userID, file.ID); err != nil {
-			return &apperr.ServerError{
-				BaseError: &apperr.BaseError{
-					Message: "Internal server error ocurred",
-					Err:     err,
-				},
-			}
-		}
-	}
 	return nil
 }
 
@@ -254,12 +298,7 @@ func (fs *FileService) UpdateFile(userID, fileID uint, updateBody models.FileUpd
 	}
 
 	if err := fs.DB.Save(&file).Error; err != nil {
-		return nil, &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: "Internal server error ocurred",
-				Err:     err,
-			},
-		}
+		return nil, nameConflictError(err, "Internal server error ocurred")
 	}
 
 	return &file, nil
@@ -268,10 +307,13 @@ func (fs *FileService) UpdateFile(userID, fileID uint, updateBody models.FileUpd
 // PatchFile updates a file by given file id and user id.
 //
 // This function also supports restoring a file from trash can by setting Restore to true.
+// onConflict is the conflict policy applied when the file takes a name already used in its
+// folder, empty for the default policy of the operation.
 //
 // If the file is not found, it returns a NotFoundError.
+// If the name is taken and the policy is to fail, it returns a ConflictError.
 // If other errors occur, it returns a ServerError.
-func (fs *FileService) PatchFile(userID, fileID uint, patchBody models.FilePatchBody) (*models.File, error) {
+func (fs *FileService) PatchFile(userID, fileID uint, patchBody models.FilePatchBody, onConflict string) (*models.File, error) {
 	// Find file
 	var file models.File
 	query := fs.DB.Where("id = ? AND user_id = ?", fileID, userID).Preload("Folder")
@@ -300,7 +342,7 @@ func (fs *FileService) PatchFile(userID, fileID uint, patchBody models.FilePatch
 
 	var err error
 	if file.FileName != patchBody.FileName && patchBody.FileName != "" {
-		err = fs.renameFile(&file, patchBody.FileName)
+		err = fs.renameFile(&file, patchBody.FileName, onConflict)
 	} else if (patchBody.IsFavorite != nil) {
 		// Why else if? Because patch request can only set one field at a time.
 		// if a user requests a file rename, they will not set a value to the rest of the fields
@@ -309,9 +351,9 @@ func (fs *FileService) PatchFile(userID, fileID uint, patchBody models.FilePatch
 		// file.IsFavorite = patchBody.IsFavorite
 		err = fs.toggleFileFavorite(&file, *patchBody.IsFavorite)
 	} else if patchBody.Restore {
-		err = fs.restoreFile(&file)
+		err = fs.restoreFile(&file, onConflict)
 	} else {
-		err = fs.moveFile(&file, patchBody.FolderCode, userID)
+		err = fs.moveFile(&file, patchBody.FolderCode, userID, onConflict)
 	}
 
 	if err != nil {
@@ -321,11 +363,23 @@ func (fs *FileService) PatchFile(userID, fileID uint, patchBody models.FilePatch
 	return &file, nil
 }
 
-func (fs *FileService) renameFile(file *models.File, newFileName string) error {
-	if err := fs.DB.Model(&file).Update("file_name", newFileName).Error; err != nil {
+// overwriteFile stores the content of file as a new version of existing, the file holding the name
+// it wanted, and permanently deletes file. file then holds the overwritten file.
+func (fs *FileService) overwriteFile(file, existing *models.File) error {
+	fileVersionService := NewFileVersionService(fs.DB, fs.BucketClient)
+	if err := fileVersionService.OverwriteWithFile(existing, file); err != nil {
+		return err
+	}
+
+	if err := fs.DeleteFilePermanent(file.UserID, file.ID); err != nil {
+		return err
+	}
+
+	*file = models.File{}
+	if err := fs.DB.Preload("Folder").First(file, existing.ID).Error; err != nil {
 		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
-				Message: "Failed to rename file",
+				Message: "Failed to fetch overwritten file",
 				Err:     err,
 			},
 		}
@@ -334,14 +388,44 @@ func (fs *FileService) renameFile(file *models.File, newFileName string) error {
 	return nil
 }
 
-func (fs *FileService) restoreFile(file *models.File) error {
-	if err := fs.DB.Unscoped().Model(&file).Update("deleted_at", nil).Error; err != nil {
-		return &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: "Internal server error ocurred",
-				Err:     err,
-			},
-		}
+// settleFileConflict handles a file whose name is held by existing, after the skip or overwrite policy.
+func (fs *FileService) settleFileConflict(file, existing *models.File, policy string) error {
+	if policy == models.CONFLICT_SKIP {
+		file.Skipped = true
+		return nil
+	}
+
+	return fs.overwriteFile(file, existing)
+}
+
+func (fs *FileService) renameFile(file *models.File, newFileName, onConflict string) error {
+	policy := conflictPolicy(onConflict, DEFAULT_RENAME_CONFLICT)
+	name, existing, err := resolveFileConflict(fs.DB, file.FolderID, file.ID, newFileName, policy)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		return fs.settleFileConflict(file, existing, policy)
+	}
+
+	if err := fs.DB.Model(file).Update("file_name", name).Error; err != nil {
+		return nameConflictError(err, "Failed to rename file")
+	}
+
+	return nil
+}
+
+func (fs *FileService) restoreFile(file *models.File, onConflict string) error {
+	policy := conflictPolicy(onConflict, DEFAULT_RESTORE_CONFLICT)
+	name, existing, err := resolveFileConflict(fs.DB, file.FolderID, file.ID, file.FileName, policy)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil && policy == models.CONFLICT_SKIP {
+		file.Skipped = true
+		return nil
 	}
 
 	if file.Folder.DeletedAt.Valid {
@@ -356,6 +440,16 @@ func (fs *FileService) restoreFile(file *models.File) error {
 		}
 	}
 
+	if existing != nil {
+		return fs.overwriteFile(file, existing)
+	}
+
+	err = fs.DB.Unscoped().Model(file).Updates(map[string]interface{}{"deleted_at": nil, "file_name": name}).Error
+	if err != nil {
+		return nameConflictError(err, "Internal server error ocurred")
+	}
+
+	file.DeletedAt = gorm.DeletedAt{}
 	return nil
 }
 
@@ -373,7 +467,12 @@ func (fs *FileService) toggleFileFavorite(file *models.File, isFavorite bool) er
 	return nil
 }
 
-func (fs *FileService) moveFile(file *models.File, targetFolderCode string, userID uint) error {
+func (fs *FileService) moveFile(file *models.File, targetFolderCode string, userID uint, onConflict string) error {
+	policy := conflictPolicy(onConflict, DEFAULT_MOVE_CONFLICT)
+
+	// The file holding the name in the target folder, when it is skipped or overwritten
+	var existing *models.File
+
 	err := fs.DB.Transaction(func(tx *gorm.DB) error {
 		// Find new parent folder
 		// Set
@@ -394,7 +493,18 @@ func (fs *FileService) moveFile(file *models.File, targetFolderCode string, user
 			}
 		}
 
+		name, conflicting, err := resolveFileConflict(tx, parentFolder.ID, file.ID, file.FileName, policy)
+		if err != nil {
+			return err
+		}
+
+		if conflicting != nil {
+			existing = conflicting
+			return nil
+		}
+
 		// Update folder with new parent
+		file.FileName = name
 		file.FolderID = parentFolder.ID
 		file.Folder = &parentFolder
 
@@ -445,7 +555,11 @@ func (fs *FileService) moveFile(file *models.File, targetFolderCode string, user
 	})
 
 	if err != nil {
-		return err
+		return nameConflictError(err, "Failed to move file")
+	}
+
+	if existing != nil {
+		return fs.settleFileConflict(file, existing, policy)
 	}
 
 	if err := fs.DB.Model(&file).Preload("Folder").Find(&file).Error; err != nil {
@@ -490,7 +604,7 @@ func (fs *FileService) GetPresignedURL(userID uint, fileCode string) (*url.URL,
 
 	reqParams.Set("response-content-type", file.FileType+charsetParam)
 
-	presignedURL, err := fs.BucketClient.PresignedGetObject(file.FileCode, time.Second*24*60*60, reqParams)
+	presignedURL, err := fs.BucketClient.PresignedGetObject(file.StorageKey(), time.Second*24*60*60, reqParams)
 	if err != nil {
 		return nil, &apperr.ServerError{
 			BaseError: &apperr.BaseError{
@@ -502,3 +616,121 @@ func (fs *FileService) GetPresignedURL(userID uint, fileCode string) (*url.URL,
 
 	return presignedURL, nil
 }
+
+// normalizeTags trims and lowercases tag names and drops empty and duplicate ones.
+func normalizeTags(names []string) []string {
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		tags = append(tags, name)
+	}
+	return tags
+}
+
+// findOrCreateTags returns the tags of a user with the given names, creating the missing ones.
+func findOrCreateTags(db *gorm.DB, userID uint, names []string) ([]*models.Tag, error) {
+	tags := []*models.Tag{}
+	for _, name := range normalizeTags(names) {
+		tag := models.Tag{UserID: userID, Name: name}
+		if err := db.Where(tag).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	return tags, nil
+}
+
+// SetFileTags replaces the tags of a file with the given tag names.
+//
+// If the file is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (fs *FileService) SetFileTags(userID, fileID uint, tagNames []string) (*models.File, error) {
+	var file models.File
+	if err := fs.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "File not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	err := fs.DB.Transaction(func(tx *gorm.DB) error {
+		tags, err := findOrCreateTags(tx, userID, tagNames)
+		if err != nil {
+			return err
+		}
+
+		if len(tags) == 0 {
+			return tx.Model(&file).Association("Tags").Clear()
+		}
+
+		return tx.Model(&file).Association("Tags").Replace(tags)
+	})
+
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to update file tags",
+				Err:     err,
+			},
+		}
+	}
+
+	return &file, nil
+}
+
+// addFileTags adds tags to a file, keeping the tags it already has.
+func (fs *FileService) addFileTags(file *models.File, tagNames []string) error {
+	tags, err := findOrCreateTags(fs.DB, file.UserID, tagNames)
+	if err != nil || len(tags) == 0 {
+		return err
+	}
+
+	return fs.DB.Model(file).Association("Tags").Append(tags)
+}
+
+// removeFileTags removes tags from a file. The tags themselves are kept.
+func (fs *FileService) removeFileTags(file *models.File, tagNames []string) error {
+	var tags []*models.Tag
+	if err := fs.DB.Where("user_id = ? AND name IN ?", file.UserID, normalizeTags(tagNames)).Find(&tags).Error; err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	return fs.DB.Model(file).Association("Tags").Delete(tags)
+}
+
+// ListTags lists all tags a user has created.
+//
+// If an internal server error occurs, it returns a ServerError.
+func (fs *FileService) ListTags(userID uint) ([]models.Tag, error) {
+	tags := []models.Tag{}
+	if err := fs.DB.Where("user_id = ?", userID).Order("name ASC").Find(&tags).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list tags",
+				Err:     err,
+			},
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+type FileVersionService struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func (fvs *FileVersionService) SetDB(db *gorm.DB) {
+	fvs.DB = db
+}
+
+func (fvs *FileVersionService) SetBucketClient(bc *models.BucketClient) {
+	fvs.BucketClient = bc
+}
+
+func NewFileVersionService(db *gorm.DB, bc *models.BucketClient) *FileVersionService {
+	return &FileVersionService{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+func nextVersionKey(file *models.File) string {
+	return fmt.Sprintf("versions/%s/%d", file.FileCode, file.Version+1)
+}
+
+func (fvs *FileVersionService) findFile(userID uint, fileCode string) (*models.File, error) {
+	var file models.File
+	if err := fvs.DB.Where("file_code = ? AND user_id = ?", fileCode, userID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "File not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch file's information",
+				Err:     err,
+			},
+		}
+	}
+
+	return &file, nil
+}
+
+func (fvs *FileVersionService) findVersion(file *models.File, version uint) (*models.FileVersion, error) {
+	var fileVersion models.FileVersion
+	if err := fvs.DB.Where("file_id = ? AND version = ?", file.ID, version).First(&fileVersion).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "File version not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch file version",
+				Err:     err,
+			},
+		}
+	}
+
+	return &fileVersion, nil
+}
+
+// ListFileVersions lists the current content of a file and all of its older versions, newest first.
+//
+// If the file is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (fvs *FileVersionService) ListFileVersions(userID uint, fileCode string) (*models.FileVersionList, error) {
+	file, err := fvs.findFile(userID, fileCode)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*models.FileVersion{}
+	if err := fvs.DB.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list file versions",
+				Err:     err,
+			},
+		}
+	}
+
+	return &models.FileVersionList{
+		Current:  file,
+		Versions: versions,
+	}, nil
+}
+
+// archiveAndReplace keeps the current content of the file as a FileVersion and
+// points the file to the given content.
+func archiveAndReplace(tx *gorm.DB, file *models.File, content *StoredContent, fileType string) error {
+	archived := models.FileVersion{
+		FileID:      file.ID,
+		UserID:      file.UserID,
+		Version:     file.Version,
+		ObjectKey:   file.StorageKey(),
+		ContentHash: file.ContentHash,
+		ContentMD5:  file.ContentMD5,
+		FileSize:    file.FileSize,
+		FileType:    file.FileType,
+		CorruptedAt: file.CorruptedAt,
+	}
+
+	if err := tx.Create(&archived).Error; err != nil {
+		return err
+	}
+
+	file.ObjectKey = content.Key
+	file.ContentHash = content.SHA256
+	file.ContentMD5 = content.MD5
+	file.Version++
+	file.FileSize = content.Size
+	file.FileType = fileType
+	file.IsPreviewable = strings.HasPrefix(fileType, "image/")
+	file.HLSSize = 0
+	file.PerceptualHash = nil
+	file.CorruptedAt = nil
+
+	return tx.Model(file).Select("object_key", "content_hash", "content_md5", "version", "file_size", "file_type",
+		"is_previewable", "hls_size", "perceptual_hash", "corrupted_at").Updates(file).Error
+}
+
+// clearDerivatives removes the thumbnail and HLS playlist made from the previous content of a file,
+// so they can be generated again from the new one. It is only called once the new content is
+// committed, file must describe the previous content.
+func (fvs *FileVersionService) clearDerivatives(file *models.File) {
+	var thumbnail models.Thumbnail
+	if err := fvs.DB.Where("file_id = ?", file.ID).First(&thumbnail).Error; err == nil {
+		if err := fvs.DB.Unscoped().Delete(&thumbnail).Error; err != nil {
+			log.Printf("Error while deleting outdated thumbnail: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		} else if err := fvs.BucketClient.RemoveServiceObject(thumbnail.FilePath, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Error while deleting outdated thumbnail: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		}
+	}
+
+	if strings.HasPrefix(file.FileType, "video/") {
+		hlsService := NewHLSService(fvs.DB, fvs.BucketClient)
+		if err := hlsService.DeleteHLSFiles(file); err != nil {
+			log.Printf("Error while deleting outdated HLS files: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		}
+	}
+}
+
+// UploadNewVersion stores the uploaded bytes as the new current content of an existing file.
+// The previous content is kept as an older version.
+//
+// If other errors occur, it returns a ServerError.
+func (fvs *FileVersionService) UploadNewVersion(file *models.File, uploadedFileBytes []byte, fileType string) error {
+	objectStore := NewObjectStore(fvs.DB, fvs.BucketClient)
+	content, err := objectStore.Store(uploadedFileBytes, fileType)
+	if err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to upload new file version",
+				Err:     err,
+			},
+		}
+	}
+
+	previous := *file
+	err = fvs.DB.Transaction(func(tx *gorm.DB) error {
+		return archiveAndReplace(tx, file, content, fileType)
+	})
+
+	if err != nil {
+		if err := objectStore.Release(content.Key); err != nil {
+			log.Printf("Error while undoing MinIO file version uploading: %s -> %v\n", content.Key, err)
+		}
+
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to save new file version",
+				Err:     err,
+			},
+		}
+	}
+
+	fvs.clearDerivatives(&previous)
+
+	file.Deduplicated = content.Deduplicated
+	return nil
+}
+
+// RestoreFileVersion makes an older version the current content of a file again.
+// The content being replaced is kept as a version too, so restoring never loses anything.
+// Thumbnails and HLS playlists are regenerated in the background.
+//
+// If the file or the version is not found, it returns a NotFoundError.
+// If the restored content doesn't fit in the user's quota, it returns a QuotaExceededError.
+// If other errors occur, it returns a ServerError.
+func (fvs *FileVersionService) RestoreFileVersion(userID uint, fileCode string, version uint) (*models.File, error) {
+	file, err := fvs.findFile(userID, fileCode)
+	if err != nil {
+		return nil, err
+	}
+
+	fileVersion, err := fvs.findVersion(file, version)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaService := NewQuotaService(fvs.DB)
+	if err := quotaService.CheckQuota(userID, int64(fileVersion.FileSize)); err != nil {
+		return nil, err
+	}
+
+	// Deduplicated contents are shared with the version, older ones are copied
+	objectStore := NewObjectStore(fvs.DB, fvs.BucketClient)
+	newKey, err := objectStore.Duplicate(fileVersion.ObjectKey, nextVersionKey(file))
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to copy file version",
+				Err:     err,
+			},
+		}
+	}
+
+	previous := *file
+	err = fvs.DB.Transaction(func(tx *gorm.DB) error {
+		return archiveAndReplace(tx, file, &StoredContent{
+			Key:    newKey,
+			SHA256: fileVersion.ContentHash,
+			MD5:    fileVersion.ContentMD5,
+			Size:   fileVersion.FileSize,
+		}, fileVersion.FileType)
+	})
+
+	if err != nil {
+		if err := objectStore.Release(newKey); err != nil {
+			log.Printf("Error while undoing MinIO file version copy: %s -> %v\n", newKey, err)
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to restore file version",
+				Err:     err,
+			},
+		}
+	}
+
+	fvs.clearDerivatives(&previous)
+	go fvs.regenerateDerivatives(*file)
+
+	return file, nil
+}
+
+// OverwriteWithFile makes the current content of src the new current content of dst, the way
+// uploading it over dst would. The content dst had is kept as an older version, src is left as is.
+// Thumbnails and HLS playlists of dst are regenerated in the background.
+//
+// If an error occurs, it returns a ServerError.
+func (fvs *FileVersionService) OverwriteWithFile(dst, src *models.File) error {
+	// Deduplicated contents are shared with src, others are copied
+	objectStore := NewObjectStore(fvs.DB, fvs.BucketClient)
+	newKey, err := objectStore.Duplicate(src.StorageKey(), nextVersionKey(dst))
+	if err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to copy file content",
+				Err:     err,
+			},
+		}
+	}
+
+	previous := *dst
+	err = fvs.DB.Transaction(func(tx *gorm.DB) error {
+		return archiveAndReplace(tx, dst, &StoredContent{
+			Key:    newKey,
+			SHA256: src.ContentHash,
+			MD5:    src.ContentMD5,
+			Size:   src.FileSize,
+		}, src.FileType)
+	})
+
+	if err != nil {
+		if err := objectStore.Release(newKey); err != nil {
+			log.Printf("Error while undoing MinIO file content copy: %s -> %v\n", newKey, err)
+		}
+
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to overwrite file",
+				Err:     err,
+			},
+		}
+	}
+
+	fvs.clearDerivatives(&previous)
+	go fvs.regenerateDerivatives(*dst)
+
+	return nil
+}
+
+// regenerateDerivatives reads the current content of a file back from MinIO and runs
+// the same post-upload processing as a fresh upload.
+func (fvs *FileVersionService) regenerateDerivatives(file models.File) {
+	object, err := fvs.BucketClient.GetObject(file.StorageKey(), minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("Error while fetching restored file: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		return
+	}
+	defer object.Close()
+
+	fileBytes, err := io.ReadAll(object)
+	if err != nil {
+		log.Printf("Error while reading restored file: %s (%s) -> %v\n", file.FileName, file.FileCode, err)
+		return
+	}
+
+	folderService := NewFolderService(fvs.DB)
+	folderService.SetBucketClient(fvs.BucketClient)
+
+	if strings.HasPrefix(file.FileType, "image/") || strings.HasPrefix(file.FileType, "video/") {
+		folderService.PostUploadProcess(&file, fileBytes)
+	}
+
+	if IsIndexable(file.FileType, file.FileName) {
+		folderService.IndexFileContent(&file, fileBytes)
+	}
+}
+
+// GetVersionPresignedURL returns a presigned URL to download an older version of a file.
+//
+// If the file or the version is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (fvs *FileVersionService) GetVersionPresignedURL(userID uint, fileCode string, version uint) (*url.URL, error) {
+	file, err := fvs.findFile(userID, fileCode)
+	if err != nil {
+		return nil, err
+	}
+
+	fileVersion, err := fvs.findVersion(file, version)
+	if err != nil {
+		return nil, err
+	}
+
+	reqParams := make(url.Values)
+	reqParams.Set("response-content-disposition", "attachment; filename=\""+file.FileName+"\"")
+	reqParams.Set("response-content-type", fileVersion.FileType)
+
+	presignedURL, err := fvs.BucketClient.PresignedGetObject(fileVersion.ObjectKey, time.Second*24*60*60, reqParams)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to get presigned URL",
+				Err:     err,
+			},
+		}
+	}
+
+	return presignedURL, nil
+}
+
+// PruneFileVersions deletes older versions of a file. Either the listed versions are deleted,
+// or every version but the KeepLatest most recent ones. The current content is never pruned.
+//
+// It returns the numbers of the deleted versions.
+//
+// If the file is not found, it returns a NotFoundError.
+// If neither versions nor keep_latest are given, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (fvs *FileVersionService) PruneFileVersions(userID uint, fileCode string, pruneBody models.FileVersionPruneBody) ([]uint, error) {
+	if pruneBody.KeepLatest == nil && len(pruneBody.Versions) == 0 {
+		return nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Either versions or keep_latest must be given",
+			},
+		}
+	}
+
+	file, err := fvs.findFile(userID, fileCode)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fvs.DB.Where("file_id = ?", file.ID).Order("version DESC")
+	if len(pruneBody.Versions) > 0 {
+		query = query.Where("version IN ?", pruneBody.Versions)
+	}
+
+	var prunedVersions []*models.FileVersion
+	if err := query.Find(&prunedVersions).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch file versions",
+				Err:     err,
+			},
+		}
+	}
+
+	if len(pruneBody.Versions) == 0 {
+		prunedVersions = versionsBeyond(prunedVersions, *pruneBody.KeepLatest)
+	}
+
+	if err := fvs.deleteVersions(prunedVersions); err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to prune file versions",
+				Err:     err,
+			},
+		}
+	}
+
+	pruned := []uint{}
+	for _, fileVersion := range prunedVersions {
+		pruned = append(pruned, fileVersion.Version)
+	}
+
+	return pruned, nil
+}
+
+// versionsBeyond returns the versions left after skipping the keep most recent ones.
+// fileVersions must be ordered from the most recent version.
+func versionsBeyond(fileVersions []*models.FileVersion, keep uint) []*models.FileVersion {
+	if uint(len(fileVersions)) <= keep {
+		return []*models.FileVersion{}
+	}
+
+	return fileVersions[keep:]
+}
+
+// DeleteAllVersions deletes every older version of the given files, objects included.
+// It is used when files are permanently deleted.
+func (fvs *FileVersionService) DeleteAllVersions(fileIDs ...uint) error {
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	var fileVersions []*models.FileVersion
+	if err := fvs.DB.Where("file_id IN ?", fileIDs).Find(&fileVersions).Error; err != nil {
+		return err
+	}
+
+	return fvs.deleteVersions(fileVersions)
+}
+
+func (fvs *FileVersionService) deleteVersions(fileVersions []*models.FileVersion) error {
+	if len(fileVersions) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(fileVersions))
+	for _, fileVersion := range fileVersions {
+		keys = append(keys, fileVersion.ObjectKey)
+	}
+
+	if err := NewObjectStore(fvs.DB, fvs.BucketClient).Release(keys...); err != nil {
+		return err
+	}
+
+	return fvs.DB.Unscoped().Delete(&fileVersions).Error
+}
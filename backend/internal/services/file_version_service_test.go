@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+)
+
+func versionsFrom(numbers ...uint) []*models.FileVersion {
+	fileVersions := []*models.FileVersion{}
+	for _, number := range numbers {
+		fileVersions = append(fileVersions, &models.FileVersion{Version: number})
+	}
+	return fileVersions
+}
+
+func versionNumbers(fileVersions []*models.FileVersion) []uint {
+	numbers := []uint{}
+	for _, fileVersion := range fileVersions {
+		numbers = append(numbers, fileVersion.Version)
+	}
+	return numbers
+}
+
+func TestVersionsBeyond(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []uint
+		keep     uint
+		want     []uint
+	}{
+		{"keep none", []uint{5, 4, 3}, 0, []uint{5, 4, 3}},
+		{"keep some", []uint{5, 4, 3, 2, 1}, 2, []uint{3, 2, 1}},
+		{"keep all", []uint{5, 4, 3}, 3, []uint{}},
+		{"keep more than exist", []uint{2, 1}, 10, []uint{}},
+		{"no versions", []uint{}, 1, []uint{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := versionNumbers(versionsBeyond(versionsFrom(tt.versions...), tt.keep))
+			if len(got) != len(tt.want) {
+				t.Fatalf("versionsBeyond() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("versionsBeyond() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
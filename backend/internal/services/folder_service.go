@@ -1,7 +1,6 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,17 +8,17 @@ import (
 	"log"
 	"mime/multipart"
 	"os"
-	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/gofrs/uuid/v5"
 	gonanoid "github.com/matoous/go-nanoid/v2"
 	"github.com/minio/minio-go/v7"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type FolderService struct {
@@ -52,11 +51,12 @@ const (
 // If folderCode is "root", it will list all top-level folders.
 // If folderCode is not "root", it will list all subfolders of the folder with the given folderCode.
 //
-// The returned FolderResponse contains a list of folders, and their hierarchy.
+// The returned FolderResponse contains a page of folders, and their hierarchy.
 //
+// If the params are invalid, it returns an InvalidParamError.
 // If the folder is not found, it returns a NotFoundError.
 // If other errors occur, it returns a ServerError.
-func (fs *FolderService) ListFolders(userID uint, folderCode string) (*models.FolderResponse, error) {
+func (fs *FolderService) ListFolders(userID uint, folderCode string, params models.ListParams) (*models.FolderResponse, error) {
 
 	var parentFolder models.Folder
 	query := fs.DB.Where("user_id = ? AND (code IS NULL OR code = '')", userID)
@@ -64,7 +64,7 @@ func (fs *FolderService) ListFolders(userID uint, folderCode string) (*models.Fo
 		query = fs.DB.Where("user_id = ? AND code = ?", userID, folderCode)
 	}
 
-	if err := query.Preload("ChildFolders").Find(&parentFolder).Error; err != nil {
+	if err := query.First(&parentFolder).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, &apperr.NotFoundError{
 				BaseError: &apperr.BaseError{
@@ -82,48 +82,104 @@ func (fs *FolderService) ListFolders(userID uint, folderCode string) (*models.Fo
 		}
 	}
 
-	// Generate hierarchy
-	var currentParent *models.Folder = &parentFolder
-	var hierarchies []models.FolderHierarchy
-	for currentParent.ParentID != nil {
-		var parent models.Folder
-		if err := fs.DB.Where("id = ?", *currentParent.ParentID).Find(&parent).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return nil, &apperr.NotFoundError{
-					BaseError: &apperr.BaseError{
-						Message: "Folder not found",
-						Err:     err,
-					},
-				}
-
-			}
-			return nil, &apperr.ServerError{
-				BaseError: &apperr.BaseError{
-					Message: "Failed to list folders",
-					Err:     err,
-				},
-			}
-		}
-		folderHierarchy := models.FolderHierarchy{
-			Name: parent.Name,
-			Code: parent.Code,
+	// Generate hierarchy, from the root folder down to the listed folder
+	ancestors, err := folderAncestors(fs.DB, parentFolder.ID)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list folders",
+				Err:     err,
+			},
 		}
-		hierarchies = append(hierarchies, folderHierarchy)
-		currentParent = &parent
 	}
 
-	slices.Reverse(hierarchies)
-	hierarchies = append(hierarchies, models.FolderHierarchy{
-		Name: parentFolder.Name,
-		Code: parentFolder.Code,
-	})
+	hierarchies := make([]models.FolderHierarchy, 0, len(ancestors))
+	for _, ancestor := range ancestors {
+		hierarchies = append(hierarchies, models.FolderHierarchy{
+			Name: ancestor.Name,
+			Code: ancestor.Code,
+		})
+	}
+
+	childQuery := fs.DB.Model(&models.Folder{}).Where("folders.parent_id = ?", parentFolder.ID)
+	childFolders, total, nextCursor, err := paginateFolders(childQuery, params)
+	if err != nil {
+		return nil, err
+	}
 
 	return &models.FolderResponse{
-		Folders:     parentFolder.ChildFolders,
+		Folders:     childFolders,
 		Hierarchies: hierarchies,
+		Total:       total,
+		NextCursor:  nextCursor,
 	}, nil
 }
 
+// mediaTypeCase maps the MIME type of a file to its media type.
+var mediaTypeCase = fmt.Sprintf(`CASE
+	WHEN file_type LIKE 'image/%%' THEN '%s'
+	WHEN file_type LIKE 'video/%%' THEN '%s'
+	WHEN file_type LIKE 'audio/%%' THEN '%s'
+	WHEN file_type LIKE 'text/%%' OR file_type IN ('application/pdf', 'application/msword', 'application/rtf')
+		OR file_type LIKE 'application/vnd.openxmlformats-officedocument.%%'
+		OR file_type LIKE 'application/vnd.oasis.opendocument.%%'
+		OR file_type LIKE 'application/vnd.ms-%%' THEN '%s'
+	ELSE '%s'
+END`, models.MEDIA_TYPE_IMAGE, models.MEDIA_TYPE_VIDEO, models.MEDIA_TYPE_AUDIO, models.MEDIA_TYPE_DOCUMENT, models.MEDIA_TYPE_OTHER)
+
+// folderStats sums up the folder tree under a folder through the folder closures, so deep trees
+// take two queries.
+func (fs *FolderService) folderStats(folder *models.Folder) (*models.FolderStats, error) {
+	var folders struct {
+		FolderCount  int64
+		LastModified *time.Time
+	}
+
+	err := fs.DB.Raw(`SELECT COUNT(*) - 1 AS folder_count, MAX(updated_at) AS last_modified FROM folders
+		WHERE id IN (`+liveSubtreeQuery+`)`, folder.ID).
+		Scan(&folders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var mediaTypes []struct {
+		models.MediaTypeStats
+		LastModified *time.Time
+	}
+
+	err = fs.DB.Raw(`SELECT `+mediaTypeCase+` AS media_type, COUNT(*) AS file_count,
+		COALESCE(SUM(file_size), 0) AS size, MAX(updated_at) AS last_modified
+		FROM files WHERE deleted_at IS NULL AND folder_id IN (`+liveSubtreeQuery+`)
+		GROUP BY media_type ORDER BY media_type`, folder.ID).
+		Scan(&mediaTypes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.FolderStats{
+		FolderCount:  folders.FolderCount,
+		MediaTypes:   make([]models.MediaTypeStats, 0, len(mediaTypes)),
+		LastModified: folders.LastModified,
+	}
+
+	for _, mediaType := range mediaTypes {
+		stats.Size += mediaType.Size
+		stats.FileCount += mediaType.FileCount
+		stats.MediaTypes = append(stats.MediaTypes, mediaType.MediaTypeStats)
+
+		if mediaType.LastModified != nil && (stats.LastModified == nil || mediaType.LastModified.After(*stats.LastModified)) {
+			stats.LastModified = mediaType.LastModified
+		}
+	}
+
+	return stats, nil
+}
+
+// GetFolderDetail fetches a folder along with the stats of everything inside it: its size, file and
+// folder counts, a breakdown by media type, and its newest modification.
+//
+// If the folder is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
 func (fs *FolderService) GetFolderDetail(userID uint, folderCode string) (*models.Folder, error) {
 	var folder models.Folder
 
@@ -150,44 +206,80 @@ func (fs *FolderService) GetFolderDetail(userID uint, folderCode string) (*model
 		}
 	}
 
-	return &folder, nil
-}
-
-func (fs *FolderService) ListFavoriteFolders(userID uint) (*models.FolderResponse, error) {
-	var favoriteFolders []*models.Folder
-	if err := fs.DB.Where("user_id = ? AND is_favorite = ?", userID, true).Find(&favoriteFolders).Error; err != nil {
+	stats, err := fs.folderStats(&folder)
+	if err != nil {
 		return nil, &apperr.ServerError{
 			BaseError: &apperr.BaseError{
-				Message: "Failed to list favorite folders",
+				Message: "Failed to compute folder stats",
 				Err:     err,
 			},
 		}
 	}
+	folder.Stats = stats
+
+	return &folder, nil
+}
+
+// ListFavoriteFolders lists the folders of a user that are marked as favorite, one page at a time.
+//
+// If the params are invalid, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (fs *FolderService) ListFavoriteFolders(userID uint, params models.ListParams) (*models.FolderResponse, error) {
+	query := fs.DB.Model(&models.Folder{}).Where("folders.user_id = ? AND folders.is_favorite = ?", userID, true)
+
+	favoriteFolders, total, nextCursor, err := paginateFolders(query, params)
+	if err != nil {
+		return nil, err
+	}
 
 	return &models.FolderResponse{
-		Folders: favoriteFolders,
+		Folders:    favoriteFolders,
+		Total:      total,
+		NextCursor: nextCursor,
 	}, nil
 }
 
-func (fs *FolderService) ListTrashFolders(userID uint) (*models.FolderResponse, error) {
-	var trashFolders []*models.Folder
-	if err := fs.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).Find(&trashFolders).Error; err != nil {
+// ListTrashFolders lists the folders of a user that are trashed, one page at a time.
+// When the user has a trash retention, each folder has the days remaining before it's purged.
+//
+// If the params are invalid, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (fs *FolderService) ListTrashFolders(userID uint, params models.ListParams) (*models.FolderResponse, error) {
+	query := fs.DB.Unscoped().Model(&models.Folder{}).Where("folders.user_id = ? AND folders.deleted_at IS NOT NULL", userID)
+
+	trashFolders, total, nextCursor, err := paginateFolders(query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	daysRemaining, err := trashDaysRemaining(fs.DB, userID)
+	if err != nil {
 		return nil, &apperr.ServerError{
 			BaseError: &apperr.BaseError{
-				Message: "Failed to list trash folders",
+				Message: "Failed to fetch trash retention",
 				Err:     err,
 			},
 		}
 	}
 
+	if daysRemaining != nil {
+		for _, folder := range trashFolders {
+			folder.TrashDaysRemaining = daysRemaining(folder.DeletedAt)
+		}
+	}
+
 	return &models.FolderResponse{
-		Folders: trashFolders,
+		Folders:    trashFolders,
+		Total:      total,
+		NextCursor: nextCursor,
 	}, nil
 }
 
 // PatchFolder updates a folder. If folderUpdateBody.Restore is true, it will restore a soft-deleted folder.
+// onConflict is the conflict policy applied when the folder takes a name already used in its parent folder,
+// empty for the default policy of the operation.
 // Refactor, isolate each field update to its own method
-func (fs *FolderService) PatchFolder(userID uint, folderCode string, folderUpdateBody models.FolderUpdateBody) (*models.Folder, error) {
+func (fs *FolderService) PatchFolder(userID uint, folderCode string, folderUpdateBody models.FolderUpdateBody, onConflict string) (*models.Folder, error) {
 	var folder models.Folder
 
 	query := fs.DB.Where("code = ? AND user_id = ?", folderCode, userID).Preload("ParentFolder")
@@ -217,7 +309,7 @@ func (fs *FolderService) PatchFolder(userID uint, folderCode string, folderUpdat
 	var err error
 
 	if folderUpdateBody.FolderName != "" {
-		err = fs.renameFolder(&folder, folderUpdateBody.FolderName)
+		err = fs.renameFolder(&folder, folderUpdateBody.FolderName, onConflict)
 		if err != nil {
 			return nil, err
 		}
@@ -227,12 +319,12 @@ func (fs *FolderService) PatchFolder(userID uint, folderCode string, folderUpdat
 			return nil, err
 		}
 	} else if folderUpdateBody.Restore {
-		err = fs.restoreFolder(&folder)
+		err = fs.restoreFolder(&folder, onConflict)
 		if err != nil {
 			return nil, err
 		}
 	} else { // TODO: unsafe, refactor later
-		err = fs.moveFolder(&folder, folderUpdateBody.ParentFolderCode, userID)
+		err = fs.moveFolder(&folder, folderUpdateBody.ParentFolderCode, userID, onConflict)
 		if err != nil {
 			return nil, err
 		}
@@ -241,30 +333,46 @@ func (fs *FolderService) PatchFolder(userID uint, folderCode string, folderUpdat
 	return &folder, nil
 }
 
-func (fs *FolderService) renameFolder(folder *models.Folder, newName string) error {
+func (fs *FolderService) renameFolder(folder *models.Folder, newName, onConflict string) error {
+	// The root folder has no siblings to conflict with
+	if folder.ParentID != nil {
+		name, existing, err := resolveFolderConflict(fs.DB, *folder.ParentID, folder.ID, newName, conflictPolicy(onConflict, DEFAULT_RENAME_CONFLICT))
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			folder.Skipped = true
+			return nil
+		}
+		newName = name
+	}
+
+	oldName := folder.Name
 	folder.Name = newName
 	if err := fs.DB.Save(folder).Error; err != nil {
-		return &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: fmt.Sprintf("Failed to rename folder from %s to %s", folder.Name, newName),
-				Err:     err,
-			},
-		}
+		return nameConflictError(err, fmt.Sprintf("Failed to rename folder from %s to %s", oldName, newName))
 	}
 
 	return nil
 }
 
-func (fs *FolderService) restoreFolder(folder *models.Folder) error {
-	if err := fs.DB.Unscoped().Model(&folder).Update("deleted_at", nil).Error; err != nil {
-		return &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: "Failed to restore folder " + folder.Name,
-				Err:     err,
-			},
-		}
+func (fs *FolderService) restoreFolder(folder *models.Folder, onConflict string) error {
+	name, existing, err := resolveFolderConflict(fs.DB, *folder.ParentID, folder.ID, folder.Name, conflictPolicy(onConflict, DEFAULT_RESTORE_CONFLICT))
+	if err != nil {
+		return err
 	}
 
+	if existing != nil {
+		folder.Skipped = true
+		return nil
+	}
+
+	if err := fs.DB.Unscoped().Model(folder).Updates(map[string]interface{}{"deleted_at": nil, "name": name}).Error; err != nil {
+		return nameConflictError(err, "Failed to restore folder "+folder.Name)
+	}
+	folder.Name = name
+
 	if folder.ParentFolder.DeletedAt.Valid {
 		if err := fs.RecursivelyRestoreFoldersUpwards(folder.ParentFolder); err != nil {
 			return &apperr.ServerError{
@@ -294,7 +402,9 @@ func (fs *FolderService) toggleFolderFavorite(folder *models.Folder, isFavorite
 	return nil
 }
 
-func (fs *FolderService) moveFolder(folder *models.Folder, targetFolderCode string, userID uint) error {
+func (fs *FolderService) moveFolder(folder *models.Folder, targetFolderCode string, userID uint, onConflict string) error {
+	policy := conflictPolicy(onConflict, DEFAULT_MOVE_CONFLICT)
+
 	err := fs.DB.Transaction(func(tx *gorm.DB) error {
 		// Find new parent folder
 		// Set
@@ -315,7 +425,36 @@ func (fs *FolderService) moveFolder(folder *models.Folder, targetFolderCode stri
 			}
 		}
 
+		isDescendant, err := isSameOrDescendant(tx, folder.ID, &parentFolder)
+		if err != nil {
+			return &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to check folder ancestry",
+					Err:     err,
+				},
+			}
+		}
+
+		if isDescendant {
+			return &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "A folder can't be moved into itself or one of its subfolders",
+				},
+			}
+		}
+
+		name, existing, err := resolveFolderConflict(tx, parentFolder.ID, folder.ID, folder.Name, policy)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			folder.Skipped = true
+			return nil
+		}
+
 		// Update folder with new parent
+		folder.Name = name
 		folder.ParentID = &parentFolder.ID
 		folder.ParentFolder = &parentFolder
 
@@ -332,6 +471,15 @@ func (fs *FolderService) moveFolder(folder *models.Folder, targetFolderCode stri
 			}
 		}
 
+		if err := moveFolderClosure(tx, folder.ID, parentFolder.ID); err != nil {
+			return &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to update folder hierarchy",
+					Err:     err,
+				},
+			}
+		}
+
 		if err := tx.Save(parentFolder).Error; err != nil {
 			return &apperr.ServerError{
 				BaseError: &apperr.BaseError{
@@ -366,7 +514,7 @@ func (fs *FolderService) moveFolder(folder *models.Folder, targetFolderCode stri
 	})
 
 	if err != nil {
-		return err
+		return nameConflictError(err, "Failed to move folder")
 	}
 
 	if err := fs.DB.Model(&folder).Preload("ParentFolder").Find(&folder).Error; err != nil {
@@ -381,17 +529,18 @@ func (fs *FolderService) moveFolder(folder *models.Folder, targetFolderCode stri
 	return nil
 }
 
-// FetchFolderFiles fetches all files in the given folder.
+// FetchFolderFiles fetches the files in the given folder, one page at a time.
 //
 // If the folder is not found, it returns a NotFoundError.
+// If the params are invalid, it returns an InvalidParamError.
 // If other errors occur, it returns a ServerError.
-func (fs *FolderService) FetchFolderFiles(userID uint, folderCode string) ([]*models.File, error) {
+func (fs *FolderService) FetchFolderFiles(userID uint, folderCode string, params models.ListParams) (*models.FileListResponse, error) {
 	if folderCode == "root" {
 		folderCode = ""
 	}
 
 	var parentFolder models.Folder
-	if err := fs.DB.Where("user_id = ? AND code = ?", userID, folderCode).Preload("Files").First(&parentFolder).Error; err != nil {
+	if err := fs.DB.Where("user_id = ? AND code = ?", userID, folderCode).First(&parentFolder).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, &apperr.NotFoundError{
 				BaseError: &apperr.BaseError{
@@ -409,10 +558,20 @@ func (fs *FolderService) FetchFolderFiles(userID uint, folderCode string) ([]*mo
 		}
 	}
 
-	return parentFolder.Files, nil
+	query := fs.DB.Model(&models.File{}).Where("files.folder_id = ?", parentFolder.ID)
+
+	return paginateFiles(query, params)
 }
 
-func (fs *FolderService) UploadFile(userID uint, folderCode string, file *multipart.FileHeader) (*models.File, []byte, error) {
+// UploadFile stores an uploaded file in a folder. Uploading over a file with the same name
+// adds a new version to it. The content is checked against the digests of the client's
+// Content-Digest header, if any.
+//
+// If the folder is not found, it returns a NotFoundError.
+// If the content doesn't match the digests, it returns an InvalidParamError.
+// If the file doesn't fit in the user's quota, it returns a QuotaExceededError.
+// If other errors occur, it returns a ServerError.
+func (fs *FolderService) UploadFile(userID uint, folderCode string, file *multipart.FileHeader, digests map[string][]byte, onConflict string) (*models.File, []byte, error) {
 	query := fs.DB.Where("user_id = ? AND code = ?", userID, folderCode)
 
 	if folderCode == "root" {
@@ -438,6 +597,12 @@ func (fs *FolderService) UploadFile(userID uint, folderCode string, file *multip
 		}
 	}
 
+	// Reject the upload before anything gets stored if it doesn't fit in the user's quota
+	quotaService := NewQuotaService(fs.DB)
+	if err := quotaService.CheckQuota(userID, file.Size); err != nil {
+		return nil, nil, err
+	}
+
 	fileCode, err := uuid.NewV4()
 	if err != nil {
 		return nil, nil, &apperr.ServerError{
@@ -490,31 +655,67 @@ func (fs *FolderService) UploadFile(userID uint, folderCode string, file *multip
 	}
 	uploadedFile.Close()
 
-	filePath := "/" + fileCode.String()
+	if err := utils.VerifyContentDigest(digests, uploadedFileBytes); err != nil {
+		return nil, nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Content-Digest mismatch",
+				Err:     err,
+			},
+		}
+	}
 
-	// Use transaction, PutObject to minio could lead to an error. If it does, we can't let any changes happen in the database
-	err = fs.DB.Transaction(func(tx *gorm.DB) error {
-		// Upload the file to minio first
-		_, err = fs.BucketClient.PutObject(filePath, bytes.NewReader(uploadedFileBytes), file.Size, minio.PutObjectOptions{ContentType: file.Header.Get("Content-Type")})
-		if err != nil {
-			return fmt.Errorf("error while uploading file to MinIO: %v", err)
+	// By default, uploading a file with the same name into the same folder stores a new version of that file
+	policy := conflictPolicy(onConflict, DEFAULT_UPLOAD_CONFLICT)
+	name, existingFile, err := resolveFileConflict(fs.DB, parentFolder.ID, 0, file.Filename, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if existingFile != nil {
+		if policy == models.CONFLICT_SKIP {
+			existingFile.Skipped = true
+			return existingFile, nil, nil
 		}
 
-		if err := tx.Create(&newFile).Error; err != nil {
-			if err := fs.BucketClient.RemoveObject(filePath, minio.RemoveObjectOptions{}); err != nil {
-				return fmt.Errorf("error while undoing MinIO file uploading: %v", err)
-			}
-			return fmt.Errorf("error while creating file in database: %v", err)
+		fileVersionService := NewFileVersionService(fs.DB, fs.BucketClient)
+		if err := fileVersionService.UploadNewVersion(existingFile, uploadedFileBytes, file.Header.Get("Content-Type")); err != nil {
+			return nil, nil, err
 		}
 
-		return nil
-	})
+		return existingFile, uploadedFileBytes, nil
+	}
+	newFile.FileName = name
 
+	// Store the content first, identical contents already stored are only referenced
+	objectStore := NewObjectStore(fs.DB, fs.BucketClient)
+	content, err := objectStore.Store(uploadedFileBytes, file.Header.Get("Content-Type"))
 	if err != nil {
 		return nil, nil, &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Failed to upload file",
-				Err:     err,
+				Err:     fmt.Errorf("error while uploading file to MinIO: %v", err),
+			},
+		}
+	}
+
+	newFile.ObjectKey = content.Key
+	newFile.ContentHash = content.SHA256
+	newFile.ContentMD5 = content.MD5
+	newFile.Deduplicated = content.Deduplicated
+
+	if err := fs.DB.Create(&newFile).Error; err != nil {
+		if err := objectStore.Release(content.Key); err != nil {
+			log.Printf("Error while undoing MinIO file uploading: %s -> %v\n", content.Key, err)
+		}
+
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, nil, nameConflictError(err, "Failed to upload file")
+		}
+
+		return nil, nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to upload file",
+				Err:     fmt.Errorf("error while creating file in database: %v", err),
 			},
 		}
 	}
@@ -598,7 +799,20 @@ func (fs *FolderService) PostUploadProcess(file *models.File, uploadedFileBytes
 	}()
 }
 
-func (fs *FolderService) CreateFolder(folderName, parentFolderCode string, userID uint) (*models.Folder, error) {
+// IndexFileContent extracts the text of a document in the background and adds it to the search index.
+func (fs *FolderService) IndexFileContent(file *models.File, uploadedFileBytes []byte) {
+	contentIndexService := NewContentIndexService(fs.DB, fs.BucketClient)
+	go contentIndexService.IndexFile(file, uploadedFileBytes)
+}
+
+// CreateFolder creates a folder in a parent folder. onConflict is the conflict policy applied when the
+// parent folder already has a folder with the same name, creating it fails by default. When that
+// folder is skipped, it is returned instead.
+//
+// If the parent folder is not found, it returns a NotFoundError.
+// If the name is taken and the policy is to fail or overwrite, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (fs *FolderService) CreateFolder(folderName, parentFolderCode string, userID uint, onConflict string) (*models.Folder, error) {
 	newFolderCode, err := gonanoid.New()
 	if err != nil {
 		return nil, &apperr.ServerError{
@@ -636,20 +850,32 @@ func (fs *FolderService) CreateFolder(folderName, parentFolderCode string, userI
 		}
 	}
 
+	name, existing, err := resolveFolderConflict(fs.DB, parentFolder.ID, 0, folderName, conflictPolicy(onConflict, DEFAULT_CREATE_CONFLICT))
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		existing.Skipped = true
+		return existing, nil
+	}
+
 	newFolder := models.Folder{
 		UserID:   userID,
 		ParentID: &parentFolder.ID,
-		Name:     folderName,
+		Name:     name,
 		Code:     newFolderCode,
 	}
 
-	if err := fs.DB.Create(&newFolder).Error; err != nil {
-		return nil, &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: "Failed to create folder",
-				Err:     err,
-			},
+	err = fs.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newFolder).Error; err != nil {
+			return err
 		}
+		return insertFolderClosure(tx, &newFolder)
+	})
+
+	if err != nil {
+		return nil, nameConflictError(err, "Failed to create folder")
 	}
 
 	parentFolder.HasChild = true
@@ -721,15 +947,6 @@ func (fs *FolderService) DeleteFolderPermanent(folderCode string, userID uint) (
 		}
 	}
 
-	if err := fs.loadFolders(&targetFolder); err != nil {
-		return nil, &apperr.ServerError{
-			BaseError: &apperr.BaseError{
-				Message: "Failed to load folder",
-				Err:     err,
-			},
-		}
-	}
-
 	var deletedObjects DeletedFilesAndFoldersList = DeletedFilesAndFoldersList{
 		DeletedFiles:   []string{},
 		DeletedFolders: []string{},
@@ -747,83 +964,96 @@ func (fs *FolderService) DeleteFolderPermanent(folderCode string, userID uint) (
 	return &deletedObjects, nil
 }
 
-// Only used when we're trying to restore a deleted (temp) folder but its parent or grandparent or great-grandparent or whatever that is, is deleted (temp)
+// RecursivelyRestoreFoldersUpwards restores a folder and every trashed folder above it, when a restored item
+// sits in a trashed folder. The ancestors are read in a single query, from the root folder down.
 func (fs *FolderService) RecursivelyRestoreFoldersUpwards(parentFolder *models.Folder) error {
-	// Restore the parent parentFolder if it was soft deleted
-	if parentFolder.DeletedAt.Valid {
-		log.Printf("Restoring folder %s (%s)\n", parentFolder.Name, parentFolder.Code)
-		if err := fs.DB.Unscoped().Model(parentFolder).Update("deleted_at", nil).Error; err != nil {
-			return err
-		}
-	}
-
-	// Fetch the parent parentFolder
-	if err := fs.DB.Unscoped().Model(&models.Folder{}).Where("id = ?", parentFolder.ParentID).First(&parentFolder.ParentFolder).Error; err != nil {
+	ancestors, err := folderAncestors(fs.DB, parentFolder.ID)
+	if err != nil {
 		return err
 	}
 
-	if parentFolder.ParentFolder.Name == "/" {
-		return nil
-	}
+	for _, ancestor := range ancestors {
+		if !ancestor.DeletedAt.Valid {
+			continue
+		}
 
-	// Recursively restore the parent's parent
-	return fs.RecursivelyRestoreFoldersUpwards(parentFolder.ParentFolder)
-}
+		log.Printf("Restoring folder %s (%s)\n", ancestor.Name, ancestor.Code)
 
-// loadFolders preloads the immediate child folders of the given folder, and then
-// recursively loads all the children of each child folder. It returns an error if
-// any of the loads fail.
-func (fs *FolderService) loadFolders(folder *models.Folder) error {
-	// Preload immediate child folders
-	if err := fs.DB.Unscoped().Preload(clause.Associations).Find(&folder).Error; err != nil {
-		return err
-	}
+		// Ancestors aren't restored on request, a name taken in the meantime is always worked around
+		updates := map[string]interface{}{"deleted_at": nil}
+		if ancestor.ParentID != nil {
+			name, _, err := resolveFolderConflict(fs.DB, *ancestor.ParentID, ancestor.ID, ancestor.Name, models.CONFLICT_RENAME)
+			if err != nil {
+				return err
+			}
+			updates["name"] = name
+			ancestor.Name = name
+		}
 
-	// Recursively load children of each child folder
-	for i := range folder.ChildFolders {
-		if err := fs.loadFolders(folder.ChildFolders[i]); err != nil {
+		if err := fs.DB.Unscoped().Model(ancestor).Updates(updates).Error; err != nil {
 			return err
 		}
+
+		if ancestor.ID == parentFolder.ID {
+			parentFolder.Name = ancestor.Name
+			parentFolder.DeletedAt = gorm.DeletedAt{}
+		}
 	}
 
 	return nil
 }
 
-// processFolder recursively deletes all files and child folders of the given folder.
+// processFolder deletes a folder and everything inside it, trashed items included. The subtree is
+// read through the folder closures and deleted in bulk, so the number of queries doesn't grow with it.
 // This is called by DeleteFolderPermanent.
 func (fs *FolderService) processFolder(deletedObjects *DeletedFilesAndFoldersList, folder *models.Folder) error {
 	bc := fs.BucketClient
-	for _, child := range folder.ChildFolders {
-		if err := fs.processFolder(deletedObjects, child); err != nil {
-			return err
-		}
+	subtree := fs.DB.Model(&models.FolderClosure{}).Select("descendant_id").Where("ancestor_id = ?", folder.ID)
+
+	// The deepest folders come first, as they are listed in the response
+	var folders []*models.Folder
+	err := fs.DB.Unscoped().
+		Joins("JOIN folder_closures ON folder_closures.descendant_id = folders.id AND folder_closures.ancestor_id = ?", folder.ID).
+		Order("folder_closures.depth DESC").
+		Find(&folders).Error
+	if err != nil {
+		return err
 	}
 
-	var toBeDeletedFiles []*models.File
+	var files []*models.File
+	if err := fs.DB.Unscoped().Preload("Thumbnail").Where("folder_id IN (?)", subtree).Find(&files).Error; err != nil {
+		return err
+	}
+
+	var fileIDs []uint
+	var storageKeys []string
+	var videos []*models.File
 	var filesThumbnail []*models.Thumbnail
-	for _, file := range folder.Files {
+	for _, file := range files {
 		log.Printf("Deleting file %s (%s)\n", file.FileName, file.FileCode)
-		toBeDeletedFiles = append(toBeDeletedFiles, file)
+		fileIDs = append(fileIDs, file.ID)
+		storageKeys = append(storageKeys, file.StorageKey())
+		if strings.HasPrefix(file.FileType, "video/") && file.IsPreviewable {
+			videos = append(videos, file)
+		}
 		if file.Thumbnail != nil {
 			filesThumbnail = append(filesThumbnail, file.Thumbnail)
 		}
 	}
 
-	// Delete thumbnails from DB
-	if len(filesThumbnail) > 0 {
-		if err := fs.DB.Unscoped().Delete(&filesThumbnail).Error; err != nil {
-			return err
-		}
+	// Objects are removed before their rows, so a failure leaves rows the reconciliation
+	// job can repair instead of objects nothing references anymore
+	if err := NewHLSService(fs.DB, bc).DeleteHLSFiles(videos...); err != nil {
+		return err
+	}
 
+	if len(filesThumbnail) > 0 {
 		thumbObjCh := make(chan minio.ObjectInfo)
 		go func() {
 			defer close(thumbObjCh)
 			for _, thumb := range filesThumbnail {
-				if len(toBeDeletedFiles) > 0 {
-					obj := minio.ObjectInfo{
-						Key: thumb.FilePath,
-					}
-					thumbObjCh <- obj
+				thumbObjCh <- minio.ObjectInfo{
+					Key: thumb.FilePath,
 				}
 			}
 		}()
@@ -833,45 +1063,59 @@ func (fs *FolderService) processFolder(deletedObjects *DeletedFilesAndFoldersLis
 				return err.Err
 			}
 		}
-	}
 
-	// Delete files from DB
-	if len(toBeDeletedFiles) > 0 {
-		for deletedFile := range toBeDeletedFiles {
-			deletedObjects.DeletedFiles = append(deletedObjects.DeletedFiles, toBeDeletedFiles[deletedFile].FileCode)
+		// Delete thumbnails from DB
+		if err := fs.DB.Unscoped().Delete(&filesThumbnail).Error; err != nil {
+			return err
 		}
+	}
 
-		if err := fs.DB.Unscoped().Delete(&toBeDeletedFiles).Error; err != nil {
+	// Delete files from DB
+	if len(files) > 0 {
+		contentIndexService := NewContentIndexService(fs.DB, bc)
+		if err := contentIndexService.DeleteFileContent(fileIDs...); err != nil {
 			return err
 		}
-	}
 
-	objectsCh := make(chan minio.ObjectInfo)
-	go func() {
-		defer close(objectsCh)
-		for _, file := range toBeDeletedFiles {
-			if len(toBeDeletedFiles) > 0 {
-				obj := minio.ObjectInfo{
-					Key: file.FileCode,
-				}
-				objectsCh <- obj
-			}
+		fileVersionService := NewFileVersionService(fs.DB, bc)
+		if err := fileVersionService.DeleteAllVersions(fileIDs...); err != nil {
+			return err
 		}
-	}()
 
-	for err := range bc.Client.RemoveObjects(bc.Context, bc.Bucket, objectsCh, minio.RemoveObjectsOptions{}) {
-		if err.Err != nil {
-			return err.Err
+		if err := fs.DB.Unscoped().Delete(&files).Error; err != nil {
+			return err
 		}
 	}
 
-	log.Printf("Deleting folder %s (%s)\n", folder.Name, folder.Code)
+	// Contents shared with other files are only removed with their last reference
+	if err := NewObjectStore(fs.DB, bc).Release(storageKeys...); err != nil {
+		return err
+	}
 
-	deletedObjects.DeletedFolders = append(deletedObjects.DeletedFolders, folder.Code)
+	var folderIDs []uint
+	for _, subfolder := range folders {
+		log.Printf("Deleting folder %s (%s)\n", subfolder.Name, subfolder.Code)
+		folderIDs = append(folderIDs, subfolder.ID)
+	}
 
-	if err := fs.DB.Unscoped().Delete(&folder).Error; err != nil {
+	// Folders reference their parent, the links within the subtree are cut first so every folder
+	// goes in a single statement
+	err = fs.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Folder{}).Where("id IN ? AND id <> ?", folderIDs, folder.ID).Update("parent_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.Folder{}, folderIDs).Error
+	})
+	if err != nil {
 		return err
 	}
 
+	for _, file := range files {
+		deletedObjects.DeletedFiles = append(deletedObjects.DeletedFiles, file.FileCode)
+	}
+	for _, subfolder := range folders {
+		deletedObjects.DeletedFolders = append(deletedObjects.DeletedFolders, subfolder.Code)
+	}
+
 	return nil
 }
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// countQueries counts the statements run on the database from now on.
+func countQueries(t *testing.T, db *gorm.DB) *int {
+	count := 0
+	callback := db.Callback()
+	for name, processor := range map[string]interface {
+		Register(string, func(*gorm.DB)) error
+	}{
+		"query":  callback.Query(),
+		"create": callback.Create(),
+		"update": callback.Update(),
+		"delete": callback.Delete(),
+		"row":    callback.Row(),
+		"raw":    callback.Raw(),
+	} {
+		if err := processor.Register("test:count_"+name, func(*gorm.DB) { count++ }); err != nil {
+			t.Fatalf("failed to register callback: %v", err)
+		}
+	}
+	return &count
+}
+
+// createTestBranch creates a chain of depth folders under parent, each holding a file.
+func createTestBranch(t *testing.T, db *gorm.DB, userID uint, parent *models.Folder, depth int) *models.Folder {
+	var top *models.Folder
+	for i := 0; i < depth; i++ {
+		parent = createTestFolder(t, db, userID, parent, fmt.Sprintf("level-%d", i))
+		createTestFile(t, db, userID, parent, fmt.Sprintf("file-%d", i))
+		if top == nil {
+			top = parent
+		}
+	}
+	return top
+}
+
+func TestDeleteFolderPermanentInBulk(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+	root := createTestFolder(t, db, userID, nil, "root")
+
+	service := NewFolderService(db)
+	service.SetBucketClient(&models.BucketClient{Bucket: "test"})
+	queries := countQueries(t, db)
+
+	queriesFor := func(depth int) int {
+		top := createTestBranch(t, db, userID, root, depth)
+		if err := db.Delete(top).Error; err != nil {
+			t.Fatalf("failed to trash folder: %v", err)
+		}
+
+		before := *queries
+		deleted, err := service.DeleteFolderPermanent(top.Code, userID)
+		if err != nil {
+			t.Fatalf("DeleteFolderPermanent() error = %v", err)
+		}
+		count := *queries - before
+
+		if len(deleted.DeletedFolders) != depth || len(deleted.DeletedFiles) != depth {
+			t.Errorf("DeleteFolderPermanent() deleted %d folders and %d files, want %d of each", len(deleted.DeletedFolders), len(deleted.DeletedFiles), depth)
+		}
+		if deleted.DeletedFolders[depth-1] != top.Code {
+			t.Errorf("DeleteFolderPermanent() deleted %s last, want the folder itself", deleted.DeletedFolders[depth-1])
+		}
+
+		var left int64
+		db.Unscoped().Model(&models.Folder{}).Where("code IN ?", deleted.DeletedFolders).Count(&left)
+		if left != 0 {
+			t.Errorf("%d folders left after DeleteFolderPermanent()", left)
+		}
+		db.Unscoped().Model(&models.File{}).Where("file_code IN ?", deleted.DeletedFiles).Count(&left)
+		if left != 0 {
+			t.Errorf("%d files left after DeleteFolderPermanent()", left)
+		}
+
+		return count
+	}
+
+	shallow, deep := queriesFor(2), queriesFor(6)
+	if shallow != deep {
+		t.Errorf("DeleteFolderPermanent() ran %d queries for 2 levels and %d for 6, want as many", shallow, deep)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// liveSubtreeQuery selects the IDs of a folder and of every folder inside it, leaving out the
+// folders inside a trashed folder along with it.
+const liveSubtreeQuery = `SELECT subtree.descendant_id FROM folder_closures subtree
+	WHERE subtree.ancestor_id = ? AND NOT EXISTS (
+		SELECT 1 FROM folder_closures up JOIN folders ON folders.id = up.ancestor_id
+		WHERE up.descendant_id = subtree.descendant_id AND up.depth < subtree.depth AND folders.deleted_at IS NOT NULL
+	)`
+
+// trashedFoldersQuery selects the IDs of the folders of a user that are trashed or inside a trashed
+// folder. Trashing a folder only marks the folder itself, what it holds goes to the trash with it.
+const trashedFoldersQuery = `SELECT up.descendant_id FROM folder_closures up JOIN folders ON folders.id = up.ancestor_id
+	WHERE folders.user_id = ? AND folders.deleted_at IS NOT NULL`
+
+// insertFolderClosure links a new folder to itself and to the ancestors of its parent.
+func insertFolderClosure(db *gorm.DB, folder *models.Folder) error {
+	if err := db.Create(&models.FolderClosure{AncestorID: folder.ID, DescendantID: folder.ID}).Error; err != nil {
+		return err
+	}
+
+	if folder.ParentID == nil {
+		return nil
+	}
+
+	return db.Exec(`INSERT INTO folder_closures (ancestor_id, descendant_id, depth)
+		SELECT ancestor_id, ?, depth + 1 FROM folder_closures WHERE descendant_id = ?`, folder.ID, *folder.ParentID).Error
+}
+
+// moveFolderClosure relinks a moved folder and everything inside it to the ancestors of its new parent.
+func moveFolderClosure(db *gorm.DB, folderID, newParentID uint) error {
+	// Links from the old ancestors into the subtree go, links within the subtree stay
+	err := db.Exec(`DELETE link FROM folder_closures link
+		JOIN folder_closures subtree ON subtree.descendant_id = link.descendant_id AND subtree.ancestor_id = ?
+		LEFT JOIN folder_closures inside ON inside.ancestor_id = ? AND inside.descendant_id = link.ancestor_id
+		WHERE inside.ancestor_id IS NULL`, folderID, folderID).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Exec(`INSERT INTO folder_closures (ancestor_id, descendant_id, depth)
+		SELECT above.ancestor_id, subtree.descendant_id, above.depth + subtree.depth + 1
+		FROM folder_closures above JOIN folder_closures subtree
+		WHERE above.descendant_id = ? AND subtree.ancestor_id = ?`, newParentID, folderID).Error
+}
+
+// folderAncestors returns a folder and its ancestors, trashed ones included, from the root folder down.
+func folderAncestors(db *gorm.DB, folderID uint) ([]*models.Folder, error) {
+	var ancestors []*models.Folder
+	err := db.Unscoped().
+		Joins("JOIN folder_closures ON folder_closures.ancestor_id = folders.id").
+		Where("folder_closures.descendant_id = ?", folderID).
+		Order("folder_closures.depth DESC").
+		Find(&ancestors).Error
+	return ancestors, err
+}
+
+// isSameOrDescendant reports whether candidate is the folder with the given ID or one of its descendants.
+func isSameOrDescendant(db *gorm.DB, folderID uint, candidate *models.Folder) (bool, error) {
+	var count int64
+	err := db.Model(&models.FolderClosure{}).
+		Where("ancestor_id = ? AND descendant_id = ?", folderID, candidate.ID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// loadFolderTree loads the live child folders and files of a folder, and of every folder inside it,
+// in two queries whatever the depth of the tree. Trashed folders and files are left out, along with
+// everything inside trashed folders. The given preloads apply to the files.
+func loadFolderTree(db *gorm.DB, folder *models.Folder, filePreloads ...string) error {
+	folderQuery := db.Where("folders.id <> ? AND folders.id IN (?)", folder.ID, db.Raw(liveSubtreeQuery, folder.ID))
+	fileQuery := db
+
+	var folders []*models.Folder
+	if err := folderQuery.Find(&folders).Error; err != nil {
+		return err
+	}
+
+	byID := map[uint]*models.Folder{folder.ID: folder}
+	folderIDs := []uint{folder.ID}
+	for _, child := range folders {
+		byID[child.ID] = child
+		folderIDs = append(folderIDs, child.ID)
+	}
+
+	var files []*models.File
+	for _, preload := range filePreloads {
+		fileQuery = fileQuery.Preload(preload)
+	}
+	if err := fileQuery.Where("folder_id IN ?", folderIDs).Find(&files).Error; err != nil {
+		return err
+	}
+
+	folder.ChildFolders = nil
+	folder.Files = nil
+	for _, child := range folders {
+		if parent, ok := byID[*child.ParentID]; ok {
+			parent.ChildFolders = append(parent.ChildFolders, child)
+		}
+	}
+	for _, file := range files {
+		byID[file.FolderID].Files = append(byID[file.FolderID].Files, file)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// testDB opens the MariaDB database named by TEST_DB_DSN, migrated by the server beforehand, in a
+// transaction rolled back once the test is done. Tests needing it are skipped when it isn't set.
+func testDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to start a transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+
+	return tx
+}
+
+func createTestFolder(t *testing.T, db *gorm.DB, userID uint, parent *models.Folder, name string) *models.Folder {
+	folder := &models.Folder{UserID: userID, Name: name, Code: fmt.Sprintf("%s-%d", name, time.Now().UnixNano())}
+	if parent != nil {
+		folder.ParentID = &parent.ID
+	}
+
+	if err := db.Create(folder).Error; err != nil {
+		t.Fatalf("failed to create folder %s: %v", name, err)
+	}
+	if err := insertFolderClosure(db, folder); err != nil {
+		t.Fatalf("failed to link folder %s: %v", name, err)
+	}
+
+	return folder
+}
+
+// closureDepths returns the depth of a folder below each of its ancestors, by ancestor ID.
+func closureDepths(t *testing.T, db *gorm.DB, folderID uint) map[uint]uint {
+	var closures []models.FolderClosure
+	if err := db.Where("descendant_id = ?", folderID).Find(&closures).Error; err != nil {
+		t.Fatalf("failed to read closures: %v", err)
+	}
+
+	depths := map[uint]uint{}
+	for _, closure := range closures {
+		depths[closure.AncestorID] = closure.Depth
+	}
+	return depths
+}
+
+func assertDepths(t *testing.T, name string, got, want map[uint]uint) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: ancestors = %v, want %v", name, got, want)
+	}
+	for ancestorID, depth := range want {
+		if got[ancestorID] != depth {
+			t.Fatalf("%s: ancestors = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMoveFolderClosure(t *testing.T) {
+	db := testDB(t)
+
+	user := models.User{Email: fmt.Sprintf("closure-%d@test.local", time.Now().UnixNano())}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	// root/a/b/c and root/d, then b is moved into d
+	root := createTestFolder(t, db, user.ID, nil, "root")
+	a := createTestFolder(t, db, user.ID, root, "a")
+	b := createTestFolder(t, db, user.ID, a, "b")
+	c := createTestFolder(t, db, user.ID, b, "c")
+	d := createTestFolder(t, db, user.ID, root, "d")
+
+	assertDepths(t, "c before the move", closureDepths(t, db, c.ID), map[uint]uint{c.ID: 0, b.ID: 1, a.ID: 2, root.ID: 3})
+
+	if err := moveFolderClosure(db, b.ID, d.ID); err != nil {
+		t.Fatalf("moveFolderClosure() error = %v", err)
+	}
+
+	assertDepths(t, "b", closureDepths(t, db, b.ID), map[uint]uint{b.ID: 0, d.ID: 1, root.ID: 2})
+	assertDepths(t, "c", closureDepths(t, db, c.ID), map[uint]uint{c.ID: 0, b.ID: 1, d.ID: 2, root.ID: 3})
+	assertDepths(t, "a", closureDepths(t, db, a.ID), map[uint]uint{a.ID: 0, root.ID: 1})
+
+	if inside, err := isSameOrDescendant(db, a.ID, c); err != nil || inside {
+		t.Errorf("isSameOrDescendant(a, c) = %v, %v after the move, want false", inside, err)
+	}
+	if inside, err := isSameOrDescendant(db, d.ID, c); err != nil || !inside {
+		t.Errorf("isSameOrDescendant(d, c) = %v, %v after the move, want true", inside, err)
+	}
+
+	ancestors, err := folderAncestors(db, c.ID)
+	if err != nil {
+		t.Fatalf("folderAncestors() error = %v", err)
+	}
+	names := []string{}
+	for _, ancestor := range ancestors {
+		names = append(names, ancestor.Name)
+	}
+	if fmt.Sprint(names) != "[root d b c]" {
+		t.Errorf("folderAncestors(c) = %v, want [root d b c]", names)
+	}
+}
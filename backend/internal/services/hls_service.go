@@ -121,6 +121,7 @@ func (hs *HLSService) ProcessHLS(filePath string, file *models.File) {
 
 	// log.Println("Creating temporary directory: " + tmpDir)
 	os.MkdirAll(tmpDir, 0755)
+	defer os.RemoveAll(tmpDir)
 
 	log.Println("Processing HLS file: " + file.FileName)
 	err := ffmpeg.Input(filePath).
@@ -170,6 +171,7 @@ func (hs *HLSService) ProcessHLS(filePath string, file *models.File) {
 	}
 
 	// log.Println("Uploading HLS files")
+	var hlsSize int64
 	for _, hlsFile := range files {
 		if !hlsFile.IsDir() {
 			filePath := fmt.Sprintf("%s/%s", tmpDir, hlsFile.Name())
@@ -177,6 +179,7 @@ func (hs *HLSService) ProcessHLS(filePath string, file *models.File) {
 			fileData, err := os.Open(filePath)
 			if err != nil {
 				log.Printf("Error while reading file: %s -> %v\n", filePath, err)
+				hs.removePartialUpload(file)
 				return
 			}
 
@@ -184,6 +187,8 @@ func (hs *HLSService) ProcessHLS(filePath string, file *models.File) {
 			fileInfo, err := fileData.Stat()
 			if err != nil {
 				log.Printf("Error while reading file info: %s -> %v\n", filePath, err)
+				fileData.Close()
+				hs.removePartialUpload(file)
 				return
 			}
 			fileSize := fileInfo.Size()
@@ -199,38 +204,58 @@ func (hs *HLSService) ProcessHLS(filePath string, file *models.File) {
 
 			// log.Println("Uploading HLS file: " + hlsFilePath + " to " + userBucket)
 			_, err = hs.BucketClient.PutServiceObject(hlsFilePath, fileData, fileSize, minio.PutObjectOptions{ContentType: contentType})
+			fileData.Close()
 			if err != nil {
 				log.Printf("Error while uploading file: %s -> %v\n", filePath, err)
+				hs.removePartialUpload(file)
 				return
 			}
+			hlsSize += fileSize
 		}
 	}
 
-	if err := hs.DB.Model(&file).Update("is_previewable", true).Error; err != nil {
+	if err := hs.DB.Model(&file).Updates(map[string]interface{}{"is_previewable": true, "hls_size": hlsSize}).Error; err != nil {
 		log.Printf("Error while updating asset file in database: %v", err)
+		hs.removePartialUpload(file)
 		return
 	}
 
 	log.Println("Created HLS playlist: " + file.FileCode)
 }
 
-func (hs *HLSService) DeleteHLSFiles(file *models.File) error {
+// removePartialUpload removes the HLS files uploaded by a ProcessHLS that failed midway,
+// so they don't stay in the service bucket without a previewable file referencing them.
+func (hs *HLSService) removePartialUpload(file *models.File) {
+	if err := hs.DeleteHLSFiles(file); err != nil {
+		log.Printf("Error while removing partially uploaded HLS files: %s -> %v\n", file.FileCode, err)
+	}
+}
+
+// DeleteHLSFiles removes the HLS playlists and segments of the given files, in a single removal
+// request however many files are given.
+func (hs *HLSService) DeleteHLSFiles(files ...*models.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
 	ctx := context.Background()
 
 	objectsCh := make(chan minio.ObjectInfo)
 
 	go func() {
 		defer close(objectsCh)
-		for object := range hs.BucketClient.Client.ListObjects(ctx, hs.BucketClient.ServiceBucket, minio.ListObjectsOptions{
-			Prefix:    "hls/" + file.FileCode,
-			Recursive: true,
-		}) {
-			if object.Err != nil {
-				log.Println("Error listing HLS files: ", object.Err)
-				continue
-			}
-			if strings.HasSuffix(object.Key, ".m3u8") || strings.HasSuffix(object.Key, ".ts") {
-				objectsCh <- object
+		for _, file := range files {
+			for object := range hs.BucketClient.Client.ListObjects(ctx, hs.BucketClient.ServiceBucket, minio.ListObjectsOptions{
+				Prefix:    "hls/" + file.FileCode,
+				Recursive: true,
+			}) {
+				if object.Err != nil {
+					log.Println("Error listing HLS files: ", object.Err)
+					continue
+				}
+				if strings.HasSuffix(object.Key, ".m3u8") || strings.HasSuffix(object.Key, ".ts") {
+					objectsCh <- object
+				}
 			}
 		}
 	}()
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	LDAP_TIMEOUT              = 10 * time.Second
+	DEFAULT_LDAP_USER_FILTER  = "(mail={email})"
+	DEFAULT_LDAP_MEMBER_OF    = "memberOf"
+	DEFAULT_LDAP_EMAIL_ATTR   = "mail"
+	DEFAULT_LDAP_FIRST_NAME   = "givenName"
+	DEFAULT_LDAP_LAST_NAME    = "sn"
+	LDAP_GROUP_LIST_SEPARATOR = ";"
+)
+
+// LDAPConfig is the directory the LDAP authenticator binds to.
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string // Account the users are searched with, anonymous when empty
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // {email} is replaced with the escaped email
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	MemberOfAttribute  string
+	GroupBaseDN        string // Groups are also searched when set, for directories without memberOf
+	GroupFilter        string // {dn} is replaced with the escaped DN of the user
+	UserGroups         []string
+	AdminGroups        []string
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// splitGroups splits a list of group DNs, which contain commas so they are separated by semicolons.
+func splitGroups(list string) []string {
+	groups := []string{}
+	for _, group := range strings.Split(list, LDAP_GROUP_LIST_SEPARATOR) {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// LDAPConfigFromEnv reads the directory from LDAP_URL and LDAP_BASE_DN, and the optional LDAP_BIND_DN,
+// LDAP_BIND_PASSWORD, LDAP_START_TLS, LDAP_INSECURE_SKIP_VERIFY, LDAP_USER_FILTER, LDAP_*_ATTRIBUTE,
+// LDAP_GROUP_BASE_DN and LDAP_GROUP_FILTER. Members of LDAP_ADMIN_GROUPS become administrators, and
+// when LDAP_USER_GROUPS is set only members of these groups (or the admin ones) can log in.
+func LDAPConfigFromEnv() (*LDAPConfig, error) {
+	config := &LDAPConfig{
+		URL:                os.Getenv("LDAP_URL"),
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         envOrDefault("LDAP_USER_FILTER", DEFAULT_LDAP_USER_FILTER),
+		EmailAttribute:     envOrDefault("LDAP_EMAIL_ATTRIBUTE", DEFAULT_LDAP_EMAIL_ATTR),
+		FirstNameAttribute: envOrDefault("LDAP_FIRST_NAME_ATTRIBUTE", DEFAULT_LDAP_FIRST_NAME),
+		LastNameAttribute:  envOrDefault("LDAP_LAST_NAME_ATTRIBUTE", DEFAULT_LDAP_LAST_NAME),
+		MemberOfAttribute:  envOrDefault("LDAP_MEMBER_OF_ATTRIBUTE", DEFAULT_LDAP_MEMBER_OF),
+		GroupBaseDN:        os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:        os.Getenv("LDAP_GROUP_FILTER"),
+		UserGroups:         splitGroups(os.Getenv("LDAP_USER_GROUPS")),
+		AdminGroups:        splitGroups(os.Getenv("LDAP_ADMIN_GROUPS")),
+	}
+
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required by the ldap auth backend")
+	}
+
+	if !strings.Contains(config.UserFilter, "{email}") {
+		return nil, errors.New("LDAP_USER_FILTER must contain {email}")
+	}
+
+	if config.GroupBaseDN != "" && !strings.Contains(config.GroupFilter, "{dn}") {
+		return nil, errors.New("LDAP_GROUP_FILTER must contain {dn} when LDAP_GROUP_BASE_DN is set")
+	}
+
+	return config, nil
+}
+
+// LDAPAuthenticator checks passwords by binding to a directory as the user. Users are provisioned
+// on their first login, and their names and role are synced from the directory on every login.
+type LDAPAuthenticator struct {
+	DB          *gorm.DB
+	UserService *UserService
+	Config      *LDAPConfig
+}
+
+func NewLDAPAuthenticator(db *gorm.DB, userService *UserService, config *LDAPConfig) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		DB:          db,
+		UserService: userService,
+		Config:      config,
+	}
+}
+
+func ldapServerError(err error) error {
+	return &apperr.ServerError{
+		BaseError: &apperr.BaseError{
+			Message: "Directory unavailable",
+			Err:     err,
+		},
+	}
+}
+
+// connect opens a connection to the directory, bound as the search account.
+func (la *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: la.Config.InsecureSkipVerify}
+
+	conn, err := ldap.DialURL(la.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: LDAP_TIMEOUT}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(LDAP_TIMEOUT)
+
+	if la.Config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if err := la.bindSearchAccount(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (la *LDAPAuthenticator) bindSearchAccount(conn *ldap.Conn) error {
+	if la.Config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(la.Config.BindDN, la.Config.BindPassword)
+}
+
+// findEntry searches the directory entry of an email, nil when there's none.
+func (la *LDAPAuthenticator) findEntry(conn *ldap.Conn, email string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		la.Config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(LDAP_TIMEOUT.Seconds()), false,
+		strings.ReplaceAll(la.Config.UserFilter, "{email}", ldap.EscapeFilter(email)),
+		[]string{la.Config.EmailAttribute, la.Config.FirstNameAttribute, la.Config.LastNameAttribute, la.Config.MemberOfAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+
+	switch {
+	case result == nil || len(result.Entries) == 0:
+		return nil, nil
+	case len(result.Entries) > 1:
+		return nil, errors.New("the LDAP user filter matches several entries")
+	}
+
+	return result.Entries[0], nil
+}
+
+// groupsOf returns the DNs of the groups of a directory entry, from its memberOf attribute and
+// from a search of the groups when one is configured.
+func (la *LDAPAuthenticator) groupsOf(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	groups := entry.GetAttributeValues(la.Config.MemberOfAttribute)
+	if la.Config.GroupBaseDN == "" {
+		return groups, nil
+	}
+
+	request := ldap.NewSearchRequest(
+		la.Config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(LDAP_TIMEOUT.Seconds()), false,
+		strings.ReplaceAll(la.Config.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN)),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+
+	return groups, nil
+}
+
+// inGroups tells whether one of the groups of a user is in a list, DNs are compared regardless of case.
+func inGroups(groups, list []string) bool {
+	for _, group := range groups {
+		for _, listed := range list {
+			if strings.EqualFold(group, listed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate binds to the directory as the user with the email, then provisions or syncs the user.
+// Emails of accounts with their own password are left to the next authenticator, without binding.
+//
+// If the password is wrong, it returns an InvalidCredentialsError.
+// If the user isn't in a group allowed to log in or the account is deleted, it returns a ForbiddenError.
+// If a local account has the email the directory entry gives, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (la *LDAPAuthenticator) Authenticate(email, password string) (*models.User, error) {
+	invalidErr := &apperr.InvalidCredentialsError{
+		BaseError: &apperr.BaseError{
+			Message: "Invalid credentials",
+		},
+	}
+
+	// An empty password would be an unauthenticated bind, which succeeds
+	if password == "" {
+		return nil, invalidErr
+	}
+
+	isOtherAccount, err := la.isOtherAccount(email)
+	if err != nil {
+		return nil, err
+	}
+	if isOtherAccount {
+		return nil, nil
+	}
+
+	conn, err := la.connect()
+	if err != nil {
+		return nil, ldapServerError(err)
+	}
+	defer conn.Close()
+
+	entry, err := la.findEntry(conn, email)
+	if err != nil {
+		return nil, ldapServerError(err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, invalidErr
+		}
+		return nil, ldapServerError(err)
+	}
+
+	// Groups may only be readable by the search account
+	if err := la.bindSearchAccount(conn); err != nil {
+		return nil, ldapServerError(err)
+	}
+
+	groups, err := la.groupsOf(conn, entry)
+	if err != nil {
+		return nil, ldapServerError(err)
+	}
+
+	isAdmin := inGroups(groups, la.Config.AdminGroups)
+	if len(la.Config.UserGroups) > 0 && !isAdmin && !inGroups(groups, la.Config.UserGroups) {
+		return nil, &apperr.ForbiddenError{
+			BaseError: &apperr.BaseError{
+				Message: "Not allowed to log in",
+			},
+		}
+	}
+
+	role := models.ROLE_USER
+	if isAdmin {
+		role = models.ROLE_ADMIN
+	}
+
+	if directoryEmail := entry.GetAttributeValue(la.Config.EmailAttribute); directoryEmail != "" {
+		email = directoryEmail
+	}
+
+	return la.syncUser(email, entry, role)
+}
+
+// isOtherAccount tells whether an account of another source, like a local one, has the email.
+func (la *LDAPAuthenticator) isOtherAccount(email string) (bool, error) {
+	var count int64
+	err := la.DB.Unscoped().Model(&models.User{}).
+		Where("email = ? AND auth_source <> ?", email, models.AUTH_SOURCE_LDAP).
+		Count(&count).Error
+	if err != nil {
+		return false, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	return count > 0, nil
+}
+
+// syncUser provisions the user of a directory entry on their first login, or else updates their
+// names and role from the directory.
+func (la *LDAPAuthenticator) syncUser(email string, entry *ldap.Entry, role string) (*models.User, error) {
+	firstName := truncateName(entry.GetAttributeValue(la.Config.FirstNameAttribute))
+	lastName := truncateName(entry.GetAttributeValue(la.Config.LastNameAttribute))
+	if isAdminEmail(email) {
+		role = models.ROLE_ADMIN
+	}
+
+	var user models.User
+	err := la.DB.Unscoped().Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		now := time.Now()
+		user = models.User{
+			FirstName:       firstName,
+			LastName:        lastName,
+			Email:           email,
+			AuthSource:      models.AUTH_SOURCE_LDAP,
+			EmailVerifiedAt: &now,
+		}
+
+		if err := la.UserService.provisionUser(&user); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	if user.DeletedAt.Valid {
+		return nil, &apperr.ForbiddenError{
+			BaseError: &apperr.BaseError{
+				Message: "Account deleted",
+			},
+		}
+	}
+
+	// The directory can't take over an account that has its own password
+	if user.AuthSource != models.AUTH_SOURCE_LDAP {
+		return nil, &apperr.ConflictError{
+			BaseError: &apperr.BaseError{
+				Message: "A local account with this email exists",
+			},
+		}
+	}
+
+	if firstName != "" {
+		user.FirstName = firstName
+	}
+	if lastName != "" {
+		user.LastName = lastName
+	}
+	user.Role = role
+
+	if err := la.DB.Model(&user).Select("first_name", "last_name", "role").Updates(&user).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	return &user, nil
+}
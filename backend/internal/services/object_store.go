@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DEDUP_SCOPE_USER      = "user"
+	DEDUP_SCOPE_SERVER    = "server"
+	DEFAULT_SHARED_BUCKET = "cloudchest-shared"
+	userObjectPrefix      = "objects/"
+)
+
+// DedupScope returns where identical contents are deduplicated, read from DEDUP_SCOPE.
+// By default contents are deduplicated per user, inside their own bucket.
+func DedupScope() string {
+	if os.Getenv("DEDUP_SCOPE") == DEDUP_SCOPE_SERVER {
+		return DEDUP_SCOPE_SERVER
+	}
+	return DEDUP_SCOPE_USER
+}
+
+// SharedBucketName returns the bucket holding the contents deduplicated across users.
+// It is returned regardless of the scope, so objects stored while the server scope was
+// enabled can still be found after switching back.
+func SharedBucketName() string {
+	if bucket := os.Getenv("DEDUP_SHARED_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return DEFAULT_SHARED_BUCKET
+}
+
+// EnsureSharedBucket creates the shared bucket when contents are deduplicated across users.
+func EnsureSharedBucket(client *minio.Client) error {
+	if DedupScope() != DEDUP_SCOPE_SERVER {
+		return nil
+	}
+
+	ctx := context.Background()
+	bucket := SharedBucketName()
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil || exists {
+		return err
+	}
+
+	return client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{
+		Region: "us-east-1",
+	})
+}
+
+// ObjectStore stores the contents of files and versions by the SHA-256 of their bytes and
+// counts the references to each of them, so identical contents are only kept once.
+//
+// Objects stored before deduplication have no StoredObject, they belong to a single file.
+type ObjectStore struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func NewObjectStore(db *gorm.DB, bc *models.BucketClient) *ObjectStore {
+	return &ObjectStore{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+func (s *ObjectStore) location(hash string) (bucket, key string) {
+	if DedupScope() == DEDUP_SCOPE_SERVER && s.BucketClient.SharedBucket != "" {
+		return s.BucketClient.SharedBucket, models.SHARED_OBJECT_PREFIX + hash
+	}
+	return s.BucketClient.Bucket, userObjectPrefix + hash
+}
+
+// StoredContent describes the object some content was stored in, along with its checksums.
+type StoredContent struct {
+	Key          string
+	SHA256       string
+	MD5          string
+	Size         uint
+	Deduplicated bool
+}
+
+// Store adds a reference to the object holding data, uploading it only if no identical
+// content is stored yet. Deduplicated is set when the content was already stored, unless
+// contents are deduplicated across users: it would tell whether another user stores that content.
+//
+// Uploads are checked end to end: MinIO verifies the Content-MD5 of the request, and the
+// ETag it returns must match the MD5 computed here.
+func (s *ObjectStore) Store(data []byte, contentType string) (*StoredContent, error) {
+	sha256Sum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+	hash := hex.EncodeToString(sha256Sum[:])
+	bucket, key := s.location(hash)
+
+	content := &StoredContent{
+		Key:    key,
+		SHA256: hash,
+		MD5:    hex.EncodeToString(md5Sum[:]),
+		Size:   uint(len(data)),
+	}
+
+	// The reference is taken with a single UPDATE, so it can't race with Release
+	// deleting the last reference to the same object
+	result := s.DB.Model(&models.StoredObject{}).
+		Where("bucket = ? AND hash = ?", bucket, hash).
+		Update("ref_count", gorm.Expr("ref_count + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		content.Deduplicated = DedupScope() != DEDUP_SCOPE_SERVER
+		return content, nil
+	}
+
+	info, err := s.BucketClient.PutObject(key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: contentType, SendContentMd5: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// Multipart uploads have an ETag made of the MD5 of each part, those were checked by MinIO already
+	if etag := strings.Trim(info.ETag, `"`); !strings.Contains(etag, "-") && etag != content.MD5 {
+		if err := s.BucketClient.RemoveObject(key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Error while removing corrupted upload: %s -> %v\n", key, err)
+		}
+		return nil, fmt.Errorf("stored object ETag %s does not match MD5 %s", etag, content.MD5)
+	}
+
+	// Another upload of the same content may have created the row in the meantime
+	storedObject := models.StoredObject{
+		Bucket:    bucket,
+		Hash:      hash,
+		MD5:       content.MD5,
+		ObjectKey: key,
+		Size:      content.Size,
+		RefCount:  1,
+	}
+
+	err = s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "bucket"}, {Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+	}).Create(&storedObject).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// Duplicate adds a reference to the object stored under key and returns the key the copy
+// should use. Deduplicated objects are shared, other objects are copied to fallbackKey.
+func (s *ObjectStore) Duplicate(key, fallbackKey string) (string, error) {
+	result := s.DB.Model(&models.StoredObject{}).
+		Where("bucket = ? AND object_key = ?", s.BucketClient.BucketFor(key), key).
+		Update("ref_count", gorm.Expr("ref_count + 1"))
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		return key, nil
+	}
+
+	if _, err := s.BucketClient.CopyObject(key, fallbackKey); err != nil {
+		return "", err
+	}
+
+	return fallbackKey, nil
+}
+
+// Release drops a reference to each of the given objects, a key given twice drops two. An object
+// whose last reference is gone is recorded as a ReleasedObject, in the same transaction as the caller.
+// The references are dropped in a few queries, however many keys are given.
+//
+// The objects are removed from MinIO right away when the store isn't used inside a transaction.
+// Otherwise the caller runs RemoveReleased once its transaction is committed.
+func (s *ObjectStore) Release(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	type objectRef struct {
+		bucket, key string
+	}
+
+	// References dropped from each object, objects are kept in the order of their keys
+	drops := map[objectRef]uint{}
+	refs := []objectRef{}
+	locations := [][]interface{}{}
+	for _, key := range keys {
+		ref := objectRef{bucket: s.BucketClient.BucketFor(key), key: key}
+		if drops[ref] == 0 {
+			refs = append(refs, ref)
+			locations = append(locations, []interface{}{ref.bucket, ref.key})
+		}
+		drops[ref]++
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var storedObjects []models.StoredObject
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("(bucket, object_key) IN ?", locations).
+			Find(&storedObjects).Error
+		if err != nil {
+			return err
+		}
+
+		stored := map[objectRef]*models.StoredObject{}
+		for i := range storedObjects {
+			stored[objectRef{bucket: storedObjects[i].Bucket, key: storedObjects[i].ObjectKey}] = &storedObjects[i]
+		}
+
+		// Objects still referenced afterwards are updated together when they lose as many references
+		decrements := map[uint][]uint{}
+		emptied := []uint{}
+		released := []models.ReleasedObject{}
+		for _, ref := range refs {
+			storedObject, ok := stored[ref]
+			if ok && storedObject.RefCount > drops[ref] {
+				decrements[drops[ref]] = append(decrements[drops[ref]], storedObject.ID)
+				continue
+			}
+
+			// Objects stored before deduplication belong to a single file
+			if ok && storedObject.RefCount > 0 {
+				emptied = append(emptied, storedObject.ID)
+			}
+			released = append(released, models.ReleasedObject{Bucket: ref.bucket, ObjectKey: ref.key})
+		}
+
+		for drop, ids := range decrements {
+			if err := tx.Model(&models.StoredObject{}).Where("id IN ?", ids).Update("ref_count", gorm.Expr("ref_count - ?", drop)).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(emptied) > 0 {
+			if err := tx.Model(&models.StoredObject{}).Where("id IN ?", emptied).Update("ref_count", 0).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(released) > 0 {
+			return tx.Create(&released).Error
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.RemoveReleased()
+}
+
+// RemoveReleased removes the released objects of the buckets of the store from MinIO. It does
+// nothing inside a transaction, the objects may still be referenced if it rolls back.
+func (s *ObjectStore) RemoveReleased() error {
+	if _, ok := s.DB.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return nil
+	}
+
+	buckets := []string{s.BucketClient.Bucket}
+	if s.BucketClient.SharedBucket != "" {
+		buckets = append(buckets, s.BucketClient.SharedBucket)
+	}
+
+	return removeReleasedObjects(s.DB, s.BucketClient.Client, buckets)
+}
+
+// RemoveReleasedObjects removes every released object from MinIO. It is scheduled to remove
+// the objects left behind when a removal failed after its transaction was committed.
+func RemoveReleasedObjects(db *gorm.DB, client *minio.Client) {
+	if err := removeReleasedObjects(db, client, nil); err != nil {
+		log.Printf("Error while removing released objects: %v\n", err)
+	}
+}
+
+// removeReleasedObjects removes the released objects of the given buckets, or of every bucket
+// when buckets is nil. Objects referenced again since they were released are kept.
+func removeReleasedObjects(db *gorm.DB, client *minio.Client, buckets []string) error {
+	query := db.Order("id")
+	if buckets != nil {
+		query = query.Where("bucket IN ?", buckets)
+	}
+
+	var releasedObjects []models.ReleasedObject
+	if err := query.Find(&releasedObjects).Error; err != nil {
+		return err
+	}
+
+	for i := range releasedObjects {
+		releasedObject := &releasedObjects[i]
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var storedObject models.StoredObject
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("bucket = ? AND object_key = ?", releasedObject.Bucket, releasedObject.ObjectKey).
+				First(&storedObject).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			if err := tx.Delete(releasedObject).Error; err != nil {
+				return err
+			}
+
+			if err == nil {
+				if storedObject.RefCount > 0 {
+					return nil
+				}
+
+				if err := tx.Delete(&storedObject).Error; err != nil {
+					return err
+				}
+			}
+
+			// Removed while the row is still locked, so a concurrent Store of the same
+			// content waits and uploads it again instead of referencing a removed object
+			return client.RemoveObject(context.Background(), releasedObject.Bucket, releasedObject.ObjectKey, minio.RemoveObjectOptions{})
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
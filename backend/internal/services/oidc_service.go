@@ -0,0 +1,537 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	OIDC_STATE_TTL       = 10 * time.Minute
+	OIDC_HTTP_TIMEOUT    = 10 * time.Second
+	OIDC_NAME_MAX_LENGTH = 50
+	DEFAULT_OIDC_SCOPES  = "openid profile email"
+)
+
+var oidcEnvNameRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// OIDCProviderConfig is an OpenID Connect provider users can log in with.
+type OIDCProviderConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	TrustEmail   bool // Provision new users with the provider's emails even without the email_verified claim
+}
+
+// OIDCProviders returns the OpenID Connect providers, named in OIDC_PROVIDERS (comma separated).
+// Each provider is configured by OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and optionally
+// _DISPLAY_NAME, _SCOPES, _REDIRECT_URL and _TRUST_EMAIL. Providers without an issuer or client ID
+// are left out.
+func OIDCProviders() map[string]OIDCProviderConfig {
+	providers := map[string]OIDCProviderConfig{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + oidcEnvNameRegex.ReplaceAllString(strings.ToUpper(name), "_") + "_"
+		config := OIDCProviderConfig{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+			TrustEmail:   os.Getenv(prefix+"TRUST_EMAIL") == "true",
+		}
+
+		if config.Issuer == "" || config.ClientID == "" {
+			continue
+		}
+
+		if config.DisplayName == "" {
+			config.DisplayName = name
+		}
+		if config.RedirectURL == "" {
+			config.RedirectURL = AppURL() + "/api/auth/oidc/" + name + "/callback"
+		}
+		if len(config.Scopes) == 0 {
+			config.Scopes = strings.Fields(DEFAULT_OIDC_SCOPES)
+		}
+
+		providers[name] = config
+	}
+
+	return providers
+}
+
+// oidcState is what the login remembers between the redirect to the provider and the callback.
+// It travels in a signed cookie, so the backend keeps no state.
+type oidcState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcClaims are the claims of an ID token a user is found or provisioned by.
+type oidcClaims struct {
+	Subject       string      `json:"sub"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // Some providers send "true" as a string
+	Name          string      `json:"name"`
+	GivenName     string      `json:"given_name"`
+	FamilyName    string      `json:"family_name"`
+}
+
+func (claims *oidcClaims) emailVerified() bool {
+	switch verified := claims.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// names returns the first and last name of the user, split from the full name when the
+// provider doesn't send them apart.
+func (claims *oidcClaims) names() (string, string) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	return truncateName(firstName), truncateName(strings.TrimSpace(lastName))
+}
+
+func truncateName(name string) string {
+	if len(name) > OIDC_NAME_MAX_LENGTH {
+		return name[:OIDC_NAME_MAX_LENGTH]
+	}
+	return name
+}
+
+func randomOIDCValue() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+type OIDCService struct {
+	DB          *gorm.DB
+	UserService *UserService
+
+	mu        sync.Mutex
+	discovery map[string]*oidc.Provider
+}
+
+func NewOIDCService(db *gorm.DB, userService *UserService) *OIDCService {
+	return &OIDCService{
+		DB:          db,
+		UserService: userService,
+		discovery:   map[string]*oidc.Provider{},
+	}
+}
+
+// oidcContext carries the HTTP client the calls to the providers are made with.
+func oidcContext() context.Context {
+	return oidc.ClientContext(context.Background(), &http.Client{Timeout: OIDC_HTTP_TIMEOUT})
+}
+
+// provider returns the configuration of a provider with its discovery document. The discovery is
+// fetched on first use and kept, so a provider being down at startup doesn't matter.
+func (oidcS *OIDCService) provider(name string) (*OIDCProviderConfig, *oidc.Provider, error) {
+	config, ok := OIDCProviders()[name]
+	if !ok {
+		return nil, nil, &apperr.NotFoundError{
+			BaseError: &apperr.BaseError{
+				Message: "Unknown identity provider",
+			},
+		}
+	}
+
+	oidcS.mu.Lock()
+	defer oidcS.mu.Unlock()
+
+	if discovered, ok := oidcS.discovery[name]; ok {
+		return &config, discovered, nil
+	}
+
+	discovered, err := oidc.NewProvider(oidcContext(), config.Issuer)
+	if err != nil {
+		return nil, nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Identity provider unavailable",
+				Err:     err,
+			},
+		}
+	}
+
+	oidcS.discovery[name] = discovered
+	return &config, discovered, nil
+}
+
+func oauth2Config(config *OIDCProviderConfig, discovered *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.RedirectURL,
+		Endpoint:     discovered.Endpoint(),
+		Scopes:       config.Scopes,
+	}
+}
+
+// ListProviders lists the providers users can log in with, sorted by name.
+func (oidcS *OIDCService) ListProviders() []models.OIDCProvider {
+	providers := []models.OIDCProvider{}
+	for _, config := range OIDCProviders() {
+		providers = append(providers, models.OIDCProvider{Name: config.Name, DisplayName: config.DisplayName})
+	}
+
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name < providers[j].Name
+	})
+
+	return providers
+}
+
+// BeginLogin starts a login with a provider, by the authorization code flow with PKCE. It returns
+// the URL to redirect the browser to, and the state token to keep in a cookie until the callback.
+// When linkUserID is set, the identity is linked to that user instead of logging in.
+//
+// If the provider is unknown, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (oidcS *OIDCService) BeginLogin(providerName string, linkUserID uint) (string, string, error) {
+	config, discovered, err := oidcS.provider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := oidcState{Provider: providerName, Verifier: oauth2.GenerateVerifier()}
+	if state.State, err = randomOIDCValue(); err == nil {
+		state.Nonce, err = randomOIDCValue()
+	}
+	if err != nil {
+		return "", "", oidcError(err, "Failed to start login")
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", "", oidcError(err, "Failed to start login")
+	}
+
+	stateToken, err := utils.GeneratePurposeToken(utils.TOKEN_PURPOSE_OIDC, linkUserID, string(data), OIDC_STATE_TTL)
+	if err != nil {
+		return "", "", oidcError(err, "Failed to start login")
+	}
+
+	authURL := oauth2Config(config, discovered).AuthCodeURL(state.State,
+		oidc.Nonce(state.Nonce),
+		oauth2.S256ChallengeOption(state.Verifier),
+	)
+
+	return authURL, stateToken, nil
+}
+
+// FinishLogin handles the callback of a provider: the code is exchanged for an ID token, which is
+// verified. It returns the user the identity belongs to, and whether the identity was only linked
+// to a logged in user.
+//
+// A new identity is linked to the account with the same email when the provider verified the email,
+// and so did the account. Otherwise a new account is provisioned.
+//
+// If the state or the code is invalid, it returns an InvalidCredentialsError.
+// If the provider is unknown, it returns a NotFoundError.
+// If the email isn't verified or the account is deleted, it returns a ForbiddenError.
+// If the identity or the email belongs to another account, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (oidcS *OIDCService) FinishLogin(providerName, stateToken, state, code string) (*models.User, bool, error) {
+	invalidState := &apperr.InvalidCredentialsError{
+		BaseError: &apperr.BaseError{
+			Message: "Invalid login state",
+		},
+	}
+
+	stateClaims, err := utils.ParsePurposeToken(stateToken, utils.TOKEN_PURPOSE_OIDC)
+	if err != nil {
+		return nil, false, invalidState
+	}
+
+	var saved oidcState
+	if err := json.Unmarshal([]byte(stateClaims.Value), &saved); err != nil || saved.Provider != providerName || saved.State != state {
+		return nil, false, invalidState
+	}
+
+	config, discovered, err := oidcS.provider(providerName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx := oidcContext()
+	token, err := oauth2Config(config, discovered).Exchange(ctx, code, oauth2.VerifierOption(saved.Verifier))
+	if err != nil {
+		return nil, false, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid authorization code",
+				Err:     err,
+			},
+		}
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, false, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "No ID token received",
+			},
+		}
+	}
+
+	idToken, err := discovered.Verifier(&oidc.Config{ClientID: config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != saved.Nonce {
+		return nil, false, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid ID token",
+				Err:     err,
+			},
+		}
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, false, oidcError(err, "Failed to log in")
+	}
+	claims.Subject = idToken.Subject
+
+	if stateClaims.UserID != 0 {
+		user, err := oidcS.linkIdentity(stateClaims.UserID, providerName, &claims)
+		return user, true, err
+	}
+
+	user, err := oidcS.resolveIdentity(config, &claims)
+	return user, false, err
+}
+
+// linkIdentity links an identity to a logged in user.
+func (oidcS *OIDCService) linkIdentity(userID uint, providerName string, claims *oidcClaims) (*models.User, error) {
+	var identity models.UserIdentity
+	err := oidcS.DB.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+	if err == nil && identity.UserID != userID {
+		return nil, &apperr.ConflictError{
+			BaseError: &apperr.BaseError{
+				Message: "This identity is linked to another account",
+			},
+		}
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, oidcError(err, "Failed to link identity")
+	}
+
+	var user models.User
+	if err := oidcS.DB.First(&user, userID).Error; err != nil {
+		return nil, oidcError(err, "Failed to link identity")
+	}
+
+	if err := oidcS.saveIdentity(user.ID, providerName, claims); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// resolveIdentity finds the user an identity belongs to, links it by email or provisions a new user.
+// Existing accounts are only linked by an email the provider says it verified.
+func (oidcS *OIDCService) resolveIdentity(config *OIDCProviderConfig, claims *oidcClaims) (*models.User, error) {
+	var identity models.UserIdentity
+	err := oidcS.DB.Preload("User").Where("provider = ? AND subject = ?", config.Name, claims.Subject).First(&identity).Error
+	if err == nil {
+		if identity.User == nil {
+			return nil, &apperr.ForbiddenError{
+				BaseError: &apperr.BaseError{
+					Message: "Account deleted",
+				},
+			}
+		}
+
+		if err := oidcS.saveIdentity(identity.UserID, config.Name, claims); err != nil {
+			return nil, err
+		}
+		return identity.User, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, oidcError(err, "Failed to log in")
+	}
+
+	// An email only identifies someone once its owner proved it
+	verified := claims.emailVerified()
+	if claims.Email == "" || (!verified && !config.TrustEmail) {
+		return nil, &apperr.ForbiddenError{
+			BaseError: &apperr.BaseError{
+				Message: "The identity provider has no verified email for this account",
+			},
+		}
+	}
+
+	var user models.User
+	err = oidcS.DB.Unscoped().Where("email = ?", claims.Email).First(&user).Error
+	if err == nil {
+		if user.DeletedAt.Valid {
+			return nil, &apperr.ForbiddenError{
+				BaseError: &apperr.BaseError{
+					Message: "Account deleted",
+				},
+			}
+		}
+
+		// Otherwise whoever registered the email first would get the provider's user's account
+		if user.EmailVerifiedAt == nil {
+			return nil, &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "An account with this email exists, verify its email to link it",
+				},
+			}
+		}
+
+		// A trusted email is enough for a new account, but only the provider's word that the email
+		// was verified hands it an existing one
+		if !verified {
+			return nil, &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "An account with this email exists, log in and link the provider from your account",
+				},
+			}
+		}
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		now := time.Now()
+		firstName, lastName := claims.names()
+		user = models.User{
+			FirstName:       firstName,
+			LastName:        lastName,
+			Email:           claims.Email,
+			EmailVerifiedAt: &now,
+		}
+
+		// A trusted email doesn't make an administrator, only one the provider verified
+		if verified && isAdminEmail(user.Email) {
+			user.Role = models.ROLE_ADMIN
+		}
+
+		if err := oidcS.UserService.provisionUser(&user); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, oidcError(err, "Failed to log in")
+	}
+
+	if err := oidcS.saveIdentity(user.ID, config.Name, claims); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// saveIdentity creates or updates the identity of a user at a provider, when it was last used.
+func (oidcS *OIDCService) saveIdentity(userID uint, providerName string, claims *oidcClaims) error {
+	now := time.Now()
+	identity := models.UserIdentity{
+		UserID:      userID,
+		Provider:    providerName,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	}
+
+	err := oidcS.DB.Where("provider = ? AND subject = ?", providerName, claims.Subject).
+		Assign(models.UserIdentity{Email: claims.Email, LastLoginAt: &now}).
+		FirstOrCreate(&identity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "This identity is linked to another account",
+					Err:     err,
+				},
+			}
+		}
+
+		return oidcError(err, "Failed to save identity")
+	}
+
+	return nil
+}
+
+// ListIdentities lists the identities linked to a user.
+//
+// If any errors occur, it returns a ServerError.
+func (oidcS *OIDCService) ListIdentities(userID uint) ([]models.UserIdentity, error) {
+	identities := []models.UserIdentity{}
+	if err := oidcS.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		return nil, oidcError(err, "Failed to list identities")
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity unlinks an identity from a user, they can't log in with it anymore.
+//
+// If the identity is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (oidcS *OIDCService) UnlinkIdentity(userID, identityID uint) error {
+	result := oidcS.DB.Where("id = ? AND user_id = ?", identityID, userID).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		return oidcError(result.Error, "Failed to unlink identity")
+	}
+
+	if result.RowsAffected == 0 {
+		return &apperr.NotFoundError{
+			BaseError: &apperr.BaseError{
+				Message: "Identity not found",
+			},
+		}
+	}
+
+	return nil
+}
+
+// oidcError passes the errors of the service through and turns the others into a ServerError.
+func oidcError(err error, message string) error {
+	switch err.(type) {
+	case *apperr.NotFoundError, *apperr.ConflictError, *apperr.InvalidCredentialsError, *apperr.ForbiddenError, *apperr.ServerError:
+		return err
+	}
+
+	return &apperr.ServerError{
+		BaseError: &apperr.BaseError{
+			Message: message,
+			Err:     err,
+		},
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 100
+	cursorTimeLayout  = "2006-01-02 15:04:05.000000"
+)
+
+var fileSortColumns = map[string]string{
+	"name":     "files.file_name",
+	"size":     "files.file_size",
+	"created":  "files.created_at",
+	"modified": "files.updated_at",
+	"type":     "files.file_type",
+}
+
+// Folders have no size or type of their own, those keys fall back to the name.
+var folderSortColumns = map[string]string{
+	"name":     "folders.name",
+	"size":     "folders.name",
+	"created":  "folders.created_at",
+	"modified": "folders.updated_at",
+	"type":     "folders.name",
+}
+
+// pageCursor points right after the last item of a page. It remembers the
+// sort it was made for, so it can't be replayed against a different ordering.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(encoded string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
+
+func withListDefaults(params models.ListParams) models.ListParams {
+	if params.Limit <= 0 {
+		params.Limit = DEFAULT_PAGE_SIZE
+	}
+
+	if params.Sort == "" {
+		params.Sort = "created"
+	}
+
+	if params.Order == "" {
+		params.Order = "asc"
+	}
+
+	return params
+}
+
+func fileSortValue(file *models.File, sort string) string {
+	switch sort {
+	case "name":
+		return file.FileName
+	case "size":
+		return strconv.FormatUint(uint64(file.FileSize), 10)
+	case "modified":
+		return file.UpdatedAt.Format(cursorTimeLayout)
+	case "type":
+		return file.FileType
+	}
+	return file.CreatedAt.Format(cursorTimeLayout)
+}
+
+func folderSortValue(folder *models.Folder, sort string) string {
+	switch sort {
+	case "created":
+		return folder.CreatedAt.Format(cursorTimeLayout)
+	case "modified":
+		return folder.UpdatedAt.Format(cursorTimeLayout)
+	}
+	return folder.Name
+}
+
+// paginate runs a keyset-paginated query. The sort column is always paired with
+// the primary key, so rows sharing the same value are neither skipped nor repeated.
+//
+// It returns the page, the total number of rows matching the query regardless of
+// the cursor, and the cursor of the next page (empty on the last page).
+//
+// If the cursor is malformed or was made for another ordering, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func paginate[T any](query *gorm.DB, params models.ListParams, sortColumns map[string]string, idColumn string, sortValue func(T, string) string, idOf func(T) uint) ([]T, int64, string, error) {
+	params = withListDefaults(params)
+	column := sortColumns[params.Sort]
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, "", &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to count items",
+				Err:     err,
+			},
+		}
+	}
+
+	if params.Cursor != "" {
+		cursor, err := decodeCursor(params.Cursor)
+		if err != nil || cursor.Sort != params.Sort || cursor.Order != params.Order {
+			return nil, 0, "", &apperr.InvalidParamError{
+				BaseError: &apperr.BaseError{
+					Message: "Invalid cursor",
+					Err:     err,
+				},
+			}
+		}
+
+		comparison := ">"
+		if params.Order == "desc" {
+			comparison = "<"
+		}
+
+		query = query.Where(
+			fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", column, comparison, column, idColumn, comparison),
+			cursor.Value, cursor.Value, cursor.ID,
+		)
+	}
+
+	items := []T{}
+	err := query.
+		Order(fmt.Sprintf("%s %s, %s %s", column, params.Order, idColumn, params.Order)).
+		Limit(params.Limit + 1).
+		Find(&items).Error
+	if err != nil {
+		return nil, 0, "", &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list items",
+				Err:     err,
+			},
+		}
+	}
+
+	nextCursor := ""
+	if len(items) > params.Limit {
+		items = items[:params.Limit]
+		last := items[len(items)-1]
+		nextCursor = encodeCursor(pageCursor{
+			Sort:  params.Sort,
+			Order: params.Order,
+			Value: sortValue(last, params.Sort),
+			ID:    idOf(last),
+		})
+	}
+
+	return items, total, nextCursor, nil
+}
+
+// paginateFiles paginates a files query, applying the media type filter of the params.
+func paginateFiles(query *gorm.DB, params models.ListParams) (*models.FileListResponse, error) {
+	query = applyMediaTypeFilter(query, params.Type)
+
+	files, total, nextCursor, err := paginate(query, params, fileSortColumns, "files.id", fileSortValue, func(f *models.File) uint { return f.ID })
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.FileListResponse{
+		Files:      files,
+		Total:      total,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// paginateFolders paginates a folders query. Media type filters don't apply to folders.
+func paginateFolders(query *gorm.DB, params models.ListParams) ([]*models.Folder, int64, string, error) {
+	return paginate(query, params, folderSortColumns, "folders.id", folderSortValue, func(f *models.Folder) uint { return f.ID })
+}
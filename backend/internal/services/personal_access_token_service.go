@@ -0,0 +1,206 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"gorm.io/gorm"
+)
+
+const (
+	PERSONAL_ACCESS_TOKEN_PREFIX        = "cc_pat_"
+	PERSONAL_ACCESS_TOKEN_DISPLAY_CHARS = 12
+	PERSONAL_ACCESS_TOKEN_LIMIT         = 50
+	TOKEN_LAST_USED_INTERVAL            = time.Minute
+)
+
+// IsPersonalAccessToken tells whether a token from a request is a personal access token rather than a JWT.
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PERSONAL_ACCESS_TOKEN_PREFIX)
+}
+
+// hashPersonalAccessToken returns the hash a personal access token is stored and looked up by.
+func hashPersonalAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type PersonalAccessTokenService struct {
+	DB *gorm.DB
+}
+
+func NewPersonalAccessTokenService(db *gorm.DB) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{
+		DB: db,
+	}
+}
+
+// CreateToken creates a personal access token for a user. The token is only returned here,
+// afterwards only its prefix is known.
+//
+// If the user has too many tokens, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (pats *PersonalAccessTokenService) CreateToken(userID uint, body models.PersonalAccessTokenBody) (*models.PersonalAccessTokenCreated, error) {
+	var count int64
+	if err := pats.DB.Model(&models.PersonalAccessToken{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to create token",
+				Err:     err,
+			},
+		}
+	}
+
+	if count >= PERSONAL_ACCESS_TOKEN_LIMIT {
+		return nil, &apperr.ConflictError{
+			BaseError: &apperr.BaseError{
+				Message: "Too many access tokens, revoke unused ones first",
+			},
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to create token",
+				Err:     err,
+			},
+		}
+	}
+
+	token := PERSONAL_ACCESS_TOKEN_PREFIX + base64.RawURLEncoding.EncodeToString(raw)
+	accessToken := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      body.Name,
+		Prefix:    token[:PERSONAL_ACCESS_TOKEN_DISPLAY_CHARS],
+		TokenHash: hashPersonalAccessToken(token),
+		Scope:     body.Scope,
+	}
+
+	if body.ExpiresInDays > 0 {
+		expiresAt := time.Now().Add(time.Duration(body.ExpiresInDays) * 24 * time.Hour)
+		accessToken.ExpiresAt = &expiresAt
+	}
+
+	if err := pats.DB.Create(&accessToken).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to create token",
+				Err:     err,
+			},
+		}
+	}
+
+	return &models.PersonalAccessTokenCreated{
+		PersonalAccessToken: accessToken,
+		Token:               token,
+	}, nil
+}
+
+// ListTokens lists the personal access tokens of a user, the newest first. Expired tokens are listed too.
+//
+// If any errors occur, it returns a ServerError.
+func (pats *PersonalAccessTokenService) ListTokens(userID uint) ([]models.PersonalAccessToken, error) {
+	tokens := []models.PersonalAccessToken{}
+	if err := pats.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list tokens",
+				Err:     err,
+			},
+		}
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken deletes a personal access token of a user, it stops working right away.
+//
+// If the token is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (pats *PersonalAccessTokenService) RevokeToken(userID, tokenID uint) error {
+	result := pats.DB.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to revoke token",
+				Err:     result.Error,
+			},
+		}
+	}
+
+	if result.RowsAffected == 0 {
+		return &apperr.NotFoundError{
+			BaseError: &apperr.BaseError{
+				Message: "Token not found",
+			},
+		}
+	}
+
+	return nil
+}
+
+// RevokeAllTokens deletes every personal access token of a user and returns how many were revoked,
+// e.g. when the password changes.
+//
+// If any errors occur, it returns a ServerError.
+func (pats *PersonalAccessTokenService) RevokeAllTokens(userID uint) (int64, error) {
+	result := pats.DB.Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return 0, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to revoke tokens",
+				Err:     result.Error,
+			},
+		}
+	}
+
+	return result.RowsAffected, nil
+}
+
+// Authenticate returns the personal access token a request is made with, along with its user.
+// When it was used is written at most once every TOKEN_LAST_USED_INTERVAL.
+//
+// If the token is unknown or expired, or its user is deleted, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (pats *PersonalAccessTokenService) Authenticate(token, ip string) (*models.PersonalAccessToken, error) {
+	invalidErr := &apperr.InvalidCredentialsError{
+		BaseError: &apperr.BaseError{
+			Message: "Invalid access token",
+		},
+	}
+
+	var accessToken models.PersonalAccessToken
+	err := pats.DB.Preload("User").Where("token_hash = ?", hashPersonalAccessToken(token)).First(&accessToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalidErr
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	now := time.Now()
+	if accessToken.User == nil || (accessToken.ExpiresAt != nil && now.After(*accessToken.ExpiresAt)) {
+		return nil, invalidErr
+	}
+
+	if accessToken.LastUsedAt == nil || now.Sub(*accessToken.LastUsedAt) > TOKEN_LAST_USED_INTERVAL {
+		pats.DB.Model(&accessToken).UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": ip})
+	}
+
+	return &accessToken, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+)
+
+func TestHashPersonalAccessToken(t *testing.T) {
+	token := PERSONAL_ACCESS_TOKEN_PREFIX + "abcdef"
+
+	hash := hashPersonalAccessToken(token)
+	if len(hash) != 64 {
+		t.Errorf("hashPersonalAccessToken() = %q, want 64 hex characters", hash)
+	}
+
+	if hash != hashPersonalAccessToken(token) {
+		t.Error("hashPersonalAccessToken() isn't stable")
+	}
+
+	if hash == hashPersonalAccessToken(token+"g") || strings.Contains(hash, "abcdef") {
+		t.Error("hashPersonalAccessToken() doesn't hide the token")
+	}
+}
+
+func TestIsPersonalAccessToken(t *testing.T) {
+	if !IsPersonalAccessToken(PERSONAL_ACCESS_TOKEN_PREFIX + "abcdef") {
+		t.Error("IsPersonalAccessToken() refused a personal access token")
+	}
+
+	if IsPersonalAccessToken("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Error("IsPersonalAccessToken() accepted a JWT")
+	}
+}
+
+func TestPersonalAccessTokenLifecycle(t *testing.T) {
+	db := testDB(t)
+
+	user := models.User{Email: fmt.Sprintf("pat-%d@example.org", time.Now().UnixNano())}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	service := NewPersonalAccessTokenService(db)
+	created, err := service.CreateToken(user.ID, models.PersonalAccessTokenBody{Name: "backup", Scope: models.TOKEN_SCOPE_READ})
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+
+	// Only the hash is stored
+	var stored models.PersonalAccessToken
+	if err := db.First(&stored, created.ID).Error; err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+	if stored.TokenHash != hashPersonalAccessToken(created.Token) || !strings.HasPrefix(created.Token, stored.Prefix) {
+		t.Errorf("stored token = %+v, want the hash and prefix of %q", stored, created.Token)
+	}
+
+	accessToken, err := service.Authenticate(created.Token, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if accessToken.User == nil || accessToken.User.ID != user.ID || accessToken.Scope != models.TOKEN_SCOPE_READ {
+		t.Errorf("Authenticate() = %+v, want the read token of user %d", accessToken, user.ID)
+	}
+
+	if _, err := service.Authenticate(created.Token+"x", "127.0.0.1"); !isInvalidCredentials(err) {
+		t.Errorf("Authenticate() of an unknown token error = %v, want an InvalidCredentialsError", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	if err := db.Model(&stored).Update("expires_at", expired).Error; err != nil {
+		t.Fatalf("failed to expire token: %v", err)
+	}
+	if _, err := service.Authenticate(created.Token, "127.0.0.1"); !isInvalidCredentials(err) {
+		t.Errorf("Authenticate() of an expired token error = %v, want an InvalidCredentialsError", err)
+	}
+
+	revoked, err := service.RevokeAllTokens(user.ID)
+	if err != nil || revoked != 1 {
+		t.Errorf("RevokeAllTokens() = %d, %v, want 1, nil", revoked, err)
+	}
+}
+
+func isInvalidCredentials(err error) bool {
+	_, ok := err.(*apperr.InvalidCredentialsError)
+	return ok
+}
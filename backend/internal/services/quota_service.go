@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"gorm.io/gorm"
+)
+
+type QuotaService struct {
+	DB *gorm.DB
+}
+
+func NewQuotaService(db *gorm.DB) *QuotaService {
+	return &QuotaService{
+		DB: db,
+	}
+}
+
+// DefaultQuota returns the quota applied to users without an override, read from
+// DEFAULT_USER_QUOTA (in bytes). A missing, invalid or zero value means no quota.
+func DefaultQuota() int64 {
+	quota, err := strconv.ParseInt(os.Getenv("DEFAULT_USER_QUOTA"), 10, 64)
+	if err != nil || quota < 0 {
+		return 0
+	}
+	return quota
+}
+
+// effectiveQuota returns the quota of a user, or nil if the user has none.
+// An override of 0 lifts the quota for that user.
+func effectiveQuota(user *models.User) *int64 {
+	quota := DefaultQuota()
+	if user.QuotaBytes != nil {
+		quota = *user.QuotaBytes
+	}
+
+	if quota <= 0 {
+		return nil
+	}
+	return &quota
+}
+
+func (qs *QuotaService) findUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := qs.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "User not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch user",
+				Err:     err,
+			},
+		}
+	}
+
+	return &user, nil
+}
+
+func (qs *QuotaService) sumColumn(query *gorm.DB, column string) (int64, error) {
+	var sum int64
+	err := query.Select(fmt.Sprintf("COALESCE(SUM(%s), 0)", column)).Scan(&sum).Error
+	return sum, err
+}
+
+// usage adds up everything stored for a user. Trashed files still take space, so they are counted too.
+func (qs *QuotaService) usage(userID uint) (*models.StorageUsage, error) {
+	var usage models.StorageUsage
+	var err error
+
+	files := qs.DB.Unscoped().Model(&models.File{}).Where("files.user_id = ?", userID)
+
+	if usage.Originals, err = qs.sumColumn(files.Session(&gorm.Session{}), "files.file_size"); err != nil {
+		return nil, err
+	}
+
+	if usage.HLS, err = qs.sumColumn(files.Session(&gorm.Session{}), "files.hls_size"); err != nil {
+		return nil, err
+	}
+
+	versions := qs.DB.Model(&models.FileVersion{}).Where("file_versions.user_id = ?", userID)
+	if usage.Versions, err = qs.sumColumn(versions, "file_versions.file_size"); err != nil {
+		return nil, err
+	}
+
+	thumbnails := qs.DB.Model(&models.Thumbnail{}).
+		Joins("JOIN files ON files.id = thumbnails.file_id").
+		Where("files.user_id = ?", userID)
+	if usage.Thumbnails, err = qs.sumColumn(thumbnails, "thumbnails.file_size"); err != nil {
+		return nil, err
+	}
+
+	usage.Total = usage.Originals + usage.Versions + usage.Thumbnails + usage.HLS
+	return &usage, nil
+}
+
+// GetUsage returns the storage used by a user, broken down by category, along with their quota.
+//
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (qs *QuotaService) GetUsage(userID uint) (*models.StorageUsage, error) {
+	user, err := qs.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := qs.usage(userID)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to compute storage usage",
+				Err:     err,
+			},
+		}
+	}
+
+	if quota := effectiveQuota(user); quota != nil {
+		remaining := max(*quota-usage.Total, 0)
+		usage.Quota = quota
+		usage.Remaining = &remaining
+	}
+
+	return usage, nil
+}
+
+// CheckQuota makes sure a user can store incomingBytes more without going over their quota.
+//
+// If the user would go over quota, it returns a QuotaExceededError.
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (qs *QuotaService) CheckQuota(userID uint, incomingBytes int64) error {
+	usage, err := qs.GetUsage(userID)
+	if err != nil {
+		return err
+	}
+
+	if usage.Remaining != nil && incomingBytes > *usage.Remaining {
+		return &apperr.QuotaExceededError{
+			BaseError: &apperr.BaseError{
+				Message: fmt.Sprintf("Storage quota exceeded: %d bytes needed, %d bytes remaining", incomingBytes, *usage.Remaining),
+			},
+		}
+	}
+
+	return nil
+}
+
+// SetUserQuota overrides the quota of a user. A nil quota reverts the user to the default quota.
+//
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (qs *QuotaService) SetUserQuota(userID uint, quotaBytes *int64) (*models.User, error) {
+	user, err := qs.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := qs.DB.Model(user).Update("quota_bytes", quotaBytes).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to update user quota",
+				Err:     err,
+			},
+		}
+	}
+
+	user.QuotaBytes = quotaBytes
+	return user, nil
+}
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/gofrs/uuid/v5"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_RECONCILE_CRON = "0 4 * * 0"
+	DEFAULT_TEMP_DIR       = "/tmp"
+	// Objects younger than this may belong to an upload whose row isn't saved yet
+	RECONCILE_GRACE_PERIOD = time.Hour
+	// Temporary files younger than this may belong to a thumbnail or HLS job still running
+	STALE_TEMP_AGE = 24 * time.Hour
+)
+
+// ReconcileCronSpec returns when the reconciliation job runs, read from RECONCILE_CRON.
+// It runs every Sunday night by default.
+func ReconcileCronSpec() string {
+	if spec := os.Getenv("RECONCILE_CRON"); spec != "" {
+		return spec
+	}
+	return DEFAULT_RECONCILE_CRON
+}
+
+// ReconcileRepairEnabled reports whether the reconciliation job repairs what it finds, read from
+// RECONCILE_REPAIR. By default it only reports.
+func ReconcileRepairEnabled() bool {
+	return os.Getenv("RECONCILE_REPAIR") == "true"
+}
+
+// ReconcileService compares the objects stored in MinIO with the rows referencing them in MariaDB,
+// and the temporary directory with the jobs using it. It finds orphan objects, rows whose objects
+// are missing, wrong reference counts and stale temporary files, and can repair them.
+// It works across all users, so it uses the MinIO client directly instead of a BucketClient.
+type ReconcileService struct {
+	DB          *gorm.DB
+	MinioClient *minio.Client
+	TempDir     string
+}
+
+func NewReconcileService(db *gorm.DB, mc *minio.Client) *ReconcileService {
+	return &ReconcileService{
+		DB:          db,
+		MinioClient: mc,
+		TempDir:     DEFAULT_TEMP_DIR,
+	}
+}
+
+// reconcileRun holds the state of a single reconciliation.
+type reconcileRun struct {
+	report *models.ReconcileReport
+	// Objects modified after cutoff are never reported as orphans
+	cutoff        time.Time
+	sharedBucket  string
+	sharedObjects map[string]minio.ObjectInfo
+	// References to the shared bucket, counted across all users
+	sharedRefs map[string]uint
+}
+
+// record adds an issue to the report, and applies fix to it when repairing.
+func (run *reconcileRun) record(issues *[]models.ReconcileIssue, issue models.ReconcileIssue, fix func() error) {
+	if run.report.Repair && fix != nil {
+		if err := fix(); err != nil {
+			issue.Error = err.Error()
+		} else {
+			issue.Repaired = true
+		}
+	}
+
+	*issues = append(*issues, issue)
+}
+
+// Reconcile checks the buckets of every user, the shared bucket and the temporary directory.
+// When repair is set:
+//   - orphan objects and stale temporary files are removed,
+//   - files, versions and stored objects whose object is missing are flagged as corrupted,
+//   - thumbnails whose object is missing are deleted, and videos whose HLS playlist is missing
+//     are marked as not previewable, so they can be generated again,
+//   - reference counts are corrected, and objects without references are removed.
+//
+// Errors while checking a bucket are logged and the bucket is skipped, so they don't hide the
+// issues found elsewhere. It only returns an error if the users or the shared bucket can't be read.
+func (rs *ReconcileService) Reconcile(repair bool) (*models.ReconcileReport, error) {
+	now := time.Now()
+	run := &reconcileRun{
+		report: &models.ReconcileReport{
+			Repair:             repair,
+			StartedAt:          now,
+			OrphanObjects:      []models.ReconcileIssue{},
+			MissingObjects:     []models.ReconcileIssue{},
+			RefCountMismatches: []models.ReconcileIssue{},
+			StaleTempPaths:     []models.ReconcileIssue{},
+		},
+		cutoff:       now.Add(-RECONCILE_GRACE_PERIOD),
+		sharedBucket: SharedBucketName(),
+		sharedRefs:   map[string]uint{},
+	}
+
+	// Rows are read before the objects they reference are listed. Objects are always stored before
+	// their rows are saved, so a row can't be reported missing because of an upload in progress.
+	var users []models.User
+	if err := rs.DB.Unscoped().Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	var sharedObjectRows []models.StoredObject
+	if err := rs.DB.Where("bucket = ?", run.sharedBucket).Find(&sharedObjectRows).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		if err := rs.reconcileUser(run, &users[i]); err != nil {
+			log.Printf("Error while reconciling user %d: %v\n", users[i].ID, err)
+		}
+	}
+
+	sharedObjects, err := rs.listObjects(run.sharedBucket)
+	if err != nil {
+		return nil, err
+	}
+	run.sharedObjects = sharedObjects
+
+	rs.reconcileFileReferences(run)
+	rs.reconcileStoredObjects(run, run.sharedBucket, sharedObjectRows, sharedObjects, run.sharedRefs)
+	rs.reconcileOrphans(run, run.sharedBucket, sharedObjects, storedObjectKeys(sharedObjectRows))
+	rs.reconcileTempDir(run)
+
+	run.report.FinishedAt = time.Now()
+	return run.report, nil
+}
+
+// ReconcileJob runs a reconciliation and logs what it found. It repairs the issues when
+// RECONCILE_REPAIR is enabled.
+//
+// It is meant to run as a cron job, so errors are logged instead of returned.
+func (rs *ReconcileService) ReconcileJob() {
+	report, err := rs.Reconcile(ReconcileRepairEnabled())
+	if err != nil {
+		log.Printf("Error while reconciling storage: %v\n", err)
+		return
+	}
+
+	for _, issues := range [][]models.ReconcileIssue{report.OrphanObjects, report.MissingObjects, report.RefCountMismatches, report.StaleTempPaths} {
+		for _, issue := range issues {
+			log.Printf("Reconcile: %s %s/%s %s (repaired: %t) %s\n", issue.Kind, issue.Bucket, issue.Key, issue.Detail, issue.Repaired, issue.Error)
+		}
+	}
+
+	log.Printf("Reconciled storage, %d issues found\n", report.IssueCount())
+}
+
+// listObjects returns every object of a bucket by key. A missing bucket has no objects.
+func (rs *ReconcileService) listObjects(bucket string) (map[string]minio.ObjectInfo, error) {
+	objects := map[string]minio.ObjectInfo{}
+	if bucket == "" {
+		return objects, nil
+	}
+
+	for object := range rs.MinioClient.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			if minio.ToErrorResponse(object.Err).Code == "NoSuchBucket" {
+				return objects, nil
+			}
+			return nil, object.Err
+		}
+		objects[object.Key] = object
+	}
+
+	return objects, nil
+}
+
+func (rs *ReconcileService) removeObject(bucket, key string) func() error {
+	return func() error {
+		return rs.MinioClient.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{})
+	}
+}
+
+// objectKey strips the leading slash some keys were saved with, MinIO lists them without it.
+func objectKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+func storedObjectKeys(storedObjects []models.StoredObject) map[string]bool {
+	keys := map[string]bool{}
+	for _, storedObject := range storedObjects {
+		keys[storedObject.ObjectKey] = true
+	}
+	return keys
+}
+
+// flagCorrupted sets the corruption flag of a row, keeping the time it was first flagged.
+func (rs *ReconcileService) flagCorrupted(model interface{}, id uint) func() error {
+	return func() error {
+		return rs.DB.Unscoped().Model(model).
+			Where("id = ? AND corrupted_at IS NULL", id).
+			UpdateColumn("corrupted_at", time.Now()).Error
+	}
+}
+
+// reconcileUser checks the bucket and the service bucket of a user.
+func (rs *ReconcileService) reconcileUser(run *reconcileRun, user *models.User) error {
+	// Trashed files still have their objects
+	var files []models.File
+	if err := rs.DB.Unscoped().Where("user_id = ?", user.ID).Find(&files).Error; err != nil {
+		return err
+	}
+
+	var versions []models.FileVersion
+	if err := rs.DB.Where("user_id = ?", user.ID).Find(&versions).Error; err != nil {
+		return err
+	}
+
+	var storedObjects []models.StoredObject
+	if err := rs.DB.Where("bucket = ?", user.MinioBucket).Find(&storedObjects).Error; err != nil {
+		return err
+	}
+
+	var thumbnails []models.Thumbnail
+	err := rs.DB.Select("thumbnails.*").
+		Joins("JOIN files ON files.id = thumbnails.file_id").
+		Where("files.user_id = ?", user.ID).
+		Find(&thumbnails).Error
+	if err != nil {
+		return err
+	}
+
+	objects, err := rs.listObjects(user.MinioBucket)
+	if err != nil {
+		return err
+	}
+
+	serviceObjects, err := rs.listObjects(user.MinioServiceBucket)
+	if err != nil {
+		return err
+	}
+
+	expected := storedObjectKeys(storedObjects)
+	refs := map[string]uint{}
+
+	reference := func(kind string, key string, detail string, fix func() error) {
+		if strings.HasPrefix(key, models.SHARED_OBJECT_PREFIX) {
+			// Checked once the shared bucket is listed
+			run.sharedRefs[key]++
+			return
+		}
+
+		refs[key]++
+		expected[key] = true
+		if _, ok := objects[key]; !ok {
+			run.record(&run.report.MissingObjects, models.ReconcileIssue{
+				Kind:   kind,
+				Bucket: user.MinioBucket,
+				Key:    key,
+				Detail: detail,
+			}, fix)
+		}
+	}
+
+	for i := range files {
+		file := &files[i]
+		reference(models.RECONCILE_KIND_FILE, objectKey(file.StorageKey()), file.FileCode, rs.flagCorrupted(&models.File{}, file.ID))
+	}
+
+	for i := range versions {
+		version := &versions[i]
+		detail := fmt.Sprintf("file %d version %d", version.FileID, version.Version)
+		reference(models.RECONCILE_KIND_VERSION, objectKey(version.ObjectKey), detail, rs.flagCorrupted(&models.FileVersion{}, version.ID))
+	}
+
+	rs.reconcileStoredObjects(run, user.MinioBucket, storedObjects, objects, refs)
+	rs.reconcileOrphans(run, user.MinioBucket, objects, expected)
+	rs.reconcileDerivatives(run, user, files, thumbnails, serviceObjects)
+
+	return nil
+}
+
+// reconcileFileReferences reports the files and versions referencing a shared object that is missing.
+func (rs *ReconcileService) reconcileFileReferences(run *reconcileRun) {
+	for key := range run.sharedRefs {
+		if _, ok := run.sharedObjects[key]; ok {
+			continue
+		}
+
+		var files []models.File
+		if err := rs.DB.Unscoped().Where("object_key = ?", key).Find(&files).Error; err != nil {
+			log.Printf("Error while listing files of a missing object: %s -> %v\n", key, err)
+			continue
+		}
+
+		for _, file := range files {
+			run.record(&run.report.MissingObjects, models.ReconcileIssue{
+				Kind:   models.RECONCILE_KIND_FILE,
+				Bucket: run.sharedBucket,
+				Key:    key,
+				Detail: file.FileCode,
+			}, rs.flagCorrupted(&models.File{}, file.ID))
+		}
+
+		var versions []models.FileVersion
+		if err := rs.DB.Where("object_key = ?", key).Find(&versions).Error; err != nil {
+			log.Printf("Error while listing versions of a missing object: %s -> %v\n", key, err)
+			continue
+		}
+
+		for _, version := range versions {
+			run.record(&run.report.MissingObjects, models.ReconcileIssue{
+				Kind:   models.RECONCILE_KIND_VERSION,
+				Bucket: run.sharedBucket,
+				Key:    key,
+				Detail: fmt.Sprintf("file %d version %d", version.FileID, version.Version),
+			}, rs.flagCorrupted(&models.FileVersion{}, version.ID))
+		}
+	}
+}
+
+// reconcileStoredObjects checks that every stored object of a bucket exists, and that its reference
+// count matches the files and versions referencing it.
+func (rs *ReconcileService) reconcileStoredObjects(run *reconcileRun, bucket string, storedObjects []models.StoredObject, objects map[string]minio.ObjectInfo, refs map[string]uint) {
+	for i := range storedObjects {
+		storedObject := &storedObjects[i]
+
+		if _, ok := objects[storedObject.ObjectKey]; !ok {
+			run.record(&run.report.MissingObjects, models.ReconcileIssue{
+				Kind:   models.RECONCILE_KIND_STORED_OBJECT,
+				Bucket: bucket,
+				Key:    storedObject.ObjectKey,
+				Detail: storedObject.Hash,
+			}, rs.flagCorrupted(&models.StoredObject{}, storedObject.ID))
+		}
+
+		// The reference is taken before the row of the file is saved, recent changes may be in progress
+		if storedObject.UpdatedAt.After(run.cutoff) || storedObject.RefCount == refs[storedObject.ObjectKey] {
+			continue
+		}
+
+		run.record(&run.report.RefCountMismatches, models.ReconcileIssue{
+			Kind:   models.RECONCILE_KIND_STORED_OBJECT,
+			Bucket: bucket,
+			Key:    storedObject.ObjectKey,
+			Detail: fmt.Sprintf("ref_count %d, %d references", storedObject.RefCount, refs[storedObject.ObjectKey]),
+		}, rs.fixRefCount(storedObject, refs[storedObject.ObjectKey]))
+	}
+}
+
+// fixRefCount sets the reference count of a stored object, removing it when nothing references it.
+// The row is only changed if its count didn't change since it was read.
+func (rs *ReconcileService) fixRefCount(storedObject *models.StoredObject, refs uint) func() error {
+	return func() error {
+		return rs.DB.Transaction(func(tx *gorm.DB) error {
+			query := tx.Model(&models.StoredObject{}).Where("id = ? AND ref_count = ?", storedObject.ID, storedObject.RefCount)
+
+			var result *gorm.DB
+			if refs > 0 {
+				result = query.UpdateColumn("ref_count", refs)
+			} else {
+				result = query.Delete(&models.StoredObject{})
+			}
+
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("stored object %d changed during reconciliation", storedObject.ID)
+			}
+
+			if refs > 0 {
+				return nil
+			}
+
+			// Removed before committing, as Release does, so a concurrent Store of the same content
+			// uploads it again instead of referencing a removed object
+			return rs.removeObject(storedObject.Bucket, storedObject.ObjectKey)()
+		})
+	}
+}
+
+// reconcileOrphans reports the objects of a bucket no row references.
+func (rs *ReconcileService) reconcileOrphans(run *reconcileRun, bucket string, objects map[string]minio.ObjectInfo, expected map[string]bool) {
+	for key, object := range objects {
+		if expected[key] || object.LastModified.After(run.cutoff) {
+			continue
+		}
+
+		run.record(&run.report.OrphanObjects, models.ReconcileIssue{
+			Kind:   models.RECONCILE_KIND_OBJECT,
+			Bucket: bucket,
+			Key:    key,
+		}, rs.removeObject(bucket, key))
+	}
+}
+
+// reconcileDerivatives checks the thumbnails and HLS files of a user against their service bucket.
+func (rs *ReconcileService) reconcileDerivatives(run *reconcileRun, user *models.User, files []models.File, thumbnails []models.Thumbnail, serviceObjects map[string]minio.ObjectInfo) {
+	bucket := user.MinioServiceBucket
+	expected := map[string]bool{}
+
+	for i := range thumbnails {
+		thumbnail := &thumbnails[i]
+		key := objectKey(thumbnail.FilePath)
+		expected[key] = true
+
+		if _, ok := serviceObjects[key]; !ok {
+			run.record(&run.report.MissingObjects, models.ReconcileIssue{
+				Kind:   models.RECONCILE_KIND_THUMBNAIL,
+				Bucket: bucket,
+				Key:    key,
+				Detail: fmt.Sprintf("file %d", thumbnail.FileID),
+			}, func() error {
+				return rs.DB.Unscoped().Delete(&models.Thumbnail{}, thumbnail.ID).Error
+			})
+		}
+	}
+
+	previewable := map[string]bool{}
+	for i := range files {
+		file := &files[i]
+		if !file.IsPreviewable || !strings.HasPrefix(file.FileType, "video/") {
+			continue
+		}
+
+		previewable[file.FileCode] = true
+		key := fmt.Sprintf("hls/%s/%s.m3u8", file.FileCode, file.FileCode)
+		if _, ok := serviceObjects[key]; !ok {
+			run.record(&run.report.MissingObjects, models.ReconcileIssue{
+				Kind:   models.RECONCILE_KIND_HLS,
+				Bucket: bucket,
+				Key:    key,
+				Detail: file.FileCode,
+			}, func() error {
+				return rs.DB.Unscoped().Model(&models.File{}).Where("id = ?", file.ID).
+					UpdateColumns(map[string]interface{}{"is_previewable": false, "hls_size": 0}).Error
+			})
+		}
+	}
+
+	// Segments of a video that isn't previewable were left by a failed or unfinished HLS job
+	for key := range serviceObjects {
+		if parts := strings.SplitN(key, "/", 3); len(parts) == 3 && parts[0] == "hls" && previewable[parts[1]] {
+			expected[key] = true
+		}
+	}
+
+	rs.reconcileOrphans(run, bucket, serviceObjects, expected)
+}
+
+// reconcileTempDir reports the files and directories thumbnail and HLS jobs left in the temporary
+// directory. They are named after file codes: /tmp/<fileCode> and /tmp/<fileCode>-file.
+func (rs *ReconcileService) reconcileTempDir(run *reconcileRun) {
+	entries, err := os.ReadDir(rs.TempDir)
+	if err != nil {
+		log.Printf("Error while listing temporary directory: %v\n", err)
+		return
+	}
+
+	staleBefore := run.report.StartedAt.Add(-STALE_TEMP_AGE)
+	for _, entry := range entries {
+		fileCode := strings.TrimSuffix(entry.Name(), "-file")
+		if code, err := uuid.FromString(fileCode); err != nil || code.String() != fileCode {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(staleBefore) {
+			continue
+		}
+
+		path := filepath.Join(rs.TempDir, entry.Name())
+		run.record(&run.report.StaleTempPaths, models.ReconcileIssue{
+			Kind: models.RECONCILE_KIND_TEMP,
+			Key:  path,
+		}, func() error {
+			return os.RemoveAll(path)
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/gofrs/uuid/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"gorm.io/gorm"
+)
+
+// fakeS3 serves the object listing and removal the reconciliation uses, from objects kept in memory.
+// Buckets it doesn't know are empty.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]time.Time
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified string
+	Size         int64
+	ETag         string
+}
+
+type fakeS3Listing struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	KeyCount    int
+	IsTruncated bool
+	Contents    []fakeS3Object
+}
+
+func (s3 *fakeS3) put(bucket, key string, modified time.Time) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	if s3.buckets[bucket] == nil {
+		s3.buckets[bucket] = map[string]time.Time{}
+	}
+	s3.buckets[bucket][key] = modified
+}
+
+func (s3 *fakeS3) has(bucket, key string) bool {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	_, ok := s3.buckets[bucket][key]
+	return ok
+}
+
+func (s3 *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		listing := fakeS3Listing{Name: bucket}
+		for objectKey, modified := range s3.buckets[bucket] {
+			listing.Contents = append(listing.Contents, fakeS3Object{
+				Key:          objectKey,
+				LastModified: modified.UTC().Format("2006-01-02T15:04:05.000Z"),
+				Size:         1,
+				ETag:         `"d41d8cd98f00b204e9800998ecf8427e"`,
+			})
+		}
+		listing.KeyCount = len(listing.Contents)
+
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(listing)
+	case r.Method == http.MethodDelete && key != "":
+		delete(s3.buckets[bucket], key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// reconcileFixture is a user whose storage has one issue of every kind the reconciliation repairs.
+type reconcileFixture struct {
+	s3      *fakeS3
+	user    models.User
+	kept    *models.File
+	missing *models.File
+	// Referenced by kept only, but counted twice
+	miscounted models.StoredObject
+	// Referenced by nothing
+	unreferenced models.StoredObject
+	thumbnail    models.Thumbnail
+	stalePath    string
+}
+
+func newReconcileFixture(t *testing.T, db *gorm.DB) (*ReconcileService, *reconcileFixture) {
+	s3 := &fakeS3{buckets: map[string]map[string]time.Time{}}
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+		Creds:        credentials.NewStaticV4("test", "test", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		t.Fatalf("failed to create MinIO client: %v", err)
+	}
+
+	now := time.Now().UnixNano()
+	old := time.Now().Add(-2 * RECONCILE_GRACE_PERIOD)
+	fixture := &reconcileFixture{s3: s3}
+
+	fixture.user = models.User{
+		Email:              fmt.Sprintf("reconcile-%d@example.org", now),
+		MinioBucket:        fmt.Sprintf("reconcile-%d", now),
+		MinioServiceBucket: fmt.Sprintf("reconcile-%d-service", now),
+	}
+	if err := db.Create(&fixture.user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	bucket := fixture.user.MinioBucket
+
+	folder := createTestFolder(t, db, fixture.user.ID, nil, "root")
+	fixture.kept = createTestFile(t, db, fixture.user.ID, folder, "kept.txt")
+	fixture.missing = createTestFile(t, db, fixture.user.ID, folder, "missing.txt")
+	s3.put(bucket, fixture.kept.StorageKey(), old)
+	s3.put(bucket, "orphan", old)
+	s3.put(bucket, "unreferenced", old)
+
+	fixture.miscounted = models.StoredObject{Bucket: bucket, Hash: strings.Repeat("a", 64), ObjectKey: fixture.kept.StorageKey(), Size: 1, RefCount: 2}
+	fixture.unreferenced = models.StoredObject{Bucket: bucket, Hash: strings.Repeat("b", 64), ObjectKey: "unreferenced", Size: 1, RefCount: 1}
+	for _, storedObject := range []*models.StoredObject{&fixture.miscounted, &fixture.unreferenced} {
+		if err := db.Create(storedObject).Error; err != nil {
+			t.Fatalf("failed to create stored object: %v", err)
+		}
+		// Recent changes are left alone, they may be in progress
+		if err := db.Model(storedObject).UpdateColumn("updated_at", old).Error; err != nil {
+			t.Fatalf("failed to age stored object: %v", err)
+		}
+	}
+
+	fixture.thumbnail = models.Thumbnail{FileID: fixture.kept.ID, FilePath: "/thumb/kept.jpg"}
+	if err := db.Create(&fixture.thumbnail).Error; err != nil {
+		t.Fatalf("failed to create thumbnail: %v", err)
+	}
+
+	rs := NewReconcileService(db, client)
+	rs.TempDir = t.TempDir()
+	fixture.stalePath = filepath.Join(rs.TempDir, uuid.Must(uuid.NewV4()).String())
+	if err := os.WriteFile(fixture.stalePath, []byte("stale"), 0o600); err != nil {
+		t.Fatalf("failed to create temporary file: %v", err)
+	}
+	staleAt := time.Now().Add(-2 * STALE_TEMP_AGE)
+	if err := os.Chtimes(fixture.stalePath, staleAt, staleAt); err != nil {
+		t.Fatalf("failed to age temporary file: %v", err)
+	}
+
+	return rs, fixture
+}
+
+// issuesOf returns the issues found in a bucket by key. Other users of the database may have issues too.
+func issuesOf(issues []models.ReconcileIssue, bucket string) map[string]models.ReconcileIssue {
+	byKey := map[string]models.ReconcileIssue{}
+	for _, issue := range issues {
+		if issue.Bucket == bucket {
+			byKey[issue.Key] = issue
+		}
+	}
+	return byKey
+}
+
+// checkReported checks that every issue of the fixture was found, and repaired when repair is set.
+func (fixture *reconcileFixture) checkReported(t *testing.T, report *models.ReconcileReport, repair bool) {
+	t.Helper()
+	bucket := fixture.user.MinioBucket
+
+	expected := []struct {
+		name   string
+		issues map[string]models.ReconcileIssue
+		key    string
+	}{
+		{"orphan object", issuesOf(report.OrphanObjects, bucket), "orphan"},
+		{"missing file", issuesOf(report.MissingObjects, bucket), fixture.missing.StorageKey()},
+		{"missing thumbnail", issuesOf(report.MissingObjects, fixture.user.MinioServiceBucket), "thumb/kept.jpg"},
+		{"miscounted object", issuesOf(report.RefCountMismatches, bucket), fixture.kept.StorageKey()},
+		{"unreferenced object", issuesOf(report.RefCountMismatches, bucket), "unreferenced"},
+		{"stale temporary file", issuesOf(report.StaleTempPaths, ""), fixture.stalePath},
+	}
+
+	for _, tt := range expected {
+		issue, ok := tt.issues[tt.key]
+		if !ok {
+			t.Errorf("%s %s wasn't reported", tt.name, tt.key)
+			continue
+		}
+		if issue.Repaired != repair || issue.Error != "" {
+			t.Errorf("%s %s: repaired = %v (%s), want %v", tt.name, tt.key, issue.Repaired, issue.Error, repair)
+		}
+	}
+
+	if _, ok := issuesOf(report.OrphanObjects, bucket)["unreferenced"]; ok {
+		t.Error("an object with a stored object row was reported as an orphan")
+	}
+}
+
+func TestReconcileRepair(t *testing.T) {
+	db := testDB(t)
+	rs, fixture := newReconcileFixture(t, db)
+	bucket := fixture.user.MinioBucket
+
+	report, err := rs.Reconcile(true)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	fixture.checkReported(t, report, true)
+
+	if fixture.s3.has(bucket, "orphan") {
+		t.Error("orphan object wasn't removed")
+	}
+	if !fixture.s3.has(bucket, fixture.kept.StorageKey()) {
+		t.Error("referenced object was removed")
+	}
+
+	var missing models.File
+	if err := db.First(&missing, fixture.missing.ID).Error; err != nil || missing.CorruptedAt == nil {
+		t.Errorf("file without object: corrupted_at = %v (%v), want it flagged", missing.CorruptedAt, err)
+	}
+
+	var miscounted models.StoredObject
+	if err := db.First(&miscounted, fixture.miscounted.ID).Error; err != nil || miscounted.RefCount != 1 {
+		t.Errorf("miscounted stored object: ref_count = %d (%v), want 1", miscounted.RefCount, err)
+	}
+
+	// fixRefCount deletes what nothing references, the row along with its object
+	var unreferenced int64
+	db.Model(&models.StoredObject{}).Where("id = ?", fixture.unreferenced.ID).Count(&unreferenced)
+	if unreferenced != 0 || fixture.s3.has(bucket, "unreferenced") {
+		t.Errorf("unreferenced stored object: %d rows left, object left = %v", unreferenced, fixture.s3.has(bucket, "unreferenced"))
+	}
+
+	var thumbnails int64
+	db.Unscoped().Model(&models.Thumbnail{}).Where("id = ?", fixture.thumbnail.ID).Count(&thumbnails)
+	if thumbnails != 0 {
+		t.Error("thumbnail without object wasn't deleted")
+	}
+
+	if _, err := os.Stat(fixture.stalePath); !os.IsNotExist(err) {
+		t.Errorf("stale temporary file wasn't removed: %v", err)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	db := testDB(t)
+	rs, fixture := newReconcileFixture(t, db)
+	bucket := fixture.user.MinioBucket
+
+	report, err := rs.Reconcile(false)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	fixture.checkReported(t, report, false)
+
+	for _, key := range []string{"orphan", "unreferenced", fixture.kept.StorageKey()} {
+		if !fixture.s3.has(bucket, key) {
+			t.Errorf("object %s was removed by a dry run", key)
+		}
+	}
+
+	var missing models.File
+	if err := db.First(&missing, fixture.missing.ID).Error; err != nil || missing.CorruptedAt != nil {
+		t.Errorf("file without object: corrupted_at = %v (%v), want it left as is", missing.CorruptedAt, err)
+	}
+
+	var storedObjects []models.StoredObject
+	db.Where("id IN ?", []uint{fixture.miscounted.ID, fixture.unreferenced.ID}).Order("id").Find(&storedObjects)
+	if len(storedObjects) != 2 || storedObjects[0].RefCount != 2 || storedObjects[1].RefCount != 1 {
+		t.Errorf("stored objects = %+v, want both left with their counts", storedObjects)
+	}
+
+	var thumbnails int64
+	db.Model(&models.Thumbnail{}).Where("id = ?", fixture.thumbnail.ID).Count(&thumbnails)
+	if thumbnails != 1 {
+		t.Error("thumbnail was deleted by a dry run")
+	}
+
+	if _, err := os.Stat(fixture.stalePath); err != nil {
+		t.Errorf("stale temporary file was removed by a dry run: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_SCRUB_CRON       = "0 3 * * *"
+	DEFAULT_SCRUB_BATCH_SIZE = 100
+)
+
+// ScrubCronSpec returns when the scrub job runs, read from SCRUB_CRON. It runs every night by default.
+func ScrubCronSpec() string {
+	if spec := os.Getenv("SCRUB_CRON"); spec != "" {
+		return spec
+	}
+	return DEFAULT_SCRUB_CRON
+}
+
+func scrubBatchSize() int {
+	size, err := strconv.Atoi(os.Getenv("SCRUB_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		return DEFAULT_SCRUB_BATCH_SIZE
+	}
+	return size
+}
+
+// ScrubService reads stored objects back from MinIO and checks them against the
+// SHA-256 recorded when they were uploaded, to catch bit rot and lost objects.
+// It works across all users, so it uses the MinIO client directly instead of a BucketClient.
+type ScrubService struct {
+	DB          *gorm.DB
+	MinioClient *minio.Client
+}
+
+func NewScrubService(db *gorm.DB, mc *minio.Client) *ScrubService {
+	return &ScrubService{
+		DB:          db,
+		MinioClient: mc,
+	}
+}
+
+// Scrub checks the objects that went the longest without being checked, SCRUB_BATCH_SIZE at a time,
+// and flags the files and versions whose content is corrupted or missing.
+// Objects that become readable again are unflagged.
+//
+// It is meant to run as a cron job, so errors are logged instead of returned.
+func (ss *ScrubService) Scrub() {
+	var storedObjects []models.StoredObject
+	if err := ss.DB.Order("checked_at ASC").Limit(scrubBatchSize()).Find(&storedObjects).Error; err != nil {
+		log.Printf("Error while listing objects to scrub: %v\n", err)
+		return
+	}
+
+	corruptedCount := 0
+	for i := range storedObjects {
+		storedObject := &storedObjects[i]
+
+		corrupted, err := ss.verify(storedObject)
+		if err != nil {
+			log.Printf("Error while scrubbing object: %s/%s -> %v\n", storedObject.Bucket, storedObject.ObjectKey, err)
+			continue
+		}
+
+		now := time.Now()
+		corruptedAt := storedObject.CorruptedAt
+		if !corrupted {
+			corruptedAt = nil
+		} else if corruptedAt == nil {
+			corruptedAt = &now
+		}
+
+		if corrupted {
+			corruptedCount++
+			log.Printf("Corrupted object: %s/%s\n", storedObject.Bucket, storedObject.ObjectKey)
+		}
+
+		err = ss.DB.Model(storedObject).UpdateColumns(map[string]interface{}{
+			"checked_at":   now,
+			"corrupted_at": corruptedAt,
+		}).Error
+		if err != nil {
+			log.Printf("Error while saving scrub result: %s/%s -> %v\n", storedObject.Bucket, storedObject.ObjectKey, err)
+			continue
+		}
+
+		if err := ss.flagReferences(storedObject, corruptedAt); err != nil {
+			log.Printf("Error while flagging corrupted files: %s/%s -> %v\n", storedObject.Bucket, storedObject.ObjectKey, err)
+		}
+	}
+
+	log.Printf("Scrubbed %d objects, %d corrupted\n", len(storedObjects), corruptedCount)
+}
+
+// verify reports whether an object is missing or doesn't match its hash anymore.
+func (ss *ScrubService) verify(storedObject *models.StoredObject) (bool, error) {
+	object, err := ss.MinioClient.GetObject(context.Background(), storedObject.Bucket, storedObject.ObjectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer object.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return size != int64(storedObject.Size) || hex.EncodeToString(h.Sum(nil)) != storedObject.Hash, nil
+}
+
+// flagReferences sets the corruption flag of every file and version stored in the object.
+// Objects of user buckets share their key with other users' objects, so only the files of
+// the bucket's owner are flagged.
+func (ss *ScrubService) flagReferences(storedObject *models.StoredObject, corruptedAt *time.Time) error {
+	files := ss.DB.Unscoped().Model(&models.File{}).Where("object_key = ?", storedObject.ObjectKey)
+	versions := ss.DB.Unscoped().Model(&models.FileVersion{}).Where("object_key = ?", storedObject.ObjectKey)
+
+	if storedObject.Bucket != SharedBucketName() {
+		owners := ss.DB.Model(&models.User{}).Select("id").Where("minio_bucket = ?", storedObject.Bucket)
+		files = files.Where("user_id IN (?)", owners)
+		versions = versions.Where("user_id IN (?)", owners)
+	}
+
+	if err := files.UpdateColumn("corrupted_at", corruptedAt).Error; err != nil {
+		return err
+	}
+
+	return versions.UpdateColumn("corrupted_at", corruptedAt).Error
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_SEARCH_RESULTS   = 50
+	MAX_SEARCH_SNIPPETS  = 3
+	booleanModeOperators = `+-<>()~*"@`
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+type SearchService struct {
+	DB *gorm.DB
+}
+
+func NewSearchService(db *gorm.DB) *SearchService {
+	return &SearchService{
+		DB: db,
+	}
+}
+
+// searchTerms splits the query into words, stripping the characters that have a
+// special meaning in MariaDB's boolean full-text mode.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.Trim(strings.Map(func(r rune) rune {
+			if strings.ContainsRune(booleanModeOperators, r) {
+				return ' '
+			}
+			return r
+		}, word), " ")
+
+		if word != "" {
+			terms = append(terms, strings.Fields(word)...)
+		}
+	}
+	return terms
+}
+
+var mediaTypeConditions = map[string]string{
+	"image": "files.file_type LIKE 'image/%'",
+	"video": "files.file_type LIKE 'video/%'",
+	"audio": "files.file_type LIKE 'audio/%'",
+	"document": "(files.file_type LIKE 'text/%' OR files.file_type IN ('application/pdf', 'application/msword', 'application/rtf') " +
+		"OR files.file_type LIKE 'application/vnd.openxmlformats-officedocument.%' OR files.file_type LIKE 'application/vnd.ms-%' " +
+		"OR files.file_type LIKE 'application/vnd.oasis.opendocument.%')",
+	"archive": "files.file_type IN ('application/zip', 'application/x-zip-compressed', 'application/x-rar-compressed', " +
+		"'application/vnd.rar', 'application/x-7z-compressed', 'application/x-tar', 'application/gzip', 'application/x-gzip')",
+}
+
+// applyMediaTypeFilter narrows a files query down to one media type category.
+// An empty category leaves the query untouched.
+func applyMediaTypeFilter(query *gorm.DB, mediaType string) *gorm.DB {
+	if condition, ok := mediaTypeConditions[mediaType]; ok {
+		return query.Where(condition)
+	}
+	return query
+}
+
+// filesQuery builds a query over the live files of a user matching every criterion of the search query,
+// leaving out the files of trashed folders.
+// It also returns the full-text terms so callers can highlight them.
+func (ss *SearchService) filesQuery(userID uint, searchQuery models.SearchQuery) (*gorm.DB, []string) {
+	query := ss.DB.Model(&models.File{}).
+		Where("files.user_id = ? AND files.folder_id NOT IN (?)", userID, ss.DB.Raw(trashedFoldersQuery, userID))
+
+	terms := searchTerms(searchQuery.Query)
+	if len(terms) > 0 {
+		booleanQuery := "+" + strings.Join(terms, " +") + "*"
+		nameQuery := "%"
+		for _, term := range terms {
+			nameQuery += likeEscaper.Replace(term) + "%"
+		}
+
+		query = query.
+			Joins("LEFT JOIN file_contents ON file_contents.file_id = files.id").
+			Where("files.file_name LIKE ? OR MATCH(file_contents.content) AGAINST (? IN BOOLEAN MODE)", nameQuery, booleanQuery)
+	}
+
+	query = applyMediaTypeFilter(query, searchQuery.MediaType)
+
+	if searchQuery.MinSize != nil {
+		query = query.Where("files.file_size >= ?", *searchQuery.MinSize)
+	}
+
+	if searchQuery.MaxSize != nil {
+		query = query.Where("files.file_size <= ?", *searchQuery.MaxSize)
+	}
+
+	if searchQuery.CreatedAfter != "" {
+		query = query.Where("files.created_at >= ?", searchQuery.CreatedAfter)
+	}
+
+	if searchQuery.CreatedBefore != "" {
+		// Dates are inclusive, so "before 2023-12-31" still covers that whole day
+		query = query.Where("files.created_at < DATE_ADD(?, INTERVAL 1 DAY)", searchQuery.CreatedBefore)
+	}
+
+	if searchQuery.FavoriteOnly {
+		query = query.Where("files.is_favorite = ?", true)
+	}
+
+	if tags := normalizeTags(searchQuery.Tags); len(tags) > 0 {
+		taggedFiles := ss.DB.Table("file_tags").
+			Select("file_tags.file_id").
+			Joins("JOIN tags ON tags.id = file_tags.tag_id").
+			Where("tags.user_id = ? AND tags.name IN ?", userID, tags).
+			Group("file_tags.file_id").
+			Having("COUNT(DISTINCT tags.id) = ?", len(tags))
+		query = query.Where("files.id IN (?)", taggedFiles)
+	}
+
+	return query, terms
+}
+
+// Search looks for files of a user whose name or extracted content matches the
+// query, narrowed down by the other criteria of the search query.
+//
+// Every term of the query must be present for a document to match on content,
+// and the last term is treated as a prefix. Trashed files are left out.
+// Each result carries highlighted snippets of the content around the matches.
+//
+// If the search query has no criteria, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (ss *SearchService) Search(userID uint, searchQuery models.SearchQuery) ([]models.SearchResult, error) {
+	if searchQuery.IsEmpty() || (searchQuery.Query != "" && len(searchTerms(searchQuery.Query)) == 0) {
+		return nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Search query is empty",
+			},
+		}
+	}
+
+	query, terms := ss.filesQuery(userID, searchQuery)
+
+	var files []*models.File
+	err := query.
+		Order("files.updated_at DESC").
+		Limit(MAX_SEARCH_RESULTS).
+		Preload("Content").
+		Preload("Tags").
+		Find(&files).Error
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to search files",
+				Err:     err,
+			},
+		}
+	}
+
+	results := make([]models.SearchResult, 0, len(files))
+	for _, file := range files {
+		snippets := []string{}
+		if file.Content != nil {
+			snippets = utils.HighlightSnippets(file.Content.Content, terms, MAX_SEARCH_SNIPPETS)
+			file.Content = nil
+		}
+
+		results = append(results, models.SearchResult{
+			File:     file,
+			Snippets: snippets,
+		})
+	}
+
+	return results, nil
+}
+
+// FindFiles returns the live files of a user matching the search query, one page at a time.
+//
+// If the params are invalid, it returns an InvalidParamError.
+// If an internal server error occurs, it returns a ServerError.
+func (ss *SearchService) FindFiles(userID uint, searchQuery models.SearchQuery, params models.ListParams) (*models.FileListResponse, error) {
+	query, _ := ss.filesQuery(userID, searchQuery)
+
+	return paginateFiles(query, params)
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func createTestFile(t *testing.T, db *gorm.DB, userID uint, folder *models.Folder, name string) *models.File {
+	file := &models.File{
+		UserID:   userID,
+		FolderID: folder.ID,
+		FileName: name,
+		FileCode: fmt.Sprintf("%s-%d", name, time.Now().UnixNano()),
+		FileSize: 1,
+		FileType: "text/plain",
+	}
+
+	if err := db.Create(file).Error; err != nil {
+		t.Fatalf("failed to create file %s: %v", name, err)
+	}
+
+	return file
+}
+
+func TestFilesQuerySkipsTrashedFolders(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+
+	root := createTestFolder(t, db, userID, nil, "root")
+	trashed := createTestFolder(t, db, userID, root, "trashed")
+	inside := createTestFolder(t, db, userID, trashed, "inside")
+
+	kept := createTestFile(t, db, userID, root, "kept.txt")
+	createTestFile(t, db, userID, trashed, "trashed.txt")
+	createTestFile(t, db, userID, inside, "inside.txt")
+
+	if err := db.Delete(trashed).Error; err != nil {
+		t.Fatalf("failed to trash folder: %v", err)
+	}
+
+	query, _ := NewSearchService(db).filesQuery(userID, models.SearchQuery{})
+	var files []models.File
+	if err := query.Find(&files).Error; err != nil {
+		t.Fatalf("filesQuery() error = %v", err)
+	}
+
+	if len(files) != 1 || files[0].ID != kept.ID {
+		t.Errorf("filesQuery() found %d files, want only %s", len(files), kept.FileName)
+	}
+}
+
+func TestHardDeleteTaggedFile(t *testing.T) {
+	db := testDB(t)
+	userID := uint(time.Now().UnixNano() % 1000000)
+
+	folder := createTestFolder(t, db, userID, nil, "root")
+	file := createTestFile(t, db, userID, folder, "tagged.txt")
+
+	tag := models.Tag{UserID: userID, Name: "holiday"}
+	if err := db.Create(&tag).Error; err != nil {
+		t.Fatalf("failed to create tag: %v", err)
+	}
+	if err := db.Model(file).Association("Tags").Append(&tag); err != nil {
+		t.Fatalf("failed to tag file: %v", err)
+	}
+
+	if err := db.Unscoped().Delete(file).Error; err != nil {
+		t.Fatalf("hard delete of a tagged file error = %v", err)
+	}
+
+	var links int64
+	if err := db.Table("file_tags").Where("file_id = ?", file.ID).Count(&links).Error; err != nil {
+		t.Fatalf("failed to count file tags: %v", err)
+	}
+	if links != 0 {
+		t.Errorf("%d file tags left after the file was deleted", links)
+	}
+}
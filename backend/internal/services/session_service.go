@@ -0,0 +1,442 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/gofrs/uuid/v5"
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DEFAULT_ACCESS_TOKEN_TTL  = 15 * time.Minute
+	DEFAULT_REFRESH_TOKEN_TTL = 30 * 24 * time.Hour
+	SESSION_DEVICE_MAX_LENGTH = 255
+
+	// LAST_SEEN_INTERVAL is how often the last seen time of a session is written, at most.
+	LAST_SEEN_INTERVAL = time.Minute
+)
+
+// sessionKey is the key of a session in closedSessions. It starts with the user ID so all the
+// sessions of a user can be dropped at once.
+func sessionKey(userID, sessionID uint) string {
+	return fmt.Sprintf("%d:%d", userID, sessionID)
+}
+
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("%d:", userID)
+}
+
+// AccessTokenTTL returns how long access tokens are valid, read from ACCESS_TOKEN_TTL_MINUTES.
+// It is 15 minutes by default.
+func AccessTokenTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_TTL_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return DEFAULT_ACCESS_TOKEN_TTL
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// RefreshTokenTTL returns how long a session stays open without being refreshed, read from
+// REFRESH_TOKEN_TTL_DAYS. It is 30 days by default.
+func RefreshTokenTTL() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_TTL_DAYS"))
+	if err != nil || days <= 0 {
+		return DEFAULT_REFRESH_TOKEN_TTL
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+type SessionService struct {
+	DB *gorm.DB
+}
+
+func NewSessionService(db *gorm.DB) *SessionService {
+	return &SessionService{
+		DB: db,
+	}
+}
+
+// hashRefreshToken returns the hash a refresh token is stored and looked up by.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken creates a random refresh token for a session and stores its hash.
+func newRefreshToken(db *gorm.DB, sessionID uint, expiresAt time.Time) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	refreshToken := models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := db.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// signAccessToken signs a short-lived access token for a session of a user, with a random jti
+// it can be revoked by.
+func signAccessToken(user *models.User, sessionID uint) (string, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	userClaim := utils.UserClaims{
+		ID:            user.ID,
+		Bucket:        user.MinioBucket,
+		ServiceBucket: user.MinioServiceBucket,
+		SessionID:     sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL()).Unix(),
+		},
+	}
+
+	return utils.GenerateToken(userClaim)
+}
+
+func truncateDevice(device string) string {
+	if len(device) > SESSION_DEVICE_MAX_LENGTH {
+		return device[:SESSION_DEVICE_MAX_LENGTH]
+	}
+	return device
+}
+
+// StartSession opens a session for a user on a device and issues its first access and refresh tokens.
+//
+// If any errors occur, it returns a ServerError.
+func (ss *SessionService) StartSession(user *models.User, device, ip string) (*models.AuthTokens, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		Device:     truncateDevice(device),
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL()),
+	}
+
+	var refreshToken string
+	err := ss.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		refreshToken, err = newRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to start session",
+				Err:     err,
+			},
+		}
+	}
+
+	accessToken, err := signAccessToken(user, session.ID)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to start session",
+				Err:     err,
+			},
+		}
+	}
+
+	return &models.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// Refresh rotates a refresh token: the token is used up and a new access and refresh token are issued
+// for its session. A refresh token used a second time means it leaked, the whole session is revoked.
+//
+// If the token is unknown, expired, reused or its session is closed, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (ss *SessionService) Refresh(refreshToken, device, ip string) (*models.AuthTokens, error) {
+	var tokens *models.AuthTokens
+	reusedSession := ""
+
+	err := ss.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Session.User").
+			Where("token_hash = ?", hashRefreshToken(refreshToken)).
+			First(&current).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apperr.InvalidCredentialsError{
+					BaseError: &apperr.BaseError{
+						Message: "Invalid refresh token",
+						Err:     err,
+					},
+				}
+			}
+			return err
+		}
+
+		now := time.Now()
+		session := current.Session
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) || now.After(current.ExpiresAt) {
+			return &apperr.InvalidCredentialsError{
+				BaseError: &apperr.BaseError{
+					Message: "Session expired",
+				},
+			}
+		}
+
+		if current.RotatedAt != nil {
+			// The revocation has to be committed, the error is returned after the transaction
+			reusedSession = sessionKey(session.UserID, session.ID)
+			return tx.Model(session).Update("revoked_at", now).Error
+		}
+
+		if err := tx.Model(&current).Update("rotated_at", now).Error; err != nil {
+			return err
+		}
+
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(RefreshTokenTTL())
+		session.IP = ip
+		if device != "" {
+			session.Device = truncateDevice(device)
+		}
+
+		err = tx.Model(session).Select("last_seen_at", "expires_at", "ip", "device").Updates(session).Error
+		if err != nil {
+			return err
+		}
+
+		newToken, err := newRefreshToken(tx, session.ID, session.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		accessToken, err := signAccessToken(session.User, session.ID)
+		if err != nil {
+			return err
+		}
+
+		tokens = &models.AuthTokens{
+			AccessToken:  accessToken,
+			RefreshToken: newToken,
+			ExpiresIn:    int64(AccessTokenTTL().Seconds()),
+		}
+		return nil
+	})
+
+	if err != nil {
+		var invalidErr *apperr.InvalidCredentialsError
+		if errors.As(err, &invalidErr) {
+			return nil, invalidErr
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to refresh session",
+				Err:     err,
+			},
+		}
+	}
+
+	if reusedSession != "" {
+		closedSessions.drop(reusedSession)
+		log.Printf("Refresh token reused, session revoked\n")
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Refresh token reused, the session was revoked",
+			},
+		}
+	}
+
+	return tokens, nil
+}
+
+// EndSession revokes the session of a refresh token, when logging out. Unknown tokens are ignored.
+//
+// If any errors occur, it returns a ServerError.
+func (ss *SessionService) EndSession(refreshToken string) error {
+	var token models.RefreshToken
+	err := ss.DB.Preload("Session").Where("token_hash = ?", hashRefreshToken(refreshToken)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+
+	if err == nil {
+		err = ss.DB.Model(&models.Session{}).
+			Where("id = ? AND revoked_at IS NULL", token.SessionID).
+			Update("revoked_at", time.Now()).Error
+	}
+	if err == nil && token.Session != nil {
+		closedSessions.drop(sessionKey(token.Session.UserID, token.SessionID))
+	}
+	if err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to end session",
+				Err:     err,
+			},
+		}
+	}
+
+	return nil
+}
+
+// ListSessions lists the open sessions of a user, the most recently seen first.
+// The session the request is made from is marked as current.
+//
+// If any errors occur, it returns a ServerError.
+func (ss *SessionService) ListSessions(userID, currentSessionID uint) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := ss.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list sessions",
+				Err:     err,
+			},
+		}
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes an open session of a user. Its refresh tokens stop working right away,
+// and so do its access tokens.
+//
+// If the session is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (ss *SessionService) RevokeSession(userID, sessionID uint) error {
+	result := ss.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to revoke session",
+				Err:     result.Error,
+			},
+		}
+	}
+
+	closedSessions.drop(sessionKey(userID, sessionID))
+
+	if result.RowsAffected == 0 {
+		return &apperr.NotFoundError{
+			BaseError: &apperr.BaseError{
+				Message: "Session not found",
+			},
+		}
+	}
+
+	return nil
+}
+
+// RevokeAllSessions revokes every open session of a user and returns how many were revoked.
+//
+// If any errors occur, it returns a ServerError.
+func (ss *SessionService) RevokeAllSessions(userID uint) (int64, error) {
+	result := ss.DB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to revoke sessions",
+				Err:     result.Error,
+			},
+		}
+	}
+
+	closedSessions.drop(userSessionsKey(userID))
+	return result.RowsAffected, nil
+}
+
+// IsSessionOpen tells whether the session of an access token is still open. Lookups are cached like
+// the denylist ones, sessions revoked here are dropped from the cache right away. The last seen time
+// of the session is written when it is read from the database, at most every LAST_SEEN_INTERVAL.
+//
+// If any errors occur, it returns a ServerError.
+func (ss *SessionService) IsSessionOpen(userID, sessionID uint) (bool, error) {
+	key := sessionKey(userID, sessionID)
+	if closed, ok := closedSessions.get(key); ok {
+		return !closed, nil
+	}
+
+	now := time.Now()
+	var session models.Session
+	err := ss.DB.Select("id", "last_seen_at", "expires_at").
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, now).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		closedSessions.set(key, true, now.Add(REVOCATION_CACHE_TTL))
+		return false, nil
+	} else if err != nil {
+		return false, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to check session",
+				Err:     err,
+			},
+		}
+	}
+
+	until := now.Add(REVOCATION_CACHE_TTL)
+	if session.ExpiresAt.Before(until) {
+		until = session.ExpiresAt
+	}
+	closedSessions.set(key, false, until)
+
+	if now.Sub(session.LastSeenAt) > LAST_SEEN_INTERVAL {
+		ss.DB.Model(&session).UpdateColumn("last_seen_at", now)
+	}
+
+	return true, nil
+}
+
+// PruneExpiredSessions deletes the sessions that expired, along with their refresh tokens.
+func (ss *SessionService) PruneExpiredSessions() {
+	log.Println("Start pruning expired sessions")
+	result := ss.DB.Where("expires_at < ?", time.Now()).Delete(&models.Session{})
+
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return
+	}
+
+	log.Printf("Pruned %d expired sessions\n", result.RowsAffected)
+}
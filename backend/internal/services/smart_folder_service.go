@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"gorm.io/gorm"
+)
+
+type SmartFolderService struct {
+	DB *gorm.DB
+}
+
+func NewSmartFolderService(db *gorm.DB) *SmartFolderService {
+	return &SmartFolderService{
+		DB: db,
+	}
+}
+
+// ListSmartFolders lists all smart folders of a user, ordered by name.
+//
+// If an internal server error occurs, it returns a ServerError.
+func (sfs *SmartFolderService) ListSmartFolders(userID uint) ([]models.SmartFolder, error) {
+	smartFolders := []models.SmartFolder{}
+	if err := sfs.DB.Where("user_id = ?", userID).Order("name ASC").Find(&smartFolders).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list smart folders",
+				Err:     err,
+			},
+		}
+	}
+
+	return smartFolders, nil
+}
+
+// GetSmartFolder fetches a smart folder of a user by its code.
+//
+// If the smart folder is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (sfs *SmartFolderService) GetSmartFolder(userID uint, code string) (*models.SmartFolder, error) {
+	var smartFolder models.SmartFolder
+	if err := sfs.DB.Where("user_id = ? AND code = ?", userID, code).First(&smartFolder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Smart folder not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch smart folder",
+				Err:     err,
+			},
+		}
+	}
+
+	return &smartFolder, nil
+}
+
+// CreateSmartFolder saves a search query as a new smart folder.
+//
+// If the query has no criteria, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (sfs *SmartFolderService) CreateSmartFolder(userID uint, body models.SmartFolderBody) (*models.SmartFolder, error) {
+	if body.Query.IsEmpty() {
+		return nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Smart folder query must have at least one criterion",
+			},
+		}
+	}
+
+	code, err := gonanoid.New()
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to generate smart folder code",
+				Err:     err,
+			},
+		}
+	}
+
+	smartFolder := models.SmartFolder{
+		UserID: userID,
+		Name:   body.Name,
+		Code:   code,
+		Query:  body.Query,
+	}
+
+	if err := sfs.DB.Create(&smartFolder).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to create smart folder",
+				Err:     err,
+			},
+		}
+	}
+
+	return &smartFolder, nil
+}
+
+// UpdateSmartFolder replaces the name and query of a smart folder.
+//
+// If the smart folder is not found, it returns a NotFoundError.
+// If the query has no criteria, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (sfs *SmartFolderService) UpdateSmartFolder(userID uint, code string, body models.SmartFolderBody) (*models.SmartFolder, error) {
+	if body.Query.IsEmpty() {
+		return nil, &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Smart folder query must have at least one criterion",
+			},
+		}
+	}
+
+	smartFolder, err := sfs.GetSmartFolder(userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	smartFolder.Name = body.Name
+	smartFolder.Query = body.Query
+
+	if err := sfs.DB.Save(smartFolder).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to update smart folder",
+				Err:     err,
+			},
+		}
+	}
+
+	return smartFolder, nil
+}
+
+// DeleteSmartFolder deletes a smart folder. The files it matched are left untouched.
+//
+// If the smart folder is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (sfs *SmartFolderService) DeleteSmartFolder(userID uint, code string) error {
+	smartFolder, err := sfs.GetSmartFolder(userID, code)
+	if err != nil {
+		return err
+	}
+
+	if err := sfs.DB.Unscoped().Delete(smartFolder).Error; err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to delete smart folder",
+				Err:     err,
+			},
+		}
+	}
+
+	return nil
+}
+
+// FetchSmartFolderFiles evaluates the query of a smart folder and returns a page of the
+// matching files, in the same shape as FetchFolderFiles.
+//
+// If the smart folder is not found, it returns a NotFoundError.
+// If the params are invalid, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (sfs *SmartFolderService) FetchSmartFolderFiles(userID uint, code string, params models.ListParams) (*models.FileListResponse, error) {
+	smartFolder, err := sfs.GetSmartFolder(userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	searchService := NewSearchService(sfs.DB)
+	return searchService.FindFiles(userID, smartFolder.Query, params)
+}
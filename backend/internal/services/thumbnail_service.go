@@ -17,6 +17,7 @@ import (
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/disintegration/imaging"
 	"github.com/minio/minio-go/v7"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
@@ -148,17 +149,19 @@ func getRandomFrameNum(start int, end int) int {
 	return rand.Intn(end-start) + start // Generate random number within range
 }
 
-func processImage(file *models.File, filePath string) (bytes.Buffer, error) {
+// processImage makes the thumbnail of an image, and computes its perceptual hash along the way
+// since the image is already decoded.
+func processImage(file *models.File, filePath string) (bytes.Buffer, uint64, error) {
 	log.Println("Processing image thumbnail for: " + filePath)
 	assetFile, err := os.Open(filePath)
 	if err != nil {
-		return bytes.Buffer{}, fmt.Errorf("error while opening image file %s: %v", file.FileName, err)
+		return bytes.Buffer{}, 0, fmt.Errorf("error while opening image file %s: %v", file.FileName, err)
 	}
 	defer assetFile.Close()
 
 	assetImg, err := imaging.Decode(assetFile, imaging.AutoOrientation(true))
 	if err != nil {
-		return bytes.Buffer{}, fmt.Errorf("error while decoding image file %s: %v", file.FileName, err)
+		return bytes.Buffer{}, 0, fmt.Errorf("error while decoding image file %s: %v", file.FileName, err)
 	}
 
 	height := assetImg.Bounds().Dy()
@@ -172,10 +175,10 @@ func processImage(file *models.File, filePath string) (bytes.Buffer, error) {
 	// Encode the thumbImg to JPEG
 	var buf bytes.Buffer
 	if err := jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85}); err != nil {
-		return bytes.Buffer{}, fmt.Errorf("error while processing thumbnail: %s -> %v", file.FileName, err)
+		return bytes.Buffer{}, 0, fmt.Errorf("error while processing thumbnail: %s -> %v", file.FileName, err)
 	}
 
-	return buf, nil	
+	return buf, utils.DHash(thumbImg), nil	
 }
 
 func processVideo(filePath string, debug bool) (bytes.Buffer, error) {
@@ -275,11 +278,16 @@ func (ts *ThumbnailService) GenerateThumbnail(filePath string, file *models.File
 	}
 
 	if strings.HasPrefix(file.FileType, "image/") {
-		thumbnailBuf, err = processImage(file, filePath)
+		var perceptualHash uint64
+		thumbnailBuf, perceptualHash, err = processImage(file, filePath)
 		if err != nil {
 			log.Printf("Error while generating image thumbnail: %s -> %v\n", file.FileName, err)
 			return
 		}
+
+		if err := ts.DB.Model(&models.File{}).Where("id = ?", file.ID).Update("perceptual_hash", perceptualHash).Error; err != nil {
+			log.Printf("Error while saving perceptual hash: %s -> %v\n", file.FileName, err)
+		}
 	} else if strings.HasPrefix(file.FileType, "video/") {
 		thumbnailBuf, err = processVideo(filePath, false)
 		if err != nil {
@@ -300,6 +308,7 @@ func (ts *ThumbnailService) GenerateThumbnail(filePath string, file *models.File
 	thumbnail := models.Thumbnail{
 		FileID:   file.ID,
 		FilePath: thumbPath,
+		FileSize: uint(size),
 	}
 
 	if err := ts.DB.Create(&thumbnail).Error; err != nil {
@@ -328,7 +337,7 @@ func (ts *ThumbnailService) DeleteThumbnail(thumbnail *models.Thumbnail) error {
 		}
 	}	
 
-	if err := ts.BucketClient.RemoveObject(thumbnail.FilePath, minio.RemoveObjectOptions{}); err != nil {
+	if err := ts.BucketClient.RemoveServiceObject(thumbnail.FilePath, minio.RemoveObjectOptions{}); err != nil {
 		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
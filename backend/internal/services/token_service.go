@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// REVOCATION_CACHE_TTL is how long a token found valid is trusted before the denylist is read again.
+// Tokens revoked by another instance of the server may keep working that long.
+const REVOCATION_CACHE_TTL = 30 * time.Second
+
+type revocationEntry struct {
+	Revoked bool
+	Until   time.Time
+}
+
+// revocationCache remembers the denylist lookups of the access tokens in use, so most requests
+// don't hit the database.
+type revocationCache struct {
+	mu      sync.RWMutex
+	entries map[string]revocationEntry
+}
+
+var revokedTokens = &revocationCache{entries: map[string]revocationEntry{}}
+
+// closedSessions caches the session lookups of the access tokens the same way, keyed by
+// sessionKey. Revoked sessions are dropped from it so they stop working right away.
+var closedSessions = &revocationCache{entries: map[string]revocationEntry{}}
+
+func (rc *revocationCache) get(jti string) (bool, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	entry, ok := rc.entries[jti]
+	if !ok || time.Now().After(entry.Until) {
+		return false, false
+	}
+	return entry.Revoked, true
+}
+
+func (rc *revocationCache) set(jti string, revoked bool, until time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.entries[jti] = revocationEntry{Revoked: revoked, Until: until}
+}
+
+func (rc *revocationCache) drop(prefix string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for key := range rc.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+func (rc *revocationCache) prune() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+	pruned := 0
+	for jti, entry := range rc.entries {
+		if now.After(entry.Until) {
+			delete(rc.entries, jti)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// PruneRevocationCache drops the cached lookups that went stale. Expired revoked tokens are
+// pruned from the database by utils.PruneRevokedTokens.
+func PruneRevocationCache() int {
+	return revokedTokens.prune() + closedSessions.prune()
+}
+
+type TokenService struct {
+	DB *gorm.DB
+}
+
+func NewTokenService(db *gorm.DB) *TokenService {
+	return &TokenService{
+		DB: db,
+	}
+}
+
+// RevokeToken adds an access token to the denylist by its jti, until the token expires.
+//
+// If the token has no jti, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (ts *TokenService) RevokeToken(claims *utils.UserClaims) error {
+	if claims.Id == "" {
+		return &apperr.InvalidParamError{
+			BaseError: &apperr.BaseError{
+				Message: "Token has no ID",
+			},
+		}
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	token := models.Token{Token: claims.Id, ExpirationDate: expiresAt}
+	if err := ts.DB.Create(&token).Error; err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to revoke token",
+				Err:     err,
+			},
+		}
+	}
+
+	revokedTokens.set(claims.Id, true, expiresAt)
+	return nil
+}
+
+// IsTokenRevoked tells whether an access token is on the denylist. Lookups are cached, revoked
+// tokens until they expire and valid ones for REVOCATION_CACHE_TTL.
+//
+// If any errors occur, it returns a ServerError.
+func (ts *TokenService) IsTokenRevoked(jti string) (bool, error) {
+	if revoked, ok := revokedTokens.get(jti); ok {
+		return revoked, nil
+	}
+
+	var tokens []models.Token
+	if err := ts.DB.Where("token = ?", jti).Limit(1).Find(&tokens).Error; err != nil {
+		return false, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to check token",
+				Err:     err,
+			},
+		}
+	}
+
+	if len(tokens) > 0 {
+		revokedTokens.set(jti, true, tokens[0].ExpirationDate)
+		return true, nil
+	}
+
+	revokedTokens.set(jti, false, time.Now().Add(REVOCATION_CACHE_TTL))
+	return false, nil
+}
+
+// useToken marks a single-use token as used, within the transaction the token is used in.
+//
+// If the token was already used or has no jti, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func useToken(db *gorm.DB, claims *utils.PurposeClaims) error {
+	alreadyUsed := &apperr.InvalidCredentialsError{
+		BaseError: &apperr.BaseError{
+			Message: "Token already used",
+		},
+	}
+
+	if claims.Id == "" {
+		return alreadyUsed
+	}
+
+	err := db.Create(&models.UsedToken{ID: claims.Id, ExpiresAt: time.Unix(claims.ExpiresAt, 0)}).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return alreadyUsed
+	} else if err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to use token",
+				Err:     err,
+			},
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_TRASH_PURGE_CRON = "0 * * * *"
+)
+
+// TrashPurgeCronSpec returns when expired trashed items are purged, read from TRASH_PURGE_CRON.
+// It runs every hour by default.
+func TrashPurgeCronSpec() string {
+	if spec := os.Getenv("TRASH_PURGE_CRON"); spec != "" {
+		return spec
+	}
+	return DEFAULT_TRASH_PURGE_CRON
+}
+
+// DefaultTrashRetentionDays returns how long users without an override keep trashed items,
+// read from DEFAULT_TRASH_RETENTION_DAYS. A missing, invalid or zero value keeps them until
+// the trash is emptied.
+func DefaultTrashRetentionDays() uint {
+	days, err := strconv.ParseUint(os.Getenv("DEFAULT_TRASH_RETENTION_DAYS"), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(days)
+}
+
+// effectiveTrashRetention returns how many days a user keeps trashed items, 0 meaning forever.
+// An override of 0 keeps them forever for that user.
+func effectiveTrashRetention(user *models.User) uint {
+	if user.TrashRetentionDays != nil {
+		return *user.TrashRetentionDays
+	}
+	return DefaultTrashRetentionDays()
+}
+
+// trashDaysRemaining returns a function computing how many days a trashed item has left before
+// being purged, or nil when the user keeps trashed items until the trash is emptied.
+func trashDaysRemaining(db *gorm.DB, userID uint) (func(deletedAt gorm.DeletedAt) *int, error) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	days := effectiveTrashRetention(&user)
+	if days == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	return func(deletedAt gorm.DeletedAt) *int {
+		if !deletedAt.Valid {
+			return nil
+		}
+
+		remaining := int(math.Ceil(deletedAt.Time.AddDate(0, 0, int(days)).Sub(now).Hours() / 24))
+		remaining = max(remaining, 0)
+		return &remaining
+	}, nil
+}
+
+type TrashService struct {
+	DB           *gorm.DB
+	BucketClient *models.BucketClient
+}
+
+func (ts *TrashService) SetDB(db *gorm.DB) {
+	ts.DB = db
+}
+
+func (ts *TrashService) SetBucketClient(bc *models.BucketClient) {
+	ts.BucketClient = bc
+}
+
+func NewTrashService(db *gorm.DB, bc *models.BucketClient) *TrashService {
+	return &TrashService{
+		DB:           db,
+		BucketClient: bc,
+	}
+}
+
+func (ts *TrashService) findUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := ts.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "User not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch user",
+				Err:     err,
+			},
+		}
+	}
+
+	return &user, nil
+}
+
+func trashRetention(user *models.User) *models.TrashRetention {
+	retention := &models.TrashRetention{
+		IsDefault: user.TrashRetentionDays == nil,
+	}
+
+	if days := effectiveTrashRetention(user); days > 0 {
+		retention.Days = &days
+	}
+
+	return retention
+}
+
+// GetTrashRetention returns how long a user keeps trashed items before they are purged.
+//
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (ts *TrashService) GetTrashRetention(userID uint) (*models.TrashRetention, error) {
+	user, err := ts.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return trashRetention(user), nil
+}
+
+// SetTrashRetention overrides how long a user keeps trashed items. A nil days reverts the user
+// to the default retention, 0 keeps trashed items until the trash is emptied.
+//
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (ts *TrashService) SetTrashRetention(userID uint, days *uint) (*models.TrashRetention, error) {
+	user, err := ts.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ts.DB.Model(user).Update("trash_retention_days", days).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to update trash retention",
+				Err:     err,
+			},
+		}
+	}
+
+	user.TrashRetentionDays = days
+	return trashRetention(user), nil
+}
+
+// trashedFolderTree returns the IDs of the trashed folders of a user and of every folder inside them.
+func (ts *TrashService) trashedFolderTree(userID uint) (map[uint]bool, error) {
+	var ids []uint
+	err := ts.DB.Raw(`SELECT DISTINCT c.descendant_id FROM folder_closures c
+		JOIN folders a ON a.id = c.ancestor_id
+		WHERE a.user_id = ? AND a.deleted_at IS NOT NULL`, userID).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		tree[id] = true
+	}
+
+	return tree, nil
+}
+
+// ListTrash lists the trashed folders and files of a user together. Items inside a trashed folder
+// are left out, they are restored or purged along with it. Folders are all listed on the first
+// page, files are listed one page at a time.
+// When the user has a trash retention, each item has the days remaining before it's purged.
+//
+// If the params are invalid, it returns an InvalidParamError.
+// If other errors occur, it returns a ServerError.
+func (ts *TrashService) ListTrash(userID uint, params models.ListParams) (*models.TrashListResponse, error) {
+	tree, err := ts.trashedFolderTree(userID)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to list trashed folders",
+				Err:     err,
+			},
+		}
+	}
+
+	treeIDs := make([]uint, 0, len(tree))
+	for id := range tree {
+		treeIDs = append(treeIDs, id)
+	}
+
+	folders := []*models.Folder{}
+	if params.Cursor == "" && len(treeIDs) > 0 {
+		query := ts.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL AND id IN ?", userID, treeIDs).
+			Where("parent_id IS NULL OR parent_id NOT IN ?", treeIDs).
+			Order("deleted_at DESC, id DESC")
+		if err := query.Find(&folders).Error; err != nil {
+			return nil, &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to list trashed folders",
+					Err:     err,
+				},
+			}
+		}
+	}
+
+	query := ts.DB.Unscoped().Model(&models.File{}).Where("files.user_id = ? AND files.deleted_at IS NOT NULL", userID)
+	if len(treeIDs) > 0 {
+		query = query.Where("files.folder_id NOT IN ?", treeIDs)
+	}
+
+	files, err := paginateFiles(query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	daysRemaining, err := trashDaysRemaining(ts.DB, userID)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to fetch trash retention",
+				Err:     err,
+			},
+		}
+	}
+
+	if daysRemaining != nil {
+		for _, folder := range folders {
+			folder.TrashDaysRemaining = daysRemaining(folder.DeletedAt)
+		}
+		for _, file := range files.Files {
+			file.TrashDaysRemaining = daysRemaining(file.DeletedAt)
+		}
+	}
+
+	return &models.TrashListResponse{
+		Folders:          folders,
+		FileListResponse: *files,
+	}, nil
+}
+
+// EmptyTrash permanently deletes every trashed folder and file of a user, along with
+// everything inside the trashed folders.
+//
+// If an internal server error occurs, it returns a ServerError.
+func (ts *TrashService) EmptyTrash(userID uint) (*models.TrashPurgeResponse, error) {
+	purgedFolders, purgedFiles, err := ts.purgeUserTrash(userID, time.Now(), ts.BucketClient)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to empty trash",
+				Err:     err,
+			},
+		}
+	}
+
+	return &models.TrashPurgeResponse{
+		PurgedFolders: purgedFolders,
+		PurgedFiles:   purgedFiles,
+	}, nil
+}
+
+// PurgeExpiredTrash permanently deletes the files and folders that stayed in the trash longer
+// than the retention of their owner, the same way emptying the trash does.
+//
+// It is meant to run as a cron job, so errors are logged instead of returned.
+func (ts *TrashService) PurgeExpiredTrash(client *minio.Client) {
+	var users []models.User
+	if err := ts.DB.Find(&users).Error; err != nil {
+		log.Printf("Error while listing users to purge trash: %v\n", err)
+		return
+	}
+
+	for i := range users {
+		user := &users[i]
+
+		days := effectiveTrashRetention(user)
+		if days == 0 {
+			continue
+		}
+
+		// The cron job has no request to take a bucket client from, so one is made per user
+		bc := &models.BucketClient{
+			Context:       context.Background(),
+			Client:        client,
+			Bucket:        user.MinioBucket,
+			ServiceBucket: user.MinioServiceBucket,
+			SharedBucket:  SharedBucketName(),
+		}
+
+		purgedFolders, purgedFiles, err := ts.purgeUserTrash(user.ID, time.Now().AddDate(0, 0, -int(days)), bc)
+		if err != nil {
+			log.Printf("Error while purging trash of user %d: %v\n", user.ID, err)
+		}
+
+		if purgedFolders > 0 || purgedFiles > 0 {
+			log.Printf("Purged %d folders and %d files from the trash of user %d\n", purgedFolders, purgedFiles, user.ID)
+		}
+	}
+}
+
+// purgeUserTrash permanently deletes the folders and files a user trashed before cutoff.
+// Folders go first, so the files they hold aren't deleted one by one.
+// Items inside a purged folder are deleted along with it, so they are skipped afterwards.
+func (ts *TrashService) purgeUserTrash(userID uint, cutoff time.Time, bc *models.BucketClient) (int, int, error) {
+	var folders []models.Folder
+	if err := ts.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", userID, cutoff).Order("id ASC").Find(&folders).Error; err != nil {
+		return 0, 0, err
+	}
+
+	folderService := NewFolderService(ts.DB)
+	folderService.SetBucketClient(bc)
+
+	purgedFolders := 0
+	for _, folder := range folders {
+		if _, err := folderService.DeleteFolderPermanent(folder.Code, userID); err != nil {
+			if _, ok := err.(*apperr.NotFoundError); ok {
+				continue
+			}
+			return purgedFolders, 0, err
+		}
+		purgedFolders++
+	}
+
+	var files []models.File
+	if err := ts.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", userID, cutoff).Find(&files).Error; err != nil {
+		return purgedFolders, 0, err
+	}
+
+	fileService := NewFileService(ts.DB)
+	fileService.SetBucketClient(bc)
+
+	purgedFiles := 0
+	for _, file := range files {
+		if err := fileService.DeleteFilePermanent(userID, file.ID); err != nil {
+			if _, ok := err.(*apperr.NotFoundError); ok {
+				continue
+			}
+			return purgedFolders, purgedFiles, err
+		}
+		purgedFiles++
+	}
+
+	return purgedFolders, purgedFiles, nil
+}
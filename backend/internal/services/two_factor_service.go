@@ -0,0 +1,498 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TOTP_ISSUER                = "CloudChest"
+	TWO_FACTOR_CHALLENGE_TTL   = 5 * time.Minute
+	TWO_FACTOR_MAX_ATTEMPTS    = 5
+	TWO_FACTOR_LOCKOUT         = 5 * time.Minute
+	TWO_FACTOR_METHOD_TOTP     = "totp"
+	TWO_FACTOR_METHOD_WEBAUTHN = "webauthn"
+	RECOVERY_CODE_COUNT        = 10
+	RECOVERY_CODE_ALPHABET     = "abcdefghjkmnpqrstuvwxyz23456789"
+	RECOVERY_CODE_GROUP_LENGTH = 5
+)
+
+type TwoFactorService struct {
+	DB *gorm.DB
+}
+
+func NewTwoFactorService(db *gorm.DB) *TwoFactorService {
+	return &TwoFactorService{
+		DB: db,
+	}
+}
+
+// normalizeRecoveryCode lets recovery codes be typed in any case, with or without the dash.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCode returns a random code such as "k3tq8-x2mfa".
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, RECOVERY_CODE_GROUP_LENGTH*2)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	var code strings.Builder
+	for i, b := range raw {
+		if i == RECOVERY_CODE_GROUP_LENGTH {
+			code.WriteByte('-')
+		}
+		code.WriteByte(RECOVERY_CODE_ALPHABET[int(b)%len(RECOVERY_CODE_ALPHABET)])
+	}
+	return code.String(), nil
+}
+
+// replaceRecoveryCodes drops the recovery codes of a user and stores new ones, returned in clear
+// this one time.
+func replaceRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	recoveryCodes := make([]models.RecoveryCode, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+		recoveryCodes = append(recoveryCodes, models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)})
+	}
+
+	if err := db.Create(&recoveryCodes).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// findTwoFactor returns the TOTP setup of a user, nil when there's none.
+func findTwoFactor(db *gorm.DB, userID uint) (*models.TwoFactor, error) {
+	var twoFactors []models.TwoFactor
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&twoFactors).Error; err != nil {
+		return nil, err
+	}
+
+	if len(twoFactors) == 0 {
+		return nil, nil
+	}
+	return &twoFactors[0], nil
+}
+
+// IsEnabled tells whether a user logs in with a second factor.
+//
+// If any errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) IsEnabled(userID uint) (bool, error) {
+	twoFactor, err := findTwoFactor(tfs.DB, userID)
+	if err != nil {
+		return false, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	return twoFactor != nil && twoFactor.EnabledAt != nil, nil
+}
+
+// Setup starts the enrollment of a user with a new secret, to be added to an authenticator app
+// through its provisioning URI. It isn't used until Enable confirms it.
+//
+// If two-factor authentication is already enabled, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) Setup(userID uint) (*models.TwoFactorSetup, error) {
+	var user models.User
+	if err := tfs.DB.Select("id", "email").First(&user, userID).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	enabled, err := tfs.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		return nil, &apperr.ConflictError{
+			BaseError: &apperr.BaseError{
+				Message: "Two-factor authentication is already enabled",
+			},
+		}
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err == nil {
+		err = tfs.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.TwoFactor{UserID: userID, Secret: secret}).Error
+	}
+
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to set up two-factor authentication",
+				Err:     err,
+			},
+		}
+	}
+
+	return &models.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(TOTP_ISSUER, user.Email, secret),
+	}, nil
+}
+
+// Enable turns two-factor authentication on once the user gives a code of the secret from Setup.
+// It returns the recovery codes, which are only shown this once.
+//
+// If Setup wasn't done, it returns a NotFoundError.
+// If two-factor authentication is already enabled, it returns a ConflictError.
+// If the code is wrong, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) Enable(userID uint, code string) ([]string, error) {
+	var codes []string
+	err := tfs.DB.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := findTwoFactor(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+		if err != nil {
+			return err
+		}
+
+		if twoFactor == nil {
+			return &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Two-factor authentication isn't set up",
+				},
+			}
+		}
+
+		if twoFactor.EnabledAt != nil {
+			return &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "Two-factor authentication is already enabled",
+				},
+			}
+		}
+
+		step, ok := utils.ValidateTOTP(twoFactor.Secret, code, time.Now())
+		if !ok {
+			return &apperr.InvalidCredentialsError{
+				BaseError: &apperr.BaseError{
+					Message: "Invalid code",
+				},
+			}
+		}
+
+		err = tx.Model(twoFactor).Updates(map[string]interface{}{"enabled_at": time.Now(), "last_used_step": step}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+
+	if err != nil {
+		return nil, twoFactorError(err, "Failed to enable two-factor authentication")
+	}
+
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off, which takes the password of the user, or a recent
+// login from sessionID for users without one.
+//
+// If the password is wrong or the login too old, it returns an InvalidCredentialsError.
+// If two-factor authentication isn't enabled, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) Disable(userID, sessionID uint, password string) error {
+	if _, err := NewUserService(tfs.DB, nil).confirmUser(userID, sessionID, password); err != nil {
+		return err
+	}
+
+	err := tfs.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Two-factor authentication isn't enabled",
+				},
+			}
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+
+	if err != nil {
+		return twoFactorError(err, "Failed to disable two-factor authentication")
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, which takes their password, or a
+// recent login from sessionID for users without one.
+//
+// If the password is wrong or the login too old, it returns an InvalidCredentialsError.
+// If two-factor authentication isn't enabled, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) RegenerateRecoveryCodes(userID, sessionID uint, password string) ([]string, error) {
+	if _, err := NewUserService(tfs.DB, nil).confirmUser(userID, sessionID, password); err != nil {
+		return nil, err
+	}
+
+	enabled, err := tfs.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !enabled {
+		return nil, &apperr.NotFoundError{
+			BaseError: &apperr.BaseError{
+				Message: "Two-factor authentication isn't enabled",
+			},
+		}
+	}
+
+	var codes []string
+	err = tfs.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+
+	if err != nil {
+		return nil, twoFactorError(err, "Failed to regenerate recovery codes")
+	}
+
+	return codes, nil
+}
+
+// Methods lists the second factors a user can log in with: "totp" and "webauthn".
+// The list is empty when the user has no two-factor authentication.
+//
+// If any errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) Methods(userID uint) ([]string, error) {
+	methods := []string{}
+
+	enabled, err := tfs.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		methods = append(methods, TWO_FACTOR_METHOD_TOTP)
+	}
+
+	hasCredentials, err := NewWebAuthnService(tfs.DB).HasCredentials(userID)
+	if err != nil {
+		return nil, twoFactorError(err, "Internal server error ocurred")
+	}
+	if hasCredentials {
+		methods = append(methods, TWO_FACTOR_METHOD_WEBAUTHN)
+	}
+
+	return methods, nil
+}
+
+// NewChallenge returns the short-lived token a login gets after the password, to be sent back
+// along with the second factor.
+func (tfs *TwoFactorService) NewChallenge(userID uint) (string, error) {
+	token, err := utils.GeneratePurposeToken(utils.TOKEN_PURPOSE_TWO_FACTOR, userID, "", TWO_FACTOR_CHALLENGE_TTL)
+	if err != nil {
+		return "", &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	return token, nil
+}
+
+// ParseChallenge returns the ID of the user a challenge token was issued to.
+//
+// If the challenge token is invalid or expired, it returns an InvalidCredentialsError.
+func (tfs *TwoFactorService) ParseChallenge(challengeToken string) (uint, error) {
+	claims, err := parseChallenge(challengeToken)
+	if err != nil {
+		return 0, err
+	}
+
+	return claims.UserID, nil
+}
+
+func parseChallenge(challengeToken string) (*utils.PurposeClaims, error) {
+	claims, err := utils.ParsePurposeToken(challengeToken, utils.TOKEN_PURPOSE_TWO_FACTOR)
+	if err != nil {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid challenge token",
+				Err:     err,
+			},
+		}
+	}
+
+	return claims, nil
+}
+
+// VerifyChallenge checks the second factor of a login: a TOTP code, or else an unused recovery code,
+// which is used up. After TWO_FACTOR_MAX_ATTEMPTS wrong codes, the user is locked out for a while.
+// The challenge token is used up once the code is right. It returns the ID of the user logging in.
+//
+// If the challenge token or the code is invalid, or the user is locked out, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) VerifyChallenge(challengeToken, code string) (uint, error) {
+	claims, err := parseChallenge(challengeToken)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tfs.checkCode(claims.UserID, code, func(tx *gorm.DB) error {
+		return useToken(tx, claims)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return claims.UserID, nil
+}
+
+// Reauthenticate confirms a sensitive change, such as adding or removing a security key, with a code
+// of the second factor of the user, or else their password or a recent login from sessionID for
+// users without one.
+//
+// If the password or the code is wrong, or the login too old, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (tfs *TwoFactorService) Reauthenticate(userID, sessionID uint, body models.ReauthBody) error {
+	if body.Code != "" {
+		return tfs.checkCode(userID, body.Code, nil)
+	}
+
+	_, err := NewUserService(tfs.DB, nil).confirmUser(userID, sessionID, body.Password)
+	return err
+}
+
+// checkCode checks a TOTP code, or else an unused recovery code, which is used up. use is called
+// in the same transaction once the code is right. After TWO_FACTOR_MAX_ATTEMPTS wrong codes, the
+// user is locked out for a while.
+func (tfs *TwoFactorService) checkCode(userID uint, code string, use func(tx *gorm.DB) error) error {
+	invalidCode := &apperr.InvalidCredentialsError{
+		BaseError: &apperr.BaseError{
+			Message: "Invalid code",
+		},
+	}
+	failed := false
+
+	err := tfs.DB.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := findTwoFactor(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+		if err != nil {
+			return err
+		}
+
+		if twoFactor == nil || twoFactor.EnabledAt == nil {
+			return invalidCode
+		}
+
+		now := time.Now()
+		if twoFactor.LockedUntil != nil && now.Before(*twoFactor.LockedUntil) {
+			return &apperr.InvalidCredentialsError{
+				BaseError: &apperr.BaseError{
+					Message: "Too many invalid codes, try again later",
+				},
+			}
+		}
+
+		if step, ok := utils.ValidateTOTP(twoFactor.Secret, code, now); ok && step > twoFactor.LastUsedStep {
+			if use != nil {
+				if err := use(tx); err != nil {
+					return err
+				}
+			}
+
+			return tx.Model(twoFactor).Updates(map[string]interface{}{
+				"last_used_step": step, "failed_attempts": 0, "locked_until": nil,
+			}).Error
+		}
+
+		result := tx.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected > 0 {
+			if use != nil {
+				if err := use(tx); err != nil {
+					return err
+				}
+			}
+
+			return tx.Model(twoFactor).Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error
+		}
+
+		// The failure has to be committed, the error is returned after the transaction
+		failed = true
+		updates := map[string]interface{}{"failed_attempts": twoFactor.FailedAttempts + 1}
+		if twoFactor.FailedAttempts+1 >= TWO_FACTOR_MAX_ATTEMPTS {
+			updates["failed_attempts"] = 0
+			updates["locked_until"] = now.Add(TWO_FACTOR_LOCKOUT)
+		}
+		return tx.Model(twoFactor).Updates(updates).Error
+	})
+
+	if err != nil {
+		return twoFactorError(err, "Failed to verify code")
+	}
+
+	if failed {
+		return invalidCode
+	}
+
+	return nil
+}
+
+// twoFactorError passes the errors of the service through and turns the others into a ServerError.
+func twoFactorError(err error, message string) error {
+	switch err.(type) {
+	case *apperr.NotFoundError, *apperr.ConflictError, *apperr.InvalidCredentialsError, *apperr.ServerError:
+		return err
+	}
+
+	return &apperr.ServerError{
+		BaseError: &apperr.BaseError{
+			Message: message,
+			Err:     err,
+		},
+	}
+}
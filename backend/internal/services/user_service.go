@@ -2,9 +2,19 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/mailer"
 	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
 	"github.com/gofrs/uuid/v5"
 	"github.com/minio/minio-go/v7"
@@ -14,6 +24,7 @@ import (
 type UserService struct {
 	DB *gorm.DB
 	MinioClient *minio.Client
+	Mailer mailer.Mailer
 }
 
 func (us *UserService) SetDB(db *gorm.DB) {
@@ -31,9 +42,17 @@ func NewUserService(db *gorm.DB, mc *minio.Client) *UserService {
 	}
 }
 
-func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, error) {
-	ctx := context.Background()
+// isAdminEmail reports whether the email is listed in ADMIN_EMAILS (comma separated).
+func isAdminEmail(email string) bool {
+	for _, adminEmail := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if adminEmail = strings.TrimSpace(adminEmail); adminEmail != "" && strings.EqualFold(adminEmail, email) {
+			return true
+		}
+	}
+	return false
+}
 
+func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, error) {
 	hashedPassword, err := utils.HashPassword(userBody.Password)
 
 	if err != nil {
@@ -45,10 +64,44 @@ func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, erro
 		}
 	}
 
+	user := models.User{
+		FirstName: userBody.FirstName,
+		LastName: userBody.LastName,
+		Email: userBody.Email,
+		Password: hashedPassword,
+	}
+
+	// Administrators get their role by verifying their email, whether or not others have to
+	if !RequireEmailVerification() && !isAdminEmail(user.Email) {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := us.provisionUser(&user); err != nil {
+		return nil, err
+	}
+
+	// A registration isn't undone when the email can't be sent, it can be sent again
+	if user.EmailVerifiedAt == nil {
+		if err := us.sendVerificationEmail(&user); err != nil {
+			log.Printf("Failed to send the verification email of user %d: %v\n", user.ID, err)
+		}
+	}
+
+	return &user, nil
+}
+
+// provisionUser creates a new user with their buckets and root folder. Users are given the user
+// role unless they come with one.
+//
+// If any errors occur, it returns a ServerError.
+func (us *UserService) provisionUser(user *models.User) error {
+	ctx := context.Background()
+
 	// CREATE MINIO BUCKET
 	bucketName, err := uuid.NewV4()
 	if err != nil {
-		return nil, &apperr.ServerError{
+		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
 				Err: err,
@@ -58,7 +111,7 @@ func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, erro
 
 	serviceBucketName, err := uuid.NewV4()
 	if err != nil {
-		return nil, &apperr.ServerError{
+		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
 				Err: err,
@@ -71,7 +124,7 @@ func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, erro
 		Region: "us-east-1",
 	})
 	if err != nil {
-		return nil, &apperr.ServerError{
+		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
 				Err: err,
@@ -84,7 +137,7 @@ func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, erro
 		Region: "us-east-1",
 	})
 	if err != nil {
-		return nil, &apperr.ServerError{
+		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
 				Err: err,
@@ -95,21 +148,26 @@ func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, erro
 	rootFolder := models.Folder{
 		Name: "/",
 	}
-	user := models.User{
-		FirstName: userBody.FirstName,
-		LastName: userBody.LastName,
-		Email: userBody.Email,
-		Password: hashedPassword,
-		MinioBucket: bucketName.String(),
-		MinioServiceBucket: serviceBucketName.String(),
-		Folders: []*models.Folder{
-			&rootFolder,
-		},
+	if user.AuthSource == "" {
+		user.AuthSource = models.AUTH_SOURCE_LOCAL
+	}
+	user.MinioBucket = bucketName.String()
+	user.MinioServiceBucket = serviceBucketName.String()
+	if user.Role == "" {
+		user.Role = models.ROLE_USER
+	}
+	user.Folders = []*models.Folder{
+		&rootFolder,
 	}
 
-	err = us.DB.Create(&user).Error
+	err = us.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return insertFolderClosure(tx, &rootFolder)
+	})
 	if err != nil {
-		return nil, &apperr.ServerError{
+		return &apperr.ServerError{
 			BaseError: &apperr.BaseError{
 				Message: "Internal server error ocurred",
 				Err: err,
@@ -117,5 +175,702 @@ func (us *UserService) CreateUser(userBody *models.UserBody) (*models.User, erro
 		}
 	}
 
+	return nil
+}
+const (
+	DEFAULT_ACCOUNT_DELETION_GRACE_DAYS = 7
+	DEFAULT_ACCOUNT_PURGE_CRON          = "15 * * * *"
+	EMAIL_CHANGE_TOKEN_TTL              = 24 * time.Hour
+	EMAIL_VERIFICATION_TOKEN_TTL        = 48 * time.Hour
+	PASSWORD_RESET_TOKEN_TTL            = time.Hour
+	DEFAULT_APP_URL                     = "http://localhost:8080"
+	REAUTH_MAX_AGE                      = 10 * time.Minute
+)
+
+// RequireEmailVerification tells whether users must verify their email before their first login,
+// read from REQUIRE_EMAIL_VERIFICATION.
+func RequireEmailVerification() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// AppURL returns the URL of the frontend the links in emails point to, read from APP_URL.
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return DEFAULT_APP_URL
+}
+
+func (us *UserService) mailer() mailer.Mailer {
+	if us.Mailer == nil {
+		return &mailer.LogMailer{}
+	}
+	return us.Mailer
+}
+
+// passwordFingerprint ties a password reset token to the password it resets, so the token stops
+// working once it has been used.
+func passwordFingerprint(hashedPassword string) string {
+	sum := sha256.Sum256([]byte(hashedPassword))
+	return hex.EncodeToString(sum[:8])
+}
+
+// AccountDeletionGraceDays returns how many days a deleted account is kept before its data is erased,
+// read from ACCOUNT_DELETION_GRACE_DAYS. It is 7 days by default.
+func AccountDeletionGraceDays() int {
+	days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		return DEFAULT_ACCOUNT_DELETION_GRACE_DAYS
+	}
+	return days
+}
+
+// AccountPurgeCronSpec returns when the accounts past their grace period are erased, read from
+// ACCOUNT_PURGE_CRON. It runs every hour by default.
+func AccountPurgeCronSpec() string {
+	if spec := os.Getenv("ACCOUNT_PURGE_CRON"); spec != "" {
+		return spec
+	}
+	return DEFAULT_ACCOUNT_PURGE_CRON
+}
+
+func (us *UserService) findUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := us.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "User not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
 	return &user, nil
-}
\ No newline at end of file
+}
+
+// emailTaken reports whether an account other than the given user uses an email, deleted accounts
+// waiting to be erased included.
+func (us *UserService) emailTaken(email string, exceptID uint) (bool, error) {
+	var count int64
+	err := us.DB.Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", email, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+// sendEmailChangeToken sends the token confirming an email change to the new address.
+func (us *UserService) sendEmailChangeToken(user *models.User, email string) error {
+	token, err := utils.GeneratePurposeToken(utils.TOKEN_PURPOSE_EMAIL_CHANGE, user.ID, email, EMAIL_CHANGE_TOKEN_TTL)
+	if err != nil {
+		return err
+	}
+
+	return us.mailer().Send(mailer.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to use this address for your account:\n%s/verify-email-change?token=%s\n\nThe link expires in %s. If you didn't ask for this change, ignore this email.\n",
+			user.FirstName, AppURL(), token, EMAIL_CHANGE_TOKEN_TTL),
+	})
+}
+
+// UpdateUser updates the profile of a user. A new email address isn't used right away, it's kept as
+// pending until the user confirms it with the token sent to that address.
+//
+// If the user is not found, it returns a NotFoundError.
+// If the email is used by another account, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (us *UserService) UpdateUser(userID uint, body models.UserUpdateBody) (*models.User, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if body.FirstName != nil {
+		updates["first_name"] = *body.FirstName
+	}
+	if body.LastName != nil {
+		updates["last_name"] = *body.LastName
+	}
+
+	newEmail := ""
+	if body.Email != nil && !strings.EqualFold(*body.Email, user.Email) {
+		newEmail = *body.Email
+
+		taken, err := us.emailTaken(newEmail, user.ID)
+		if err != nil {
+			return nil, &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to update user",
+					Err:     err,
+				},
+			}
+		}
+
+		if taken {
+			return nil, &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "Email is already used by another account",
+				},
+			}
+		}
+
+		updates["pending_email"] = newEmail
+	}
+
+	if len(updates) > 0 {
+		if err := us.DB.Model(user).Updates(updates).Error; err != nil {
+			return nil, &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to update user",
+					Err:     err,
+				},
+			}
+		}
+	}
+
+	if newEmail != "" {
+		if err := us.sendEmailChangeToken(user, newEmail); err != nil {
+			return nil, &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to send email verification",
+					Err:     err,
+				},
+			}
+		}
+	}
+
+	return us.findUser(userID)
+}
+
+// ConfirmEmailChange switches a user to their pending email, once they prove they own it with the
+// token sent by UpdateUser.
+//
+// If the token is invalid, expired or not for the pending email, it returns an InvalidCredentialsError.
+// If the email got used by another account in the meantime, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (us *UserService) ConfirmEmailChange(token string) (*models.User, error) {
+	claims, err := utils.ParsePurposeToken(token, utils.TOKEN_PURPOSE_EMAIL_CHANGE)
+	if err != nil {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid token",
+				Err:     err,
+			},
+		}
+	}
+
+	user, err := us.findUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.PendingEmail == nil || *user.PendingEmail != claims.Value {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid token",
+			},
+		}
+	}
+
+	err = us.DB.Model(user).Updates(map[string]interface{}{"email": claims.Value, "pending_email": nil, "email_verified_at": time.Now()}).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "Email is already used by another account",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to update user",
+				Err:     err,
+			},
+		}
+	}
+
+	return us.findUser(user.ID)
+}
+
+// checkUserPassword loads a user and checks their password, before a sensitive change.
+func (us *UserService) checkUserPassword(userID uint, password string) (*models.User, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !utils.CheckPassword(password, user.Password) {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid credentials",
+			},
+		}
+	}
+
+	return user, nil
+}
+
+// hasPassword tells whether a user logs in with a password checked here, rather than with single
+// sign-on or against the directory.
+func hasPassword(user *models.User) bool {
+	return user.AuthSource == models.AUTH_SOURCE_LOCAL && user.Password != ""
+}
+
+// confirmUser loads a user and confirms a sensitive change with their password. Users without a
+// password here confirm it by logging in again with their provider or the directory: the session the
+// request is made from must have been opened less than REAUTH_MAX_AGE ago.
+func (us *UserService) confirmUser(userID, sessionID uint, password string) (*models.User, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasPassword(user) {
+		return us.checkUserPassword(userID, password)
+	}
+
+	var sessions []models.Session
+	err = us.DB.Select("id", "created_at").
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Limit(1).Find(&sessions).Error
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	if len(sessions) == 0 || time.Since(sessions[0].CreatedAt) > REAUTH_MAX_AGE {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Log in again to confirm this change",
+			},
+		}
+	}
+
+	return user, nil
+}
+
+// ChangePassword changes the password of a user, who must give the current one. Every session and
+// personal access token of the user is revoked, so other devices have to log in with the new password.
+//
+// If the user is not found, it returns a NotFoundError.
+// If the current password is wrong, it returns an InvalidCredentialsError.
+// If the password is managed by the directory, it returns a ForbiddenError.
+// If other errors occur, it returns a ServerError.
+func (us *UserService) ChangePassword(userID uint, body models.PasswordChangeBody) (*models.User, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.AuthSource != models.AUTH_SOURCE_LOCAL {
+		return nil, &apperr.ForbiddenError{
+			BaseError: &apperr.BaseError{
+				Message: "The password is managed by the directory",
+			},
+		}
+	}
+
+	user, err = us.checkUserPassword(userID, body.CurrentPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := utils.HashPassword(body.NewPassword)
+	if err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	if err := us.DB.Model(user).Update("password", hashedPassword).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to change password",
+				Err:     err,
+			},
+		}
+	}
+
+	if _, err := NewSessionService(us.DB).RevokeAllSessions(user.ID); err != nil {
+		return nil, err
+	}
+
+	if _, err := NewPersonalAccessTokenService(us.DB).RevokeAllTokens(user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// DeleteUser deletes the account of a user, who must give their password or, without one, have
+// logged in recently. The account is closed right away and every session revoked, its files and
+// buckets are erased by PurgeDeletedUsers once the grace period is over.
+//
+// If the user is not found, it returns a NotFoundError.
+// If the password is wrong or the login too old, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (us *UserService) DeleteUser(userID, sessionID uint, password string) error {
+	user, err := us.confirmUser(userID, sessionID, password)
+	if err != nil {
+		return err
+	}
+
+	if err := us.DB.Delete(user).Error; err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to delete user",
+				Err:     err,
+			},
+		}
+	}
+
+	if _, err := NewSessionService(us.DB).RevokeAllSessions(user.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers erases the accounts deleted longer than the grace period ago: their files and
+// folders, both of their buckets and every row they own.
+//
+// It is meant to run as a cron job, so errors are logged instead of returned.
+func (us *UserService) PurgeDeletedUsers() {
+	cutoff := time.Now().AddDate(0, 0, -AccountDeletionGraceDays())
+
+	var users []models.User
+	if err := us.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&users).Error; err != nil {
+		log.Printf("Error while listing deleted users to purge: %v\n", err)
+		return
+	}
+
+	for i := range users {
+		if err := us.purgeUser(&users[i]); err != nil {
+			log.Printf("Error while purging user %d: %v\n", users[i].ID, err)
+			continue
+		}
+
+		log.Printf("Purged deleted user %d\n", users[i].ID)
+	}
+}
+
+// purgeUser erases a deleted account. Files are deleted one by one first, so the deduplicated
+// objects they share with other users are released properly.
+func (us *UserService) purgeUser(user *models.User) error {
+	bc := &models.BucketClient{
+		Context:       context.Background(),
+		Client:        us.MinioClient,
+		Bucket:        user.MinioBucket,
+		ServiceBucket: user.MinioServiceBucket,
+		SharedBucket:  SharedBucketName(),
+	}
+
+	// Tag links go before the files they link, whether or not file_tags cascades deletes
+	if err := us.DB.Exec("DELETE FROM file_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)", user.ID).Error; err != nil {
+		return err
+	}
+
+	var rootFolders []models.Folder
+	if err := us.DB.Unscoped().Where("user_id = ? AND parent_id IS NULL", user.ID).Find(&rootFolders).Error; err != nil {
+		return err
+	}
+
+	folderService := NewFolderService(us.DB)
+	folderService.SetBucketClient(bc)
+	for _, rootFolder := range rootFolders {
+		if _, err := folderService.DeleteFolderPermanent(rootFolder.Code, user.ID); err != nil {
+			return err
+		}
+	}
+
+	// Files left out of the folder tree, if any
+	var files []models.File
+	if err := us.DB.Unscoped().Select("id").Where("user_id = ?", user.ID).Find(&files).Error; err != nil {
+		return err
+	}
+
+	fileService := NewFileService(us.DB)
+	fileService.SetBucketClient(bc)
+	for _, file := range files {
+		if err := fileService.DeleteFilePermanent(user.ID, file.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, bucket := range []string{user.MinioBucket, user.MinioServiceBucket} {
+		if err := us.removeBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	return us.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Tag{}, &models.SmartFolder{}, &models.BatchJob{}, &models.Folder{}} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		// Sessions, second factors, security keys, access tokens and linked identities go along with the user
+		return tx.Unscoped().Delete(user).Error
+	})
+}
+
+// removeBucket removes a bucket and every object left in it. A bucket already gone is fine.
+func (us *UserService) removeBucket(bucket string) error {
+	ctx := context.Background()
+
+	exists, err := us.MinioClient.BucketExists(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	objectsCh := make(chan minio.ObjectInfo)
+
+	go func() {
+		defer close(objectsCh)
+		for object := range us.MinioClient.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+			if object.Err != nil {
+				log.Printf("Error listing objects of bucket %s: %v\n", bucket, object.Err)
+				continue
+			}
+			objectsCh <- object
+		}
+	}()
+
+	for rErr := range us.MinioClient.RemoveObjects(ctx, bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+		if rErr.Err != nil {
+			return rErr.Err
+		}
+	}
+
+	return us.MinioClient.RemoveBucket(ctx, bucket)
+}
+
+// sendVerificationEmail sends a user the link verifying their email address.
+func (us *UserService) sendVerificationEmail(user *models.User) error {
+	token, err := utils.GeneratePurposeToken(utils.TOKEN_PURPOSE_EMAIL_VERIFICATION, user.ID, user.Email, EMAIL_VERIFICATION_TOKEN_TTL)
+	if err != nil {
+		return err
+	}
+
+	return us.mailer().Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to verify your email address:\n%s/verify-email?token=%s\n\nThe link expires in %s.\n",
+			user.FirstName, AppURL(), token, EMAIL_VERIFICATION_TOKEN_TTL),
+	})
+}
+
+// VerifyEmail marks the email of a user as verified, with the token sent at registration. Users
+// listed in ADMIN_EMAILS become administrators then.
+//
+// If the token is invalid, expired or for another email, it returns an InvalidCredentialsError.
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (us *UserService) VerifyEmail(token string) (*models.User, error) {
+	claims, err := utils.ParsePurposeToken(token, utils.TOKEN_PURPOSE_EMAIL_VERIFICATION)
+	if err != nil {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid token",
+				Err:     err,
+			},
+		}
+	}
+
+	user, err := us.findUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Email != claims.Value {
+		return nil, &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid token",
+			},
+		}
+	}
+
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if isAdminEmail(user.Email) {
+			user.Role = models.ROLE_ADMIN
+		}
+
+		if err := us.DB.Model(user).Select("email_verified_at", "role").Updates(user).Error; err != nil {
+			return nil, &apperr.ServerError{
+				BaseError: &apperr.BaseError{
+					Message: "Failed to verify email",
+					Err:     err,
+				},
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// findUserByEmail looks a user up for the email flows. A missing user isn't an error, these flows
+// don't tell whether an email is registered.
+func (us *UserService) findUserByEmail(email string) (*models.User, error) {
+	var users []models.User
+	if err := us.DB.Where("email = ?", email).Limit(1).Find(&users).Error; err != nil {
+		return nil, &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+// ResendVerificationEmail sends the verification link again. Nothing is sent when the email is
+// unknown or already verified, without telling the caller.
+//
+// If any errors occur, it returns a ServerError.
+func (us *UserService) ResendVerificationEmail(email string) error {
+	user, err := us.findUserByEmail(email)
+	if err != nil || user == nil || user.EmailVerifiedAt != nil {
+		return err
+	}
+
+	if err := us.sendVerificationEmail(user); err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to send email",
+				Err:     err,
+			},
+		}
+	}
+
+	return nil
+}
+
+// RequestPasswordReset sends a user a link to reset their password. Nothing is sent when the email
+// is unknown, without telling the caller.
+//
+// If any errors occur, it returns a ServerError.
+func (us *UserService) RequestPasswordReset(email string) error {
+	user, err := us.findUserByEmail(email)
+	if err != nil || user == nil {
+		return err
+	}
+
+	// Directory users reset their password there, answering the same keeps emails from being probed
+	if user.AuthSource != models.AUTH_SOURCE_LOCAL {
+		return nil
+	}
+
+	token, err := utils.GeneratePurposeToken(utils.TOKEN_PURPOSE_PASSWORD_RESET, user.ID, passwordFingerprint(user.Password), PASSWORD_RESET_TOKEN_TTL)
+	if err == nil {
+		err = us.mailer().Send(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hello %s,\n\nOpen this link to choose a new password:\n%s/reset-password?token=%s\n\nThe link expires in %s. If you didn't ask for a new password, ignore this email.\n",
+				user.FirstName, AppURL(), token, PASSWORD_RESET_TOKEN_TTL),
+		})
+	}
+
+	if err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to send email",
+				Err:     err,
+			},
+		}
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password with the token sent by RequestPasswordReset. The token works
+// once, and every session and personal access token of the user is revoked. The email is verified along the way, since the
+// user got the token through it.
+//
+// If the token is invalid, expired or already used, it returns an InvalidCredentialsError.
+// If the user is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (us *UserService) ResetPassword(body models.PasswordResetBody) error {
+	claims, err := utils.ParsePurposeToken(body.Token, utils.TOKEN_PURPOSE_PASSWORD_RESET)
+	if err != nil {
+		return &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid token",
+				Err:     err,
+			},
+		}
+	}
+
+	user, err := us.findUser(claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	if passwordFingerprint(user.Password) != claims.Value {
+		return &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Invalid token",
+			},
+		}
+	}
+
+	hashedPassword, err := utils.HashPassword(body.NewPassword)
+	if err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Internal server error ocurred",
+				Err:     err,
+			},
+		}
+	}
+
+	updates := map[string]interface{}{"password": hashedPassword}
+	if user.EmailVerifiedAt == nil {
+		updates["email_verified_at"] = time.Now()
+	}
+
+	if err := us.DB.Model(user).Updates(updates).Error; err != nil {
+		return &apperr.ServerError{
+			BaseError: &apperr.BaseError{
+				Message: "Failed to reset password",
+				Err:     err,
+			},
+		}
+	}
+
+	if _, err := NewSessionService(us.DB).RevokeAllSessions(user.ID); err != nil {
+		return err
+	}
+
+	if _, err := NewPersonalAccessTokenService(us.DB).RevokeAllTokens(user.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
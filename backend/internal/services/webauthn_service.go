@@ -0,0 +1,533 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/internal/models"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/apperr"
+	"github.com/DuckOfTheBooBoo/web-gallery-app/backend/pkg/utils"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+const (
+	WEBAUTHN_RP_DISPLAY_NAME = "CloudChest"
+	WEBAUTHN_CEREMONY_TTL    = 5 * time.Minute
+	WEBAUTHN_HANDLE_SIZE     = 32
+	DEFAULT_CREDENTIAL_NAME  = "Security key"
+)
+
+// WebAuthnConfig returns the relying party passkeys are bound to. The ID is read from WEBAUTHN_RP_ID
+// and the allowed origins from WEBAUTHN_RP_ORIGINS (comma separated), both default to APP_URL.
+func WebAuthnConfig() *webauthn.Config {
+	origins := []string{}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = append(origins, AppURL())
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		if appURL, err := url.Parse(AppURL()); err == nil {
+			rpID = appURL.Hostname()
+		}
+	}
+
+	return &webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: WEBAUTHN_RP_DISPLAY_NAME,
+		RPOrigins:     origins,
+	}
+}
+
+// webAuthnUser is a user as the WebAuthn library sees it.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.WebAuthnHandle
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return strings.TrimSpace(u.user.FirstName + " " + u.user.LastName)
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, credential := range u.credentials {
+		credentials = append(credentials, credential.Credential)
+	}
+	return credentials
+}
+
+func hashCredentialID(id []byte) string {
+	sum := sha256.Sum256(id)
+	return hex.EncodeToString(sum[:])
+}
+
+type WebAuthnService struct {
+	DB *gorm.DB
+}
+
+func NewWebAuthnService(db *gorm.DB) *WebAuthnService {
+	return &WebAuthnService{
+		DB: db,
+	}
+}
+
+func (ws *WebAuthnService) relyingParty() (*webauthn.WebAuthn, error) {
+	return webauthn.New(WebAuthnConfig())
+}
+
+// loadUser loads the user a query finds, with their credentials.
+func (ws *WebAuthnService) loadUser(query *gorm.DB) (*webAuthnUser, error) {
+	var user models.User
+	if err := query.First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	credentials := []models.WebAuthnCredential{}
+	if err := ws.DB.Where("user_id = ?", user.ID).Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{user: &user, credentials: credentials}, nil
+}
+
+// newCeremony signs the session data of a ceremony into the token the browser sends back with its result.
+func newCeremony(userID uint, options interface{}, session *webauthn.SessionData) (*models.WebAuthnCeremony, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GeneratePurposeToken(utils.TOKEN_PURPOSE_WEBAUTHN, userID, string(data), WEBAUTHN_CEREMONY_TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnCeremony{Options: options, CeremonyToken: token}, nil
+}
+
+// parseCeremony returns the session data of a ceremony, which must have been started for the user.
+// The ceremony token is used up, so its challenge can't be answered twice.
+func parseCeremony(db *gorm.DB, token string, userID uint) (*webauthn.SessionData, error) {
+	invalidErr := &apperr.InvalidCredentialsError{
+		BaseError: &apperr.BaseError{
+			Message: "Invalid ceremony token",
+		},
+	}
+
+	claims, err := utils.ParsePurposeToken(token, utils.TOKEN_PURPOSE_WEBAUTHN)
+	if err != nil || claims.UserID != userID {
+		return nil, invalidErr
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(claims.Value), &session); err != nil {
+		return nil, invalidErr
+	}
+
+	if err := useToken(db, claims); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// webAuthnError passes the errors of the service through. Failed ceremonies become an
+// InvalidCredentialsError, anything else a ServerError.
+func webAuthnError(err error, message string) error {
+	switch err.(type) {
+	case *apperr.NotFoundError, *apperr.InvalidCredentialsError, *apperr.ServerError:
+		return err
+	}
+
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		return &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: message,
+				Err:     errors.New(protocolErr.Details),
+			},
+		}
+	}
+
+	return &apperr.ServerError{
+		BaseError: &apperr.BaseError{
+			Message: message,
+			Err:     err,
+		},
+	}
+}
+
+// BeginRegistration starts adding a hardware key or passkey to a user. Discoverable credentials are
+// preferred, so the key can also log in without a password.
+// The caller confirms the user first, the ceremony token then stands for that confirmation.
+//
+// If any errors occur, it returns a ServerError.
+func (ws *WebAuthnService) BeginRegistration(userID uint) (*models.WebAuthnCeremony, error) {
+	user, err := ws.loadUser(ws.DB.Where("id = ?", userID))
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start registration")
+	}
+
+	if len(user.user.WebAuthnHandle) == 0 {
+		handle := make([]byte, WEBAUTHN_HANDLE_SIZE)
+		if _, err := rand.Read(handle); err != nil {
+			return nil, webAuthnError(err, "Failed to start registration")
+		}
+
+		if err := ws.DB.Model(user.user).Update("web_authn_handle", handle).Error; err != nil {
+			return nil, webAuthnError(err, "Failed to start registration")
+		}
+		user.user.WebAuthnHandle = handle
+	}
+
+	relyingParty, err := ws.relyingParty()
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start registration")
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Credential.Descriptor())
+	}
+
+	options, session, err := relyingParty.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start registration")
+	}
+
+	ceremony, err := newCeremony(userID, options, session)
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start registration")
+	}
+
+	return ceremony, nil
+}
+
+// FinishRegistration checks the credential the browser created and stores it for the user.
+//
+// If the ceremony token or the credential is invalid, it returns an InvalidCredentialsError.
+// If the credential is already registered, it returns a ConflictError.
+// If other errors occur, it returns a ServerError.
+func (ws *WebAuthnService) FinishRegistration(userID uint, body models.WebAuthnRegistrationBody) (*models.WebAuthnCredential, error) {
+	session, err := parseCeremony(ws.DB, body.CeremonyToken, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ws.loadUser(ws.DB.Where("id = ?", userID))
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to register credential")
+	}
+
+	relyingParty, err := ws.relyingParty()
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to register credential")
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body.Credential))
+	if err != nil {
+		return nil, webAuthnError(err, "Invalid credential")
+	}
+
+	credential, err := relyingParty.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return nil, webAuthnError(err, "Invalid credential")
+	}
+
+	name := body.Name
+	if name == "" {
+		name = DEFAULT_CREDENTIAL_NAME
+	}
+
+	discoverable := false
+	if parsed.ClientExtensionResults != nil {
+		if credProps, ok := parsed.ClientExtensionResults["credProps"].(map[string]interface{}); ok {
+			discoverable, _ = credProps["rk"].(bool)
+		}
+	}
+
+	newCredential := models.WebAuthnCredential{
+		UserID:         userID,
+		Name:           name,
+		CredentialHash: hashCredentialID(credential.ID),
+		Credential:     *credential,
+		Discoverable:   discoverable,
+	}
+
+	if err := ws.DB.Create(&newCredential).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, &apperr.ConflictError{
+				BaseError: &apperr.BaseError{
+					Message: "Credential already registered",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, webAuthnError(err, "Failed to register credential")
+	}
+
+	return &newCredential, nil
+}
+
+// BeginLogin starts a passwordless login with a passkey, the browser offers the passkeys it has
+// for this site.
+//
+// If any errors occur, it returns a ServerError.
+func (ws *WebAuthnService) BeginLogin() (*models.WebAuthnCeremony, error) {
+	relyingParty, err := ws.relyingParty()
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	// Without a password, the passkey has to verify the user itself, e.g. with a PIN or biometrics
+	options, session, err := relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	ceremony, err := newCeremony(0, options, session)
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	return ceremony, nil
+}
+
+// FinishLogin checks the assertion of a passwordless login and returns the user it logs in.
+//
+// If the ceremony token or the assertion is invalid, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (ws *WebAuthnService) FinishLogin(body models.WebAuthnLoginBody) (*models.User, error) {
+	session, err := parseCeremony(ws.DB, body.CeremonyToken, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	relyingParty, err := ws.relyingParty()
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to log in")
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body.Credential))
+	if err != nil {
+		return nil, webAuthnError(err, "Invalid assertion")
+	}
+
+	var user *webAuthnUser
+	credential, err := relyingParty.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		var err error
+		user, err = ws.loadUser(ws.DB.Where("web_authn_handle = ?", userHandle))
+		return user, err
+	}, *session, parsed)
+	if err != nil {
+		return nil, webAuthnError(err, "Invalid assertion")
+	}
+
+	if err := ws.markUsed(user.user.ID, credential); err != nil {
+		return nil, webAuthnError(err, "Failed to log in")
+	}
+
+	return user.user, nil
+}
+
+// BeginSecondFactor starts checking a hardware key or passkey of a user as the second factor of a login.
+//
+// If the user has no credential, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (ws *WebAuthnService) BeginSecondFactor(userID uint) (*models.WebAuthnCeremony, error) {
+	user, err := ws.loadUser(ws.DB.Where("id = ?", userID))
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	if len(user.credentials) == 0 {
+		return nil, &apperr.NotFoundError{
+			BaseError: &apperr.BaseError{
+				Message: "No security key registered",
+			},
+		}
+	}
+
+	relyingParty, err := ws.relyingParty()
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	options, session, err := relyingParty.BeginLogin(user)
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	ceremony, err := newCeremony(userID, options, session)
+	if err != nil {
+		return nil, webAuthnError(err, "Failed to start login")
+	}
+
+	return ceremony, nil
+}
+
+// FinishSecondFactor checks the assertion of a hardware key or passkey given as second factor.
+//
+// If the ceremony token or the assertion is invalid, it returns an InvalidCredentialsError.
+// If other errors occur, it returns a ServerError.
+func (ws *WebAuthnService) FinishSecondFactor(userID uint, body models.WebAuthnLoginBody) error {
+	session, err := parseCeremony(ws.DB, body.CeremonyToken, userID)
+	if err != nil {
+		return err
+	}
+
+	user, err := ws.loadUser(ws.DB.Where("id = ?", userID))
+	if err != nil {
+		return webAuthnError(err, "Failed to log in")
+	}
+
+	relyingParty, err := ws.relyingParty()
+	if err != nil {
+		return webAuthnError(err, "Failed to log in")
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body.Credential))
+	if err != nil {
+		return webAuthnError(err, "Invalid assertion")
+	}
+
+	credential, err := relyingParty.ValidateLogin(user, *session, parsed)
+	if err != nil {
+		return webAuthnError(err, "Invalid assertion")
+	}
+
+	if err := ws.markUsed(userID, credential); err != nil {
+		return webAuthnError(err, "Failed to log in")
+	}
+
+	return nil
+}
+
+// markUsed saves the sign count of a credential after a login, and when it was used. A sign count
+// that didn't go up means the key may have been cloned, the login is refused.
+func (ws *WebAuthnService) markUsed(userID uint, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return &apperr.InvalidCredentialsError{
+			BaseError: &apperr.BaseError{
+				Message: "Security key may have been cloned",
+			},
+		}
+	}
+
+	var stored models.WebAuthnCredential
+	err := ws.DB.Where("user_id = ? AND credential_hash = ?", userID, hashCredentialID(credential.ID)).First(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	stored.Credential.Authenticator = credential.Authenticator
+	stored.Credential.Flags = credential.Flags
+	now := time.Now()
+	stored.LastUsedAt = &now
+
+	return ws.DB.Model(&stored).Select("credential", "last_used_at").Updates(&stored).Error
+}
+
+// HasCredentials tells whether a user registered a hardware key or passkey.
+func (ws *WebAuthnService) HasCredentials(userID uint) (bool, error) {
+	var count int64
+	err := ws.DB.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count > 0, err
+}
+
+// ListCredentials lists the hardware keys and passkeys of a user, the oldest first.
+//
+// If any errors occur, it returns a ServerError.
+func (ws *WebAuthnService) ListCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	credentials := []models.WebAuthnCredential{}
+	if err := ws.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&credentials).Error; err != nil {
+		return nil, webAuthnError(err, "Failed to list credentials")
+	}
+
+	return credentials, nil
+}
+
+// findCredential returns a credential of a user.
+func (ws *WebAuthnService) findCredential(userID, credentialID uint) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := ws.DB.Where("id = ? AND user_id = ?", credentialID, userID).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apperr.NotFoundError{
+				BaseError: &apperr.BaseError{
+					Message: "Credential not found",
+					Err:     err,
+				},
+			}
+		}
+
+		return nil, webAuthnError(err, "Internal server error ocurred")
+	}
+
+	return &credential, nil
+}
+
+// RenameCredential renames a hardware key or passkey of a user.
+//
+// If the credential is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (ws *WebAuthnService) RenameCredential(userID, credentialID uint, name string) (*models.WebAuthnCredential, error) {
+	credential, err := ws.findCredential(userID, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ws.DB.Model(credential).Update("name", name).Error; err != nil {
+		return nil, webAuthnError(err, "Failed to rename credential")
+	}
+
+	return credential, nil
+}
+
+// DeleteCredential removes a hardware key or passkey of a user.
+//
+// If the credential is not found, it returns a NotFoundError.
+// If other errors occur, it returns a ServerError.
+func (ws *WebAuthnService) DeleteCredential(userID, credentialID uint) error {
+	credential, err := ws.findCredential(userID, credentialID)
+	if err != nil {
+		return err
+	}
+
+	if err := ws.DB.Delete(credential).Error; err != nil {
+		return webAuthnError(err, "Failed to remove credential")
+	}
+
+	return nil
+}
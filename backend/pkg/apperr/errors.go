@@ -27,6 +27,18 @@ type InvalidCredentialsError struct {
 	*BaseError
 }
 
+type QuotaExceededError struct {
+	*BaseError
+}
+
+type ConflictError struct {
+	*BaseError
+}
+
+type ForbiddenError struct {
+	*BaseError
+}
+
 func (e *BaseError) Error() string {
 	if e.Err != nil {
         return fmt.Sprintf("%s: %v", e.Message, e.Err)
@@ -0,0 +1,135 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BACKEND_SMTP = "smtp"
+	BACKEND_FILE = "file"
+	BACKEND_LOG  = "log"
+
+	DEFAULT_SMTP_PORT = "25"
+	DEFAULT_MAIL_DIR  = "mails"
+	DEFAULT_MAIL_FROM = "CloudChest <no-reply@localhost>"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails. Which one is used is picked by FromEnv.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv returns the mailer set by MAIL_BACKEND: "smtp", "file" or "log". The log mailer is
+// used by default, so nothing is sent until a mail backend is configured.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = DEFAULT_MAIL_FROM
+	}
+
+	switch backend := os.Getenv("MAIL_BACKEND"); backend {
+	case BACKEND_SMTP:
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = DEFAULT_SMTP_PORT
+		}
+
+		if os.Getenv("SMTP_HOST") == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required by the smtp mail backend")
+		}
+
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case BACKEND_FILE:
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = DEFAULT_MAIL_DIR
+		}
+
+		return &FileMailer{Dir: dir, From: from}, nil
+	case BACKEND_LOG, "":
+		return &LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", backend)
+	}
+}
+
+// format renders a message with its headers, as sent over SMTP or written to a file.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTPMailer sends emails through an SMTP server. Without a username, no authentication is
+// done, e.g. with a fake SMTP server in development.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// The envelope sender is the bare address, MAIL_FROM may carry a display name
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid MAIL_FROM %q: %w", m.From, err)
+	}
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, from.Address, []string{msg.To}, format(m.From, msg))
+}
+
+// FileMailer writes emails to .eml files in a directory instead of sending them.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644)
+}
+
+// LogMailer logs emails instead of sending them.
+type LogMailer struct{}
+
+func (m *LogMailer) Send(msg Message) error {
+	log.Printf("Email to %s: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+	"md5":     md5.New,
+}
+
+// ParseContentDigest parses a Content-Digest header (RFC 9530), such as
+// `sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:`, into digests by algorithm.
+// Algorithms that aren't supported are left out.
+func ParseContentDigest(header string) (map[string][]byte, error) {
+	digests := map[string][]byte{}
+
+	for _, member := range strings.Split(header, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+
+		algorithm, value, ok := strings.Cut(member, "=")
+		if !ok || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("malformed Content-Digest member: %s", member)
+		}
+
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if _, supported := digestAlgorithms[algorithm]; !supported {
+			continue
+		}
+
+		digest, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s digest: %v", algorithm, err)
+		}
+
+		digests[algorithm] = digest
+	}
+
+	return digests, nil
+}
+
+// VerifyContentDigest checks data against every digest parsed by ParseContentDigest.
+func VerifyContentDigest(digests map[string][]byte, data []byte) error {
+	for algorithm, expected := range digests {
+		h := digestAlgorithms[algorithm]()
+		h.Write(data)
+
+		if !bytes.Equal(h.Sum(nil), expected) {
+			return fmt.Errorf("%s digest does not match the uploaded content", algorithm)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package utils
+
+import "testing"
+
+// Example of RFC 9530, section 2
+const rfcBody = `{"hello": "world"}`
+const rfcDigest = "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+
+func TestContentDigestRFCExample(t *testing.T) {
+	digests, err := ParseContentDigest(rfcDigest)
+	if err != nil {
+		t.Fatalf("ParseContentDigest() error = %v", err)
+	}
+
+	if err := VerifyContentDigest(digests, []byte(rfcBody)); err != nil {
+		t.Errorf("VerifyContentDigest() error = %v", err)
+	}
+
+	if err := VerifyContentDigest(digests, []byte(`{"hello": "world!"}`)); err == nil {
+		t.Error("VerifyContentDigest() accepted a modified body")
+	}
+}
+
+func TestParseContentDigest(t *testing.T) {
+	digests, err := ParseContentDigest("unixsum=:MTIz:, SHA-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+	if err != nil {
+		t.Fatalf("ParseContentDigest() error = %v", err)
+	}
+
+	if len(digests) != 1 || len(digests["sha-256"]) != 32 {
+		t.Errorf("ParseContentDigest() = %v, want the sha-256 digest only", digests)
+	}
+
+	for _, header := range []string{"sha-256", "sha-256=X48E9qOo", "sha-256=:not base64:"} {
+		if _, err := ParseContentDigest(header); err == nil {
+			t.Errorf("ParseContentDigest(%q) accepted a malformed header", header)
+		}
+	}
+}
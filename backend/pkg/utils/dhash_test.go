@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// gradient returns an image getting brighter from left to right, or darker when reversed.
+func gradient(width, height int, reversed bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		level := uint8(x * 255 / (width - 1))
+		if reversed {
+			level = 255 - level
+		}
+		for y := 0; y < height; y++ {
+			img.SetGray(x, y, color.Gray{Y: level})
+		}
+	}
+	return img
+}
+
+func TestDHashGradients(t *testing.T) {
+	if hash := DHash(gradient(90, 80, false)); hash != 0 {
+		t.Errorf("DHash(brightening gradient) = %064b, want all bits unset", hash)
+	}
+	if hash := DHash(gradient(90, 80, true)); hash != ^uint64(0) {
+		t.Errorf("DHash(darkening gradient) = %064b, want all bits set", hash)
+	}
+}
+
+func TestDHashIgnoresScale(t *testing.T) {
+	img := gradient(180, 160, true)
+	resized := imaging.Resize(img, 45, 40, imaging.Lanczos)
+
+	if distance := HammingDistance(DHash(img), DHash(resized)); distance > 2 {
+		t.Errorf("HammingDistance between an image and its downscaled copy = %d, want at most 2", distance)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, ^uint64(0), 64},
+		{0b1010, 0b0101, 4},
+		{0xff00, 0x0ff0, 8},
+	}
+
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HammingDistance(%#x, %#x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const snippetRadius = 60
+
+// HighlightSnippets returns up to maxSnippets excerpts of content around the
+// occurrences of terms. The excerpts are HTML-escaped and every match is
+// wrapped in a <mark> element, so they can be rendered as-is.
+func HighlightSnippets(content string, terms []string, maxSnippets int) []string {
+	pattern := termsPattern(terms)
+	if pattern == nil || content == "" {
+		return []string{}
+	}
+
+	snippets := []string{}
+	lastEnd := 0
+	for _, match := range pattern.FindAllStringIndex(content, -1) {
+		if len(snippets) >= maxSnippets {
+			break
+		}
+
+		// Skip matches that are already part of the previous snippet
+		if match[0] < lastEnd {
+			continue
+		}
+
+		start := runeStart(content, max(match[0]-snippetRadius, 0))
+		end := runeStart(content, min(match[1]+snippetRadius, len(content)))
+
+		excerpt := content[start:end]
+		var sb strings.Builder
+		if start > 0 {
+			sb.WriteString("…")
+		}
+
+		offset := 0
+		for _, m := range pattern.FindAllStringIndex(excerpt, -1) {
+			sb.WriteString(html.EscapeString(excerpt[offset:m[0]]))
+			sb.WriteString("<mark>")
+			sb.WriteString(html.EscapeString(excerpt[m[0]:m[1]]))
+			sb.WriteString("</mark>")
+			offset = m[1]
+		}
+		sb.WriteString(html.EscapeString(excerpt[offset:]))
+
+		if end < len(content) {
+			sb.WriteString("…")
+		}
+
+		snippets = append(snippets, strings.Join(strings.Fields(sb.String()), " "))
+		lastEnd = end
+	}
+
+	return snippets
+}
+
+func termsPattern(terms []string) *regexp.Regexp {
+	var quoted []string
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	return regexp.MustCompile("(?i)(" + strings.Join(quoted, "|") + ")")
+}
+
+// runeStart moves i back to the beginning of the rune it points into.
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// NumberedName returns name with a number appended, "name (n)". With keepExtension,
+// the number goes before the extension, "name (n).ext".
+func NumberedName(name string, n int, keepExtension bool) string {
+	base, extension := name, ""
+	// Dotfiles like ".env" are all name, no extension
+	if keepExtension && filepath.Ext(name) != name {
+		extension = filepath.Ext(name)
+		base = strings.TrimSuffix(name, extension)
+	}
+
+	return fmt.Sprintf("%s (%d)%s", base, n, extension)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name          string
+		n             int
+		keepExtension bool
+		want          string
+	}{
+		{"photo.jpg", 1, true, "photo (1).jpg"},
+		{"archive.tar.gz", 2, true, "archive.tar (2).gz"},
+		{"README", 3, true, "README (3)"},
+		{".env", 1, true, ".env (1)"},
+		{"holiday.2024", 1, false, "holiday.2024 (1)"},
+	}
+
+	for _, tt := range tests {
+		if got := NumberedName(tt.name, tt.n, tt.keepExtension); got != tt.want {
+			t.Errorf("NumberedName(%q, %d, %v) = %q, want %q", tt.name, tt.n, tt.keepExtension, got, tt.want)
+		}
+	}
+}
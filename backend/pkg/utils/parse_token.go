@@ -9,6 +9,8 @@ type UserClaims struct {
 	ID uint `json:"id"`
 	Bucket string
 	ServiceBucket string
+	SessionID uint `json:"sid"`
+	Scope string `json:"scope,omitempty"` // Set when authenticated by a personal access token
 	jwt.StandardClaims
 }
 
@@ -17,4 +17,12 @@ func PruneRevokedTokens(db *gorm.DB) {
 
 	logMsg := fmt.Sprintf("Pruned %d revoked tokens", result.RowsAffected)
 	log.Println(logMsg)
+
+	result = db.Exec("DELETE FROM used_tokens WHERE expires_at < NOW();")
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return
+	}
+
+	log.Printf("Pruned %d used tokens\n", result.RowsAffected)
 }
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	TOKEN_PURPOSE_EMAIL_CHANGE       = "email_change"
+	TOKEN_PURPOSE_EMAIL_VERIFICATION = "email_verification"
+	TOKEN_PURPOSE_PASSWORD_RESET     = "password_reset"
+	TOKEN_PURPOSE_TWO_FACTOR         = "two_factor"
+	TOKEN_PURPOSE_WEBAUTHN           = "webauthn"
+	TOKEN_PURPOSE_OIDC               = "oidc"
+)
+
+// PurposeClaims are the claims of a token sent to a user to prove they own an email address or an
+// account, e.g. in an email. Purpose keeps it from being used for anything else, access tokens included.
+// The jti lets single-use tokens be marked as used.
+type PurposeClaims struct {
+	Purpose string `json:"purpose"`
+	UserID  uint   `json:"uid"`
+	Value   string `json:"value,omitempty"`
+	jwt.StandardClaims
+}
+
+// GeneratePurposeToken signs a token for a purpose, valid for ttl. Value is what the token confirms,
+// e.g. the new email address of an email change.
+func GeneratePurposeToken(purpose string, userID uint, value string, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := PurposeClaims{
+		Purpose: purpose,
+		UserID:  userID,
+		Value:   value,
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(jti),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("TOKEN_SECRET")))
+}
+
+// ParsePurposeToken checks a token signed by GeneratePurposeToken for the given purpose.
+func ParsePurposeToken(token, purpose string) (*PurposeClaims, error) {
+	parsedToken, err := jwt.ParseWithClaims(token, &PurposeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("TOKEN_SECRET")), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := parsedToken.Claims.(*PurposeClaims)
+	if claims.Purpose != purpose {
+		return nil, errors.New("token has another purpose")
+	}
+
+	return claims, nil
+}
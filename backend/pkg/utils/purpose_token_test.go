@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestPurposeTokenRoundTrip(t *testing.T) {
+	t.Setenv("TOKEN_SECRET", "test-secret")
+
+	token, err := GeneratePurposeToken(TOKEN_PURPOSE_EMAIL_CHANGE, 42, "new@example.org", time.Hour)
+	if err != nil {
+		t.Fatalf("GeneratePurposeToken() error = %v", err)
+	}
+
+	claims, err := ParsePurposeToken(token, TOKEN_PURPOSE_EMAIL_CHANGE)
+	if err != nil {
+		t.Fatalf("ParsePurposeToken() error = %v", err)
+	}
+
+	if claims.UserID != 42 || claims.Value != "new@example.org" || claims.Purpose != TOKEN_PURPOSE_EMAIL_CHANGE {
+		t.Errorf("ParsePurposeToken() = %+v, want the claims the token was generated with", claims)
+	}
+}
+
+func TestPurposeTokenRejected(t *testing.T) {
+	t.Setenv("TOKEN_SECRET", "test-secret")
+
+	reset, err := GeneratePurposeToken(TOKEN_PURPOSE_PASSWORD_RESET, 1, "", time.Hour)
+	if err != nil {
+		t.Fatalf("GeneratePurposeToken() error = %v", err)
+	}
+
+	if _, err := ParsePurposeToken(reset, TOKEN_PURPOSE_EMAIL_VERIFICATION); err == nil {
+		t.Error("ParsePurposeToken() accepted a token of another purpose")
+	}
+
+	expired, err := GeneratePurposeToken(TOKEN_PURPOSE_PASSWORD_RESET, 1, "", -time.Minute)
+	if err != nil {
+		t.Fatalf("GeneratePurposeToken() error = %v", err)
+	}
+
+	if _, err := ParsePurposeToken(expired, TOKEN_PURPOSE_PASSWORD_RESET); err == nil {
+		t.Error("ParsePurposeToken() accepted an expired token")
+	}
+
+	t.Setenv("TOKEN_SECRET", "another-secret")
+	if _, err := ParsePurposeToken(reset, TOKEN_PURPOSE_PASSWORD_RESET); err == nil {
+		t.Error("ParsePurposeToken() accepted a token signed with another secret")
+	}
+}
+
+func TestPurposeTokenNotAnAccessToken(t *testing.T) {
+	t.Setenv("TOKEN_SECRET", "test-secret")
+
+	// An access token is signed with the same secret but has no purpose
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	if _, err := ParsePurposeToken(accessToken, TOKEN_PURPOSE_PASSWORD_RESET); err == nil {
+		t.Error("ParsePurposeToken() accepted a token without a purpose")
+	}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret of the SHA-1 test vectors of RFC 6238, appendix B: "12345678901234567890", base32 encoded
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC gives 8 digit codes, authenticator apps use the last 6
+var rfcTOTPVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFCVectors(t *testing.T) {
+	for _, vector := range rfcTOTPVectors {
+		code, err := TOTPCode(rfcTOTPSecret, TOTPStep(time.Unix(vector.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+
+		if code != vector.code {
+			t.Errorf("TOTPCode() at %d = %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	current := TOTPStep(at)
+
+	step, ok := ValidateTOTP(rfcTOTPSecret, "050 471", at)
+	if !ok || step != current {
+		t.Errorf("ValidateTOTP() = %d, %v, want %d, true", step, ok, current)
+	}
+
+	// Lowercase secrets are accepted, as some apps show them
+	if _, ok := ValidateTOTP(strings.ToLower(rfcTOTPSecret), "050471", at); !ok {
+		t.Error("ValidateTOTP() refused a lowercase secret")
+	}
+
+	for _, skew := range []int64{-TOTP_SKEW, TOTP_SKEW} {
+		code, err := TOTPCode(rfcTOTPSecret, current+skew)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+
+		if step, ok := ValidateTOTP(rfcTOTPSecret, code, at); !ok || step != current+skew {
+			t.Errorf("ValidateTOTP() of step %+d = %d, %v, want %d, true", skew, step, ok, current+skew)
+		}
+	}
+
+	tooLate, err := TOTPCode(rfcTOTPSecret, current+TOTP_SKEW+1)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+
+	for _, code := range []string{tooLate, "", "12345", "0504710"} {
+		if _, ok := ValidateTOTP(rfcTOTPSecret, code, at); ok {
+			t.Errorf("ValidateTOTP(%q) accepted an invalid code", code)
+		}
+	}
+}
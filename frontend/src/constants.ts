@@ -1,2 +1,4 @@
 export const FILE_UPDATED = "FILE_UPDATED";
-export const FOLDER_UPDATED = "FOLDER_UPDATED";
\ No newline at end of file
+export const FOLDER_UPDATED = "FOLDER_UPDATED";
+// Number of files or folders requested per page by the listing views
+export const PAGE_SIZE = 50;
@@ -0,0 +1,5 @@
+export interface FilePage<T> {
+  files: T[];
+  total: number;
+  next_cursor: string;
+}
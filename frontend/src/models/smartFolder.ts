@@ -0,0 +1,20 @@
+export interface SearchQuery {
+  query?: string;
+  media_type?: string;
+  min_size?: number;
+  max_size?: number;
+  created_after?: string;
+  created_before?: string;
+  tags?: string[];
+  favorite_only?: boolean;
+}
+
+export default interface SmartFolder {
+  ID: number;
+  UserID: number;
+  Name: string;
+  Code: string;
+  Query: SearchQuery;
+  CreatedAt: Date;
+  UpdatedAt: Date;
+}
@@ -4,6 +4,7 @@ import Dashboard from '../components/Dashboard.vue';
 import Files from '../components/explorer/Files.vue';
 import Favorite from '../components/explorer/Favorite.vue';
 import Trash from '../components/explorer/Trash.vue';
+import SmartFolder from '../components/explorer/SmartFolder.vue';
 import { createWebHistory, createRouter } from 'vue-router';
 import checkTokenValidation from '../utils/checkTokenValidation';
 
@@ -37,6 +38,11 @@ const routes = [
         path: 'trash',
         component: Trash
       },
+      {
+        path: 'smart/:code',
+        component: SmartFolder,
+        name: 'explorer-smart',
+      },
     ]
   },
 ];
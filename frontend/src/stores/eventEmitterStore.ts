@@ -2,6 +2,7 @@ import { defineStore } from "pinia";
 import EventEmitter from "eventemitter3";
 import { CloudChestFile } from "../models/file";
 import type Folder from "../models/folder";
+import type SmartFolder from "../models/smartFolder";
 
 interface Events {
   FILE_UPDATED: (file: CloudChestFile) => void;
@@ -12,6 +13,8 @@ interface Events {
   FOLDER_DELETED_TEMP: (folder: Folder) => void;
   FOLDER_DELETED_PERM: (deletedObjects: {deleted_files: string[], deleted_folders: string[]}) => void;
   FOLDER_ADDED: (folder: Folder) => void;
+  SMART_FOLDER_ADDED: (smartFolder: SmartFolder) => void;
+  SMART_FOLDER_DELETED: (smartFolder: SmartFolder) => void;
 }
 
 class MyEmitter extends EventEmitter<Events> {}
@@ -3,43 +3,53 @@ import { CloudChestFile, type FileResponse } from "../models/file";
 import { type PresignedURL } from "../models/presignedUrl";
 import { useEventEmitterStore } from "../stores/eventEmitterStore";
 import { type FilePatchRequest } from "../models/requestModel";
+import { type FilePage } from "../models/page";
+import { PAGE_SIZE } from "../constants";
 
-export async function getFilesFromCode(folderCode: string): Promise<CloudChestFile[]> {
+export interface FileListPage {
+  files: CloudChestFile[];
+  next_cursor: string;
+}
+
+// Listing endpoints are paginated, fetch a single page and hand next_cursor back to the caller
+export async function fetchFilePage(url: string, params: Record<string, unknown> = {}, cursor: string = ""): Promise<FileListPage> {
+  const response = await axios.get(url, {
+    params: { ...params, limit: PAGE_SIZE, ...(cursor ? { cursor } : {}) }
+  });
+  const page: FilePage<FileResponse> = response.data as FilePage<FileResponse>;
+  return {
+    files: page.files.map((fileResponse) => new CloudChestFile(fileResponse)),
+    next_cursor: page.next_cursor,
+  };
+}
+
+export async function getFilesFromCode(folderCode: string, cursor: string = ""): Promise<FileListPage> {
   try {
-    const response = await axios.get(`/api/folders/${folderCode}/files`, {
-      params: {
-        trashCan: false
-      }
-    });
-    const filesResponse: FileResponse[] = response.data as FileResponse[];
-    const files: CloudChestFile[] = filesResponse.map(
-      (fileResponse) => new CloudChestFile(fileResponse)
-    )!;
-    return files;
+    return await fetchFilePage(`/api/folders/${folderCode}/files`, {
+      trashCan: false
+    }, cursor);
   } catch (error: any) {
     console.error(error);
   }
-  return [];
+  return { files: [], next_cursor: "" };
 }
 
-export async function getTrashCan(): Promise<{files: CloudChestFile[]}> {
+export async function getTrashCan(cursor: string = ""): Promise<FileListPage> {
   try {
-    const response = await axios.get("/api/files/trashcan");
-    return { files: response.data as CloudChestFile[] };
+    return await fetchFilePage("/api/files/trashcan", {}, cursor);
   } catch (error) {
     console.error(error);
   }
-  return { files: [] };
+  return { files: [], next_cursor: "" };
 }
 
-export async function getFavoriteFiles(): Promise<{files: CloudChestFile[]}> {
+export async function getFavoriteFiles(cursor: string = ""): Promise<FileListPage> {
   try {
-    const response = await axios.get("/api/files/favorite");
-    return { files: response.data as CloudChestFile[] };
+    return await fetchFilePage("/api/files/favorite", {}, cursor);
   } catch (error) {
     console.error(error);
   }
-  return { files: [] };
+  return { files: [], next_cursor: "" };
 }
 
 export async function trashFile(file: CloudChestFile, isTrashFile: boolean): Promise<void> {
@@ -3,10 +3,27 @@ import type Folder from "../models/folder";
 import type FolderHierarchy from "../models/folderHierarchy";
 import { useEventEmitterStore } from "../stores/eventEmitterStore";
 import { type FolderPatchRequest } from "../models/requestModel";
+import { PAGE_SIZE } from "../constants";
 
 export interface getFoldersResponse {
     folders: Folder[];
     hierarchies: FolderHierarchy[];
+    total?: number;
+    next_cursor?: string;
+}
+
+// Listing endpoints are paginated, fetch a single page and hand next_cursor back to the caller
+async function fetchFolderPage(url: string, cursor: string = ""): Promise<getFoldersResponse> {
+    const response = await axios.get(url, {
+        params: { limit: PAGE_SIZE, ...(cursor ? { cursor } : {}) }
+    });
+    const page = response.data as getFoldersResponse;
+    return {
+        folders: page.folders ?? [],
+        hierarchies: page.hierarchies ?? [],
+        total: page.total,
+        next_cursor: page.next_cursor ?? "",
+    };
 }
 
 export async function getRootFolder(): Promise<Folder> {
@@ -20,10 +37,9 @@ export async function getRootFolder(): Promise<Folder> {
     return {} as Folder;
 }
 
-export async function getFolderList(folderCode: string): Promise<getFoldersResponse> {
+export async function getFolderList(folderCode: string, cursor: string = ""): Promise<getFoldersResponse> {
     try {
-        const response = await axios.get(`/api/folders/${folderCode}/folders`);
-        return response.data as getFoldersResponse;
+        return await fetchFolderPage(`/api/folders/${folderCode}/folders`, cursor);
     } catch (error) {
         console.error(error);
     }
@@ -34,10 +50,9 @@ export async function getFolderList(folderCode: string): Promise<getFoldersRespo
     } as getFoldersResponse;
 }
 
-export async function getFavoriteFolders(): Promise<getFoldersResponse> {
+export async function getFavoriteFolders(cursor: string = ""): Promise<getFoldersResponse> {
     try {
-        const response = await axios.get(`/api/folders/favorite`);
-        return response.data as getFoldersResponse;
+        return await fetchFolderPage(`/api/folders/favorite`, cursor);
     } catch (error) {
         console.error(error);
     }
@@ -48,15 +63,17 @@ export async function getFavoriteFolders(): Promise<getFoldersResponse> {
     } as getFoldersResponse;
 }
 
-export async function getDeletedFolders(): Promise<getFoldersResponse> {
+export async function getDeletedFolders(cursor: string = ""): Promise<getFoldersResponse> {
     try {
-        const response = await axios.get(`/api/folders/trashcan`);
-        return response.data as getFoldersResponse;
+        return await fetchFolderPage(`/api/folders/trashcan`, cursor);
     } catch (error) {
         console.error(error);
     }
 
-    return {} as getFoldersResponse;
+    return {
+        folders: [],
+        hierarchies: [],
+    } as getFoldersResponse;
 }
 
 export async function createNewFolder(parentFolderCode: string, folderName: string): Promise<void> {
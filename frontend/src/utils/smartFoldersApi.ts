@@ -0,0 +1,54 @@
+import axios from "axios";
+import type SmartFolder from "../models/smartFolder";
+import { type SearchQuery } from "../models/smartFolder";
+import { fetchFilePage, type FileListPage } from "./filesApi";
+import { useEventEmitterStore } from "../stores/eventEmitterStore";
+
+export async function getSmartFolders(): Promise<SmartFolder[]> {
+  try {
+    const response = await axios.get("/api/smart-folders");
+    return response.data as SmartFolder[];
+  } catch (error) {
+    console.error(error);
+  }
+  return [];
+}
+
+export async function getSmartFolder(code: string): Promise<SmartFolder | null> {
+  try {
+    const response = await axios.get(`/api/smart-folders/${code}`);
+    return response.data as SmartFolder;
+  } catch (error) {
+    console.error(error);
+  }
+  return null;
+}
+
+export async function getSmartFolderFiles(code: string, cursor: string = ""): Promise<FileListPage> {
+  try {
+    return await fetchFilePage(`/api/smart-folders/${code}/files`, {}, cursor);
+  } catch (error) {
+    console.error(error);
+  }
+  return { files: [], next_cursor: "" };
+}
+
+export async function createSmartFolder(name: string, query: SearchQuery): Promise<void> {
+  const evStore = useEventEmitterStore();
+  try {
+    const response = await axios.post("/api/smart-folders", { name, query });
+    evStore.getEventEmitter.emit("SMART_FOLDER_ADDED", response.data as SmartFolder);
+  } catch (error) {
+    console.error(error);
+  }
+}
+
+export async function deleteSmartFolder(smartFolder: SmartFolder): Promise<void> {
+  const evStore = useEventEmitterStore();
+  try {
+    await axios.delete(`/api/smart-folders/${smartFolder.Code}`);
+    evStore.getEventEmitter.emit("SMART_FOLDER_DELETED", smartFolder);
+  } catch (error) {
+    console.error(error);
+  }
+}